@@ -1,11 +1,10 @@
 package model
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"project/helper"
+	"slices"
 	"strings"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -17,8 +16,15 @@ type UTXO struct {
 	Vout  VOUT
 }
 
+// BadgerUTXOSet keeps the UTXO set on disk only:
+//
+//	'U' txid varint(idx)     → encodeUTXOValue (see utxocompress.go)
+//	addr:<addr>:<txid>:<idx> → empty, address index
 type BadgerUTXOSet struct {
 	db *badger.DB
+
+	// ownsDB: opened by NewBadgerUTXOSet, closed by Close
+	ownsDB bool
 }
 
 func NewBadgerUTXOSet(path string) (*BadgerUTXOSet, error) {
@@ -28,242 +34,173 @@ func NewBadgerUTXOSet(path string) (*BadgerUTXOSet, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BadgerUTXOSet{db: db}, nil
+	if err := CheckSchemaVersion(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BadgerUTXOSet{db: db, ownsDB: true}, nil
 }
 
-func utxoKey(txid string, index int) []byte {
-	return []byte(fmt.Sprintf("%s:%d", txid, index))
+// NewBadgerUTXOSetFromDB uses an already open db (normally the chain's, so
+// block commits stay atomic); Close leaves it open.
+func NewBadgerUTXOSetFromDB(db *badger.DB) (*BadgerUTXOSet, error) {
+	if err := CheckSchemaVersion(db); err != nil {
+		return nil, err
+	}
+	return &BadgerUTXOSet{db: db}, nil
 }
 
-func serializeVOUT(out VOUT) ([]byte, error) {
-	buf := new(bytes.Buffer)
-
-	// 1) Value int64
-	binary.Write(buf, binary.LittleEndian, out.Value)
-
-	// 2) ScriptPubKey raw bytes (hex → raw)
-	scriptBytes, err := hexToBytes(out.ScriptPubKey.Hex)
+// writeUTXOChange writes one outpoint's final state and its address index
+func writeUTXOChange(w kvWriter, c utxoChange) error {
+	u := c.utxo
+	if !c.exists {
+		if err := w.Delete(makeUTXOKey(u.Txid, u.Index)); err != nil {
+			return err
+		}
+		return writeAddrIndex(w, u, false)
+	}
+	key, val, err := encodeUTXO(u)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// write script length
-	binary.Write(buf, binary.LittleEndian, uint32(len(scriptBytes)))
-	// write script
-	buf.Write(scriptBytes)
-
-	// 3) Address count
-	binary.Write(buf, binary.LittleEndian, uint32(len(out.ScriptPubKey.Addresses)))
-
-	// 4) Write each address as length + bytes
-	for _, addr := range out.ScriptPubKey.Addresses {
-		binary.Write(buf, binary.LittleEndian, uint32(len(addr)))
-		buf.Write([]byte(addr))
+	if err := w.Set(key, val); err != nil {
+		return err
 	}
-
-	return buf.Bytes(), nil
+	return writeAddrIndex(w, u, true)
 }
 
-func deserializeVOUT(data []byte) (VOUT, error) {
-	var v VOUT
-	buf := bytes.NewReader(data)
-
-	// 1) Value
-	binary.Read(buf, binary.LittleEndian, &v.Value)
-
-	// 2) ScriptPubKey bytes
-	var scriptLen uint32
-	binary.Read(buf, binary.LittleEndian, &scriptLen)
-
-	scriptRaw := make([]byte, scriptLen)
-	buf.Read(scriptRaw)
-
-	// store hex form
-	spk := ScriptPubKey{
-		Hex:       bytesToHex(scriptRaw),
-		Addresses: []string{},
-	}
-
-	// 3) Address count
-	var addrCount uint32
-	binary.Read(buf, binary.LittleEndian, &addrCount)
-
-	// 4) Read addresses
-	for i := 0; i < int(addrCount); i++ {
-		var n uint32
-		binary.Read(buf, binary.LittleEndian, &n)
-
-		addr := make([]byte, n)
-		buf.Read(addr)
-
-		spk.Addresses = append(spk.Addresses, string(addr))
+// writeAddrIndex sets or removes u under every one of its addresses
+func writeAddrIndex(w kvWriter, u UTXO, exists bool) error {
+	for _, addr := range u.Vout.ScriptPubKey.Addresses {
+		akey := addrKey(addr, u.Txid, u.Index)
+		var err error
+		if exists {
+			err = w.Set(akey, []byte{})
+		} else {
+			err = w.Delete(akey)
+		}
+		if err != nil {
+			return err
+		}
 	}
-
-	v.ScriptPubKey = spk
-	return v, nil
+	return nil
 }
 
-func (u *BadgerUTXOSet) Put(txid string, index int, out VOUT) error {
-	key := utxoKey(txid, index)
-
-	val, err := serializeVOUT(out)
+// getUTXOTxn reads one output inside txn
+func getUTXOTxn(txn *badger.Txn, txid string, index int) (UTXO, bool, error) {
+	item, err := txn.Get(makeUTXOKey(txid, index))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return UTXO{}, false, nil
+	}
 	if err != nil {
+		return UTXO{}, false, err
+	}
+	var utxo UTXO
+	err = item.Value(func(val []byte) error {
+		utxo, err = decodeUTXO(item.Key(), val)
 		return err
+	})
+	if err != nil {
+		return UTXO{}, false, err
 	}
+	// keep the caller's txid spelling, as the in-memory sets do
+	utxo.Txid = txid
+	return utxo, true, nil
+}
 
-	return u.db.Update(func(txn *badger.Txn) error {
-		if err := txn.Set(key, val); err != nil {
-			return err
+func (u *BadgerUTXOSet) Put(txid string, index int, out VOUT) error {
+	return u.update(func(txn *badger.Txn) ([]utxoChange, error) {
+		_, exists, err := getUTXOTxn(txn, txid, index)
+		if err != nil {
+			return nil, err
 		}
-
-		// index theo địa chỉ
-		if len(out.ScriptPubKey.Addresses) > 0 {
-			addr := out.ScriptPubKey.Addresses[0]
-			akey := addrKey(addr, txid, index)
-			if err := txn.Set(akey, []byte{}); err != nil {
-				return err
-			}
+		if exists {
+			return nil, fmt.Errorf("utxo already exists: %s", viewKey(txid, index))
 		}
-
-		return nil
+		return []utxoChange{{utxo: UTXO{Txid: txid, Index: index, Vout: out}, exists: true}}, nil
 	})
 }
 
 func (u *BadgerUTXOSet) Get(txid string, index int) (UTXO, bool) {
-	key := utxoKey(txid, index)
-
-	var out VOUT
-
+	var utxo UTXO
+	var found bool
 	err := u.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		if err != nil {
-			return err
-		}
-
-		return item.Value(func(val []byte) error {
-			v, err := deserializeVOUT(val)
-			if err != nil {
-				return err
-			}
-			out = v
-			return nil
-		})
+		var err error
+		utxo, found, err = getUTXOTxn(txn, txid, index)
+		return err
 	})
-
-	if err != nil {
-		if err == badger.ErrKeyNotFound {
-			return UTXO{}, false
-		}
+	if err != nil || !found {
 		return UTXO{}, false
 	}
-
-	return UTXO{
-		Txid:  txid,
-		Index: index,
-		Vout:  out,
-	}, true
+	return utxo, true
 }
 
 func (u *BadgerUTXOSet) Delete(txid string, index int) error {
-	key := utxoKey(txid, index)
-
-	return u.db.Update(func(txn *badger.Txn) error {
-		// read current value first to know address (if any)
-		var out VOUT
-		item, err := txn.Get(key)
-		if err == nil {
-			_ = item.Value(func(val []byte) error {
-				v, e := deserializeVOUT(val)
-				if e == nil {
-					out = v
-				}
-				return nil
-			})
-		} else if err != badger.ErrKeyNotFound {
-			// unexpected error
-			return err
+	return u.update(func(txn *badger.Txn) ([]utxoChange, error) {
+		old, exists, err := getUTXOTxn(txn, txid, index)
+		if err != nil {
+			return nil, err
 		}
-
-		// delete UTXO key (ignore ErrKeyNotFound)
-		_ = txn.Delete(key)
-
-		// delete addr index if we had address info
-		if len(out.ScriptPubKey.Addresses) > 0 {
-			akey := addrKey(out.ScriptPubKey.Addresses[0], txid, index)
-			_ = txn.Delete(akey)
+		if !exists {
+			return nil, fmt.Errorf("utxo not found: %s", viewKey(txid, index))
 		}
-
-		return nil
+		return []utxoChange{{utxo: old, exists: false}}, nil
 	})
 }
 
+// UpdateWithTransaction spends tx's inputs and adds its outputs in one Badger
+// transaction: a missing input or existing output changes nothing
 func (u *BadgerUTXOSet) UpdateWithTransaction(tx Transaction) error {
-	// Build lists of deletes and puts
-	type dentry struct {
-		txid string
-		idx  int
-	}
-	type pentry struct {
-		txid string
-		idx  int
-		out  VOUT
-	}
+	return u.update(func(txn *badger.Txn) ([]utxoChange, error) {
+		return txChanges(tx, func(txid string, index int) (UTXO, bool, error) {
+			return getUTXOTxn(txn, txid, index)
+		})
+	})
+}
 
-	var dels []dentry
-	var puts []pentry
+// update writes the changes fn picks, with the set stats, in one transaction
+func (u *BadgerUTXOSet) update(fn func(txn *badger.Txn) ([]utxoChange, error)) error {
+	utxoStatsMu.Lock()
+	defer utxoStatsMu.Unlock()
 
-	for _, vin := range tx.Vin {
-		if vin.Txid != "" {
-			dels = append(dels, dentry{vin.Txid, vin.Vout})
+	return u.db.Update(func(txn *badger.Txn) error {
+		changes, err := fn(txn)
+		if err != nil {
+			return err
 		}
-	}
-	for _, out := range tx.Vout {
-		puts = append(puts, pentry{tx.Txid, out.N, out})
-	}
-
-	// Perform all DB writes in a single transaction (atomic for this tx)
-	err := u.db.Update(func(txn *badger.Txn) error {
-		// deletes
-		for _, d := range dels {
-			k := utxoKey(d.txid, d.idx)
-			// try to read to know address for index deletion
-			item, err := txn.Get(k)
-			if err == nil {
-				_ = item.Value(func(val []byte) error {
-					v, e := deserializeVOUT(val)
-					if e == nil && len(v.ScriptPubKey.Addresses) > 0 {
-						akey := addrKey(v.ScriptPubKey.Addresses[0], d.txid, d.idx)
-						_ = txn.Delete(akey)
-					}
-					return nil
-				})
-			}
-			// delete UTXO key (ignore not found)
-			_ = txn.Delete(k)
+		// before the writes: a scan for missing stats would see them
+		stats, err := loadUTXOStatsTxn(txn)
+		if err != nil {
+			return err
 		}
-
-		// puts
-		for _, p := range puts {
-			k := utxoKey(p.txid, p.idx)
-			val, serr := serializeVOUT(p.out)
-			if serr != nil {
-				return serr
-			}
-			if err := txn.Set(k, val); err != nil {
+		for _, c := range changes {
+			if err := writeUTXOChange(txn, c); err != nil {
 				return err
 			}
-			if len(p.out.ScriptPubKey.Addresses) > 0 {
-				akey := addrKey(p.out.ScriptPubKey.Addresses[0], p.txid, p.idx)
-				if err := txn.Set(akey, []byte{}); err != nil {
-					return err
-				}
-			}
 		}
-
-		return nil
+		if err := stats.apply(changes); err != nil {
+			return err
+		}
+		return txn.Set(utxoStatsKey, stats.marshal())
 	})
+}
+
+// applyCommitted: see utxoCommitTarget
+func (u *BadgerUTXOSet) applyCommitted(changes []utxoChange, db *badger.DB) error {
+	if db == u.db {
+		return nil // already there
+	}
+	var ops opList
+	for _, c := range changes {
+		if err := writeUTXOChange(&ops, c); err != nil {
+			return err
+		}
+	}
+	return writeAtomicWithStats(u.db, ops, changes)
+}
 
-	return err
+func (u *BadgerUTXOSet) FindUTXOsByAddress(addr string) []UTXO {
+	return u.FindByAddress(addr)
 }
 
 // Find UTXO by address (slow scan - OK for toy blockchain)
@@ -287,9 +224,9 @@ func (u *BadgerUTXOSet) FindByAddress(addr string) []UTXO {
 			var voutIndex int
 			fmt.Sscanf(parts[3], "%d", &voutIndex)
 
-			// now fetch real UTXO
+			// now fetch real UTXO (skip stale index entries)
 			utxo, ok := u.Get(txid, voutIndex)
-			if ok {
+			if ok && slices.Contains(utxo.Vout.ScriptPubKey.Addresses, addr) {
 				result = append(result, utxo)
 			}
 		}
@@ -300,15 +237,14 @@ func (u *BadgerUTXOSet) FindByAddress(addr string) []UTXO {
 	return result
 }
 
+// Close closes the DB if NewBadgerUTXOSet opened it
 func (u *BadgerUTXOSet) Close() {
-	u.db.Close()
+	if u.ownsDB {
+		u.db.Close()
+	}
 }
 
 // Helper
-func hexToBytes(h string) ([]byte, error) {
-	return hex.DecodeString(h)
-}
-
 func bytesToHex(b []byte) string {
 	return hex.EncodeToString(b)
 }
@@ -330,18 +266,14 @@ func InitUTXOSet(dbPath string) *CachedUTXOSet {
 	db, err := NewBadgerUTXOSet(dbPath)
 	if err != nil {
 		fmt.Printf("[UTXO] Cannot open DB: %v → using empty cache\n", err)
-		globalUTXOSet = &CachedUTXOSet{
-			db:        nil,
-			Cache:     make(map[string]UTXO),
-			addrIndex: make(map[string][]string),
-		}
+		globalUTXOSet = NewCachedUTXOSet(nil, DefaultUTXOCacheBytes)
 		return globalUTXOSet
 	}
 
 	// Create wrapped cache object
-	c := NewCachedUTXOSet(db)
+	c := NewCachedUTXOSet(db, DefaultUTXOCacheBytes)
 
-	// IMPORTANT: load DB → cache
+	// warm the cache (up to its budget)
 	if err := c.LoadAllFromDB(); err != nil {
 		fmt.Println("Error loading UTXO from DB:", err)
 	}
@@ -350,59 +282,52 @@ func InitUTXOSet(dbPath string) *CachedUTXOSet {
 	return globalUTXOSet
 }
 
-// LoadAllFromDB loads all UTXO entries from Badger into RAM cache
+// LoadAllFromDB warms the cache with DB entries until the memory budget is
+// full. The rest is read on demand by Get.
 func (c *CachedUTXOSet) LoadAllFromDB() error {
 	if c.db == nil {
 		return nil
 	}
 
-	fmt.Println("[UTXO] Loading all UTXOs from DB into RAM...")
+	if err := CheckSchemaVersion(c.db.db); err != nil {
+		return err
+	}
+
+	fmt.Println("[UTXO] Loading UTXOs from DB into RAM cache...")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	return c.db.db.View(func(txn *badger.Txn) error {
 
-		// Iterate prefix "utxo:"
-		prefix := []byte("utxo:")
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		for it.Seek(utxoKeyPrefix); it.ValidForPrefix(utxoKeyPrefix); it.Next() {
 
 			item := it.Item()
-			key := item.Key() // bytes "utxo:<txid>:<index>"
 
 			err := item.Value(func(val []byte) error {
-				out, err := deserializeVOUT(val)
+				utxo, err := decodeUTXO(item.Key(), val)
 				if err != nil {
 					return err
 				}
-
-				// Parse UTXO key
-				txid, idx := helper.ParseUTXOKey(key)
-
-				// Insert into RAM
-				utxo := UTXO{
-					Txid:  txid,
-					Index: idx,
-					Vout:  out,
+				k := string(utxoKey(utxo.Txid, utxo.Index))
+				if _, ok := c.entries[k]; !ok {
+					c.insertCleanLocked(k, utxo)
 				}
-
-				cacheKey := keyOf(txid, idx)
-
-				c.Cache[cacheKey] = utxo
-
-				if len(out.ScriptPubKey.Addresses) > 0 {
-					addr := out.ScriptPubKey.Addresses[0]
-					c.addrIndex[addr] = append(c.addrIndex[addr], cacheKey)
-				}
-
 				return nil
 			})
 
 			if err != nil {
 				return err
 			}
+			if c.usage >= c.maxBytes {
+				break
+			}
 		}
 
+		c.evictLocked()
 		return nil
 	})
 }
@@ -431,9 +356,7 @@ func (b *BadgerUTXOSet) IterateAll(cb func(key []byte, val []byte) error) error
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 
-		prefix := []byte("utxo:")
-
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		for it.Seek(utxoKeyPrefix); it.ValidForPrefix(utxoKeyPrefix); it.Next() {
 			item := it.Item()
 			k := item.KeyCopy(nil)
 
@@ -1,5 +1,18 @@
 package model
 
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// UTXOProvider is the UTXO store the node runs on: UTXOSet (RAM, persisted
+// by the block commit), CachedUTXOSet (LRU cache over Badger) or
+// BadgerUTXOSet (disk only). See NewUTXOProvider.
+//
+// Every implementation behaves the same: Put fails if the output exists,
+// Delete if it does not, and UpdateWithTransaction applies all of a tx or
+// nothing.
 type UTXOProvider interface {
 	Get(txid string, index int) (UTXO, bool)
 	Put(txid string, index int, out VOUT) error
@@ -8,3 +21,116 @@ type UTXOProvider interface {
 	UpdateWithTransaction(tx Transaction) error
 	Close()
 }
+
+// UTXO backends for NewUTXOProvider
+const (
+	UTXOBackendMemory = "memory" // UTXOSet
+	UTXOBackendCached = "cached" // CachedUTXOSet
+	UTXOBackendDisk   = "disk"   // BadgerUTXOSet
+)
+
+// NewUTXOProvider opens the UTXO set stored in db with the given backend.
+// db must be the chain's DB so block commits stay atomic (see UTXOView.flush).
+// cacheBytes is the CachedUTXOSet memory budget.
+func NewUTXOProvider(backend string, db *badger.DB, cacheBytes int64) (UTXOProvider, error) {
+	switch backend {
+	case UTXOBackendMemory:
+		set := NewUTXOSet()
+		if err := set.LoadFromBadger(db); err != nil {
+			return nil, err
+		}
+		return set, nil
+
+	case UTXOBackendCached:
+		disk, err := NewBadgerUTXOSetFromDB(db)
+		if err != nil {
+			return nil, err
+		}
+		cache := NewCachedUTXOSet(disk, cacheBytes)
+		if err := cache.LoadAllFromDB(); err != nil {
+			return nil, err
+		}
+		return cache, nil
+
+	case UTXOBackendDisk:
+		return NewBadgerUTXOSetFromDB(db)
+	}
+	return nil, fmt.Errorf("unknown utxo backend %q (want %s, %s or %s)",
+		backend, UTXOBackendMemory, UTXOBackendCached, UTXOBackendDisk)
+}
+
+// utxoCommitTarget takes the changes of a flushed UTXOView. db is the Badger
+// the changes were just committed to (nil if none): a disk-backed provider
+// on that same db only has to update its memory.
+type utxoCommitTarget interface {
+	applyCommitted(changes []utxoChange, db *badger.DB) error
+}
+
+// utxoWriteBack is a provider holding writes not yet on its db (CachedUTXOSet).
+// A commit takes its stats transitions from what the provider returns, so
+// those writes must reach db first.
+type utxoWriteBack interface {
+	flushBeforeCommit(db *badger.DB) error
+}
+
+func flushBeforeCommit(p UTXOProvider, db *badger.DB) error {
+	if w, ok := p.(utxoWriteBack); ok {
+		return w.flushBeforeCommit(db)
+	}
+	return nil
+}
+
+func applyCommitted(p UTXOProvider, changes []utxoChange, db *badger.DB) error {
+	if t, ok := p.(utxoCommitTarget); ok {
+		return t.applyCommitted(changes, db)
+	}
+	for _, c := range changes {
+		// final state: drop whatever is there, then put
+		err := p.Delete(c.utxo.Txid, c.utxo.Index)
+		if c.exists {
+			err = p.Put(c.utxo.Txid, c.utxo.Index, c.utxo.Vout)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txChanges lists the outpoint changes of applying tx on top of get. Every
+// input must exist and no output may; coinbase inputs are skipped.
+func txChanges(tx Transaction, get func(txid string, index int) (UTXO, bool, error)) ([]utxoChange, error) {
+	changes := make([]utxoChange, 0, len(tx.Vin)+len(tx.Vout))
+	spent := make(map[string]bool, len(tx.Vin))
+
+	for _, vin := range tx.Vin {
+		if vin.Txid == "" {
+			continue
+		}
+		key := viewKey(vin.Txid, vin.Vout)
+		if spent[key] {
+			return nil, fmt.Errorf("input spent twice: %s", key)
+		}
+		old, ok, err := get(vin.Txid, vin.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("utxo not found: %s", key)
+		}
+		spent[key] = true
+		changes = append(changes, utxoChange{utxo: old, exists: false})
+	}
+
+	for i, out := range tx.Vout {
+		_, ok, err := get(tx.Txid, i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, fmt.Errorf("utxo already exists: %s", viewKey(tx.Txid, i))
+		}
+		changes = append(changes, utxoChange{utxo: UTXO{Txid: tx.Txid, Index: i, Vout: out}, exists: true})
+	}
+	return changes, nil
+}
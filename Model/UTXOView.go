@@ -1,55 +1,162 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
 
-type UTXOView struct {
-	// shadow copy: "txid:vout" -> UTXO
-	utxos map[string]UTXO
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// UTXOReader is what a UTXOView reads through to
+type UTXOReader interface {
+	Get(txid string, index int) (UTXO, bool)
 }
 
-func NewUTXOViewFromSet(utxoSet *UTXOSet) *UTXOView {
-	utxoSet.mu.RLock()
-	defer utxoSet.mu.RUnlock()
+// UTXOView is a copy-on-write overlay over a base UTXO set: it records its
+// own additions and spent markers and falls through to base for everything
+// else. Nothing reaches the base until Flush.
+type UTXOView struct {
+	base UTXOReader
 
-	viewMap := make(map[string]UTXO, len(utxoSet.utxos))
-	for k, v := range utxoSet.utxos {
-		viewMap[k] = v
-	}
+	// added: outputs created in the view, "txid:vout" -> UTXO
+	added map[string]UTXO
+
+	// spent: base outputs spent in the view, "txid:vout" -> UTXO
+	spent map[string]UTXO
 
+	// spentLog: every spend in order, base or view (undo data, see CommitBlock)
+	spentLog []UTXO
+}
+
+func NewUTXOView(base UTXOReader) *UTXOView {
 	return &UTXOView{
-		utxos: viewMap,
+		base:  base,
+		added: make(map[string]UTXO),
+		spent: make(map[string]UTXO),
 	}
 }
 
+// NewUTXOViewFromSet: overlay on utxoSet (no copy; kept for existing callers)
+func NewUTXOViewFromSet(utxoSet UTXOProvider) *UTXOView {
+	return NewUTXOView(utxoSet)
+}
+
 func (v *UTXOView) Get(txid string, vout int) (UTXO, bool) {
-	utxo, ok := v.utxos[string(utxoKey(txid, vout))]
-	return utxo, ok
+	key := viewKey(txid, vout)
+	if _, ok := v.spent[key]; ok {
+		return UTXO{}, false
+	}
+	if utxo, ok := v.added[key]; ok {
+		return utxo, true
+	}
+	return v.base.Get(txid, vout)
 }
 
 // Put dùng khi add output mới trong quá trình verify block
 func (v *UTXOView) Put(txid string, vout int, voutData VOUT) error {
-	key := string(utxoKey(txid, vout))
-
-	if _, exists := v.utxos[key]; exists {
-		return fmt.Errorf("utxo already exists in view: %s", key)
+	if _, exists := v.Get(txid, vout); exists {
+		return fmt.Errorf("utxo already exists in view: %s", viewKey(txid, vout))
 	}
 
-	v.utxos[key] = UTXO{
+	v.add(UTXO{
 		Txid:  txid,
 		Index: vout,
 		Vout:  voutData,
-	}
+	})
 	return nil
 }
 
 // Delete dùng khi spend input trong block
 func (v *UTXOView) Delete(txid string, vout int) error {
-	key := string(utxoKey(txid, vout))
+	if _, ok := v.spend(txid, vout); !ok {
+		return fmt.Errorf("utxo not found in view: %s", viewKey(txid, vout))
+	}
+	return nil
+}
+
+// add creates or overwrites an output in the view
+func (v *UTXOView) add(utxo UTXO) {
+	key := viewKey(utxo.Txid, utxo.Index)
+	delete(v.spent, key)
+	v.added[key] = utxo
+}
 
-	if _, exists := v.utxos[key]; !exists {
-		return fmt.Errorf("utxo not found in view: %s", key)
+// spend removes an output from the view and returns it
+func (v *UTXOView) spend(txid string, vout int) (UTXO, bool) {
+	utxo, ok := v.Get(txid, vout)
+	if !ok {
+		return UTXO{}, false
 	}
 
-	delete(v.utxos, key)
+	key := viewKey(txid, vout)
+	if _, created := v.added[key]; created {
+		delete(v.added, key)
+		// it may have replaced a base entry, which is gone as well
+		if old, inBase := v.base.Get(txid, vout); inBase {
+			v.spent[key] = old
+		}
+	} else {
+		v.spent[key] = utxo
+	}
+
+	v.spentLog = append(v.spentLog, utxo)
+	return utxo, true
+}
+
+// Flush writes the view's diff to db atomically (see writeAtomic) and then
+// applies it to utxoSet, which must be the view's base. A failed write leaves
+// both untouched. db may be nil for a memory-only set.
+// The view is empty afterwards.
+func (v *UTXOView) Flush(utxoSet UTXOProvider, db *badger.DB) error {
+	return v.flush(utxoSet, db, nil)
+}
+
+// flush: extra adds its own writes (undo data, best-block marker, chain tip)
+// to the same atomic commit
+func (v *UTXOView) flush(utxoSet UTXOProvider, db *badger.DB, extra func(w kvWriter) error) error {
+	changes := make([]utxoChange, 0, len(v.spent)+len(v.added))
+	for _, utxo := range v.spent {
+		changes = append(changes, utxoChange{utxo: utxo, exists: false})
+	}
+	for _, utxo := range v.added {
+		changes = append(changes, utxoChange{utxo: utxo, exists: true})
+	}
+
+	if db != nil {
+		ops := make(opList, 0, 2*len(changes)+4)
+
+		for _, c := range changes {
+			if err := writeUTXOChange(&ops, c); err != nil {
+				return err
+			}
+		}
+		if extra != nil {
+			if err := extra(&ops); err != nil {
+				return err
+			}
+		}
+
+		// the stats transitions assume db already holds what utxoSet returns
+		if err := flushBeforeCommit(utxoSet, db); err != nil {
+			return err
+		}
+
+		// disk first: memory is only changed once the commit is durable
+		if err := writeAtomicWithStats(db, ops, changes); err != nil {
+			return err
+		}
+	}
+
+	if err := applyCommitted(utxoSet, changes, db); err != nil {
+		return err
+	}
+
+	v.added = make(map[string]UTXO)
+	v.spent = make(map[string]UTXO)
+	v.spentLog = nil
 	return nil
 }
+
+// Size returns the number of outputs added and spent in the view
+func (v *UTXOView) Size() (added, spent int) {
+	return len(v.added), len(v.spent)
+}
@@ -1,245 +1,477 @@
 package model
 
 import (
+	"container/list"
 	"fmt"
 	"sync"
+	"time"
+
+	"project/metrics"
+
+	badger "github.com/dgraph-io/badger/v4"
 )
 
+// DefaultUTXOCacheBytes is the memory budget used by InitUTXOSet
+const DefaultUTXOCacheBytes = 64 << 20
+
+// cacheEntryOverhead: rough per-entry cost of the map slot, list element
+// and struct headers, on top of the string contents
+const cacheEntryOverhead = 192
+
+// CachedUTXOSet is a write-back cache over a BadgerUTXOSet.
+//
+// Clean entries (same as on disk) are kept in LRU order and evicted once the
+// memory budget is exceeded. Put/Delete only mark entries dirty (a Delete
+// leaves a spent marker); nothing reaches Badger until Flush, which writes all
+// dirty entries in one atomic batch and should be called at block boundaries.
+// Dirty entries are never evicted, so the cache can go over budget within a
+// block. With no db every entry stays dirty and the cache is the whole set.
 type CachedUTXOSet struct {
-	db        *BadgerUTXOSet
-	mu        sync.RWMutex
-	Cache     map[string]UTXO
-	addrIndex map[string][]string // address → list of "txid:index"
+	db *BadgerUTXOSet
+	mu sync.Mutex
+
+	maxBytes int64
+	usage    int64
+
+	// entries: utxoKey -> entry, clean and dirty
+	entries map[string]*cacheEntry
+
+	// lru: clean entries only, most recently used at the front
+	lru *list.List
+
+	// dirty: entries not yet flushed
+	dirty map[string]*cacheEntry
+
+	// dirtyAddr: address -> dirty live entries, overlays the disk addr: index
+	dirtyAddr map[string]map[string]struct{}
+
+	// diskGen is bumped whenever our writes reach the DB (Flush, block
+	// commits): a DB read started under an older generation may be stale
+	diskGen uint64
+
+	// readDisk: the DB read of a miss (db.Get), done without c.mu held
+	readDisk func(txid string, index int) (UTXO, bool)
+
+	hits, misses, evictions uint64
+}
+
+type cacheEntry struct {
+	key   string
+	utxo  UTXO // for a spent marker: the output that was spent
+	spent bool
+	dirty bool
+	disk  *UTXO // while dirty: the version on disk, nil if none
+	size  int64
+	elem  *list.Element // set while clean
 }
 
-func NewCachedUTXOSet(db *BadgerUTXOSet) *CachedUTXOSet {
-	return &CachedUTXOSet{
+// CacheStats is a snapshot of the cache counters
+type CacheStats struct {
+	Entries, Dirty          int
+	Bytes, MaxBytes         int64
+	Hits, Misses, Evictions uint64
+}
+
+func NewCachedUTXOSet(db *BadgerUTXOSet, maxBytes int64) *CachedUTXOSet {
+	c := &CachedUTXOSet{
 		db:        db,
-		Cache:     make(map[string]UTXO),
-		addrIndex: make(map[string][]string),
+		maxBytes:  maxBytes,
+		entries:   make(map[string]*cacheEntry),
+		lru:       list.New(),
+		dirty:     make(map[string]*cacheEntry),
+		dirtyAddr: make(map[string]map[string]struct{}),
+	}
+	if db != nil {
+		c.readDisk = db.Get
 	}
+	return c
 }
 
-// key "txid:index"
-func keyOf(txid string, index int) string {
-	return fmt.Sprintf("%s:%d", txid, index)
+func utxoMemSize(key string, u UTXO) int64 {
+	n := cacheEntryOverhead + len(key) + len(u.Txid) +
+		len(u.Vout.ScriptPubKey.Hex) + len(u.Vout.ScriptPubKey.ASM)
+	for _, a := range u.Vout.ScriptPubKey.Addresses {
+		n += 16 + len(a)
+	}
+	return int64(n)
 }
 
 // =======================================================
-// GET — RAM first → DB fallback → push into RAM
+// GET — RAM first → DB fallback → clean entry in the LRU
 // =======================================================
 func (c *CachedUTXOSet) Get(txid string, index int) (UTXO, bool) {
-	k := keyOf(txid, index)
+	k := string(utxoKey(txid, index))
 
-	// RAM first
-	c.mu.RLock()
-	utxo, ok := c.Cache[k]
-	c.mu.RUnlock()
-	if ok {
-		return utxo, true
+	c.mu.Lock()
+	if e, ok := c.entries[k]; ok {
+		c.touchLocked(e)
+		c.hits++
+		c.mu.Unlock()
+		metrics.UTXOCacheLookups.WithLabelValues("hit").Inc()
+		if e.spent {
+			return UTXO{}, false
+		}
+		return e.utxo, true
 	}
+	c.misses++
+	gen := c.diskGen
+	c.mu.Unlock()
+	metrics.UTXOCacheLookups.WithLabelValues("miss").Inc()
 
-	// DB fallback (skip if DB not available)
 	if c.db == nil {
 		return UTXO{}, false
 	}
-
-	dbUtxo, found := c.db.Get(txid, index)
+	dbUtxo, found := c.readDisk(txid, index)
 	if !found {
 		return UTXO{}, false
 	}
 
-	// put into Cache
 	c.mu.Lock()
-	c.Cache[k] = dbUtxo
-	if len(dbUtxo.Vout.ScriptPubKey.Addresses) > 0 {
-		addr := dbUtxo.Vout.ScriptPubKey.Addresses[0]
-		c.addrIndex[addr] = appendMissing(c.addrIndex[addr], k)
+	defer c.mu.Unlock()
+	// a Put/Delete may have landed while we were reading
+	if e, ok := c.entries[k]; ok {
+		if e.spent {
+			return UTXO{}, false
+		}
+		return e.utxo, true
 	}
-	c.mu.Unlock()
-
+	// a commit may have spent it on disk and dropped it from entries: the
+	// read is stale, do it again under the lock and do not cache it
+	if c.diskGen != gen {
+		utxo, found, err := c.lookupLocked(k, txid, index)
+		if err != nil || !found {
+			return UTXO{}, false
+		}
+		return utxo, true
+	}
+	c.insertCleanLocked(k, dbUtxo)
+	c.evictLocked()
 	return dbUtxo, true
 }
 
 // =======================================================
-// PUT — DB first → update RAM
+// PUT / DELETE — dirty in RAM until Flush
 // =======================================================
 func (c *CachedUTXOSet) Put(txid string, index int, out VOUT) error {
-	// Try to update DB if available (for persistence)
-	if c.db != nil {
-		if err := c.db.Put(txid, index, out); err != nil {
-			return err
-		}
+	if _, err := encodeUTXOValue(out); err != nil {
+		return err // fail now rather than at Flush
 	}
-
-	k := keyOf(txid, index)
-	utxo := UTXO{Txid: txid, Index: index, Vout: out}
+	k := string(utxoKey(txid, index))
 
 	c.mu.Lock()
-	c.Cache[k] = utxo
-	if len(out.ScriptPubKey.Addresses) > 0 {
-		addr := out.ScriptPubKey.Addresses[0]
-		c.addrIndex[addr] = appendMissing(c.addrIndex[addr], k)
+	defer c.mu.Unlock()
+	_, exists, err := c.lookupLocked(k, txid, index)
+	if err != nil {
+		return err
 	}
-	c.mu.Unlock()
+	if exists {
+		return fmt.Errorf("utxo already exists: %s", viewKey(txid, index))
+	}
+	c.setDirtyLocked(k, UTXO{Txid: txid, Index: index, Vout: out}, false)
+	return nil
+}
+
+func (c *CachedUTXOSet) Delete(txid string, index int) error {
+	k := string(utxoKey(txid, index))
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the spent marker keeps the output for the address index
+	old, exists, err := c.lookupLocked(k, txid, index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("utxo not found: %s", viewKey(txid, index))
+	}
+	c.setDirtyLocked(k, old, true)
 	return nil
 }
 
 // =======================================================
-// DELETE — DB first → update RAM
+// UPDATE WITH TX — inputs spent, outputs added, all or nothing
 // =======================================================
-func (c *CachedUTXOSet) Delete(txid string, index int) error {
-	// Try to delete from DB if available
-	if c.db != nil {
-		if err := c.db.Delete(txid, index); err != nil {
+func (c *CachedUTXOSet) UpdateWithTransaction(tx Transaction) error {
+	for _, out := range tx.Vout {
+		if _, err := encodeUTXOValue(out); err != nil {
 			return err
 		}
 	}
 
-	k := keyOf(txid, index)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changes, err := txChanges(tx, func(txid string, index int) (UTXO, bool, error) {
+		return c.lookupLocked(string(utxoKey(txid, index)), txid, index)
+	})
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		c.setDirtyLocked(string(utxoKey(ch.utxo.Txid, ch.utxo.Index)), ch.utxo, !ch.exists)
+	}
+	return nil
+}
 
+// applyCommitted: see utxoCommitTarget. Changes already written to our own
+// db become clean entries; anything else is kept dirty for the next Flush.
+func (c *CachedUTXOSet) applyCommitted(changes []utxoChange, db *badger.DB) error {
 	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if utxo, ok := c.Cache[k]; ok {
-		if len(utxo.Vout.ScriptPubKey.Addresses) > 0 {
-			addr := utxo.Vout.ScriptPubKey.Addresses[0]
-			c.addrIndex[addr] = removeKey(c.addrIndex[addr], k)
+	if c.db == nil || db != c.db.db {
+		for _, ch := range changes {
+			c.setDirtyLocked(string(utxoKey(ch.utxo.Txid, ch.utxo.Index)), ch.utxo, !ch.exists)
 		}
+		return nil
 	}
 
-	delete(c.Cache, k)
-
-	c.mu.Unlock()
+	c.diskGen++
+	for _, ch := range changes {
+		k := string(utxoKey(ch.utxo.Txid, ch.utxo.Index))
+		if e, ok := c.entries[k]; ok {
+			c.dropLocked(e)
+		}
+		if ch.exists {
+			c.insertCleanLocked(k, ch.utxo)
+		}
+	}
+	metrics.UTXOCacheDirty.Set(float64(len(c.dirty)))
+	c.evictLocked()
 	return nil
 }
 
+// flushBeforeCommit: see utxoWriteBack. Only a commit to our own db reads
+// the disk under our dirty entries.
+func (c *CachedUTXOSet) flushBeforeCommit(db *badger.DB) error {
+	if c.db == nil || db != c.db.db {
+		return nil
+	}
+	return c.Flush()
+}
+
 // =======================================================
-// UPDATE WITH TX — atomic DB update + RAM update
+// FIND BY ADDRESS — disk index overlaid with dirty entries
 // =======================================================
-func (c *CachedUTXOSet) UpdateWithTransaction(tx Transaction) error {
-
-	// 1) update DB atomically (if DB available)
+// Results from disk are not cached: one address scan should not flush the
+// working set out of the LRU.
+func (c *CachedUTXOSet) FindUTXOsByAddress(addr string) []UTXO {
+	var onDisk []UTXO
 	if c.db != nil {
-		if err := c.db.UpdateWithTransaction(tx); err != nil {
-			return err
-		}
+		onDisk = c.db.FindByAddress(addr)
 	}
 
-	// 2) then update RAM
 	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// remove inputs
-	for _, vin := range tx.Vin {
-		if vin.Txid == "" {
+	var res []UTXO
+	for _, u := range onDisk {
+		// a dirty entry replaces the disk copy (listed below if still addr's)
+		if _, ok := c.dirty[string(utxoKey(u.Txid, u.Index))]; ok {
 			continue
 		}
-		k := keyOf(vin.Txid, vin.Vout)
-		if utxo, ok := c.Cache[k]; ok {
-			if len(utxo.Vout.ScriptPubKey.Addresses) > 0 {
-				addr := utxo.Vout.ScriptPubKey.Addresses[0]
-				c.addrIndex[addr] = removeKey(c.addrIndex[addr], k)
-			}
-		}
-		delete(c.Cache, k)
+		res = append(res, u)
 	}
-
-	// add outputs
-	for _, out := range tx.Vout {
-		k := keyOf(tx.Txid, out.N)
-		u := UTXO{Txid: tx.Txid, Index: out.N, Vout: out}
-		c.Cache[k] = u
-
-		if len(out.ScriptPubKey.Addresses) > 0 {
-			addr := out.ScriptPubKey.Addresses[0]
-			c.addrIndex[addr] = appendMissing(c.addrIndex[addr], k)
-		}
+	for k := range c.dirtyAddr[addr] {
+		res = append(res, c.dirty[k].utxo)
 	}
-
-	c.mu.Unlock()
-	return nil
+	return res
 }
 
 // =======================================================
-// FAST FIND — RAM first (with lock held), then DB fallback
+// FLUSH — one atomic write of every dirty entry
 // =======================================================
-func (c *CachedUTXOSet) FindUTXOsByAddress(addr string) []UTXO {
-
-	// 1) Try RAM index first (fast path)
-	c.mu.RLock()
-	keys, ok := c.addrIndex[addr]
-	c.mu.RUnlock()
-
-	if ok && len(keys) > 0 {
-		res := make([]UTXO, 0, len(keys))
+func (c *CachedUTXOSet) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		c.mu.RLock()
-		for _, k := range keys {
-			if u, ok := c.Cache[k]; ok {
-				res = append(res, u)
+	if c.db == nil || len(c.dirty) == 0 {
+		return nil
+	}
+	start := time.Now()
+
+	ops := make(opList, 0, 3*len(c.dirty))
+	transitions := make([]utxoChange, 0, len(c.dirty))
+	for _, e := range c.dirty {
+		if e.disk != nil {
+			// the value on disk may be indexed under other addresses
+			if err := writeAddrIndex(&ops, *e.disk, false); err != nil {
+				return err
 			}
+			transitions = append(transitions, utxoChange{utxo: *e.disk, exists: false})
 		}
-		c.mu.RUnlock()
+		if err := writeUTXOChange(&ops, utxoChange{utxo: e.utxo, exists: !e.spent}); err != nil {
+			return err
+		}
+		if !e.spent {
+			transitions = append(transitions, utxoChange{utxo: e.utxo, exists: true})
+		}
+	}
+	if err := writeAtomicWithStats(c.db.db, ops, transitions); err != nil {
+		return fmt.Errorf("flush utxo cache: %w", err)
+	}
+	c.diskGen++
 
-		if len(res) > 0 {
-			return res
+	for k, e := range c.dirty {
+		e.dirty, e.disk = false, nil
+		if e.spent {
+			delete(c.entries, k)
+			c.usage -= e.size
+			continue
 		}
+		e.elem = c.lru.PushFront(e)
+	}
+	c.dirty = make(map[string]*cacheEntry)
+	c.dirtyAddr = make(map[string]map[string]struct{})
+	c.evictLocked()
+
+	metrics.ObserveDuration(metrics.UTXOCacheFlushDuration, start)
+	metrics.UTXOCacheDirty.Set(0)
+	return nil
+}
+
+// Stats returns the current cache counters
+func (c *CachedUTXOSet) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:   len(c.entries),
+		Dirty:     len(c.dirty),
+		Bytes:     c.usage,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
 	}
+}
 
-	// 2) Fallback → Scan DB by address
+// Close flushes dirty entries and closes the DB
+func (c *CachedUTXOSet) Close() {
 	if c.db == nil {
-		return nil
+		return
 	}
+	if err := c.Flush(); err != nil {
+		fmt.Println("[UTXO] flush on close failed:", err)
+	}
+	c.db.Close()
+}
 
-	dbUtxos := c.db.FindByAddress(addr)
-	if len(dbUtxos) == 0 {
-		return nil
+// =======================================================
+// HELPERS (c.mu held)
+// =======================================================
+func (c *CachedUTXOSet) touchLocked(e *cacheEntry) {
+	if e.elem != nil {
+		c.lru.MoveToFront(e.elem)
 	}
+}
 
-	// 3) Insert DB results into RAM (rebuild index)
-	c.mu.Lock()
-	for _, u := range dbUtxos {
+func (c *CachedUTXOSet) insertCleanLocked(k string, u UTXO) {
+	e := &cacheEntry{key: k, utxo: u, size: utxoMemSize(k, u)}
+	e.elem = c.lru.PushFront(e)
+	c.entries[k] = e
+	c.usage += e.size
+	metrics.UTXOCacheBytes.Set(float64(c.usage))
+}
+
+// lookupLocked reads k from the cache or, without caching it, from the DB
+func (c *CachedUTXOSet) lookupLocked(k, txid string, index int) (UTXO, bool, error) {
+	if e, ok := c.entries[k]; ok {
+		return e.utxo, !e.spent, nil
+	}
+	if c.db == nil {
+		return UTXO{}, false, nil
+	}
+	var utxo UTXO
+	var found bool
+	err := c.db.db.View(func(txn *badger.Txn) error {
+		var err error
+		utxo, found, err = getUTXOTxn(txn, txid, index)
+		return err
+	})
+	return utxo, found, err
+}
 
-		// RAM key must be "txid:index"
-		k := fmt.Sprintf("%s:%d", u.Txid, u.Index)
+// setDirtyLocked makes k a dirty live entry (u) or spent marker (u = spent
+// output). Callers have checked k against the DB: a new spent marker is for
+// an output on disk, a new live entry for one that is not.
+func (c *CachedUTXOSet) setDirtyLocked(k string, u UTXO, spent bool) {
+	e, ok := c.entries[k]
+	switch {
+	case !ok:
+		e = &cacheEntry{key: k}
+		if spent && c.db != nil {
+			disk := u
+			e.disk = &disk
+		}
+		c.entries[k] = e
+	case !e.dirty:
+		disk := e.utxo
+		e.disk = &disk
+	}
+	if e.elem != nil {
+		c.lru.Remove(e.elem)
+		e.elem = nil
+	}
+	if e.dirty && !e.spent {
+		c.unindexDirtyLocked(e)
+	}
 
-		c.Cache[k] = u
+	c.usage -= e.size
+	e.utxo, e.spent, e.dirty = u, spent, true
+	e.size = utxoMemSize(k, u)
+	c.usage += e.size
 
-		// Extract address from ScriptPubKey (to ensure correct mapping)
-		if len(u.Vout.ScriptPubKey.Addresses) > 0 {
-			realAddr := u.Vout.ScriptPubKey.Addresses[0]
-			c.addrIndex[realAddr] = appendMissing(c.addrIndex[realAddr], k)
+	c.dirty[k] = e
+	if !spent {
+		for _, addr := range u.Vout.ScriptPubKey.Addresses {
+			if _, ok := c.dirtyAddr[addr]; !ok {
+				c.dirtyAddr[addr] = make(map[string]struct{})
+			}
+			c.dirtyAddr[addr][k] = struct{}{}
 		}
 	}
-	c.mu.Unlock()
 
-	return dbUtxos
+	metrics.UTXOCacheDirty.Set(float64(len(c.dirty)))
+	c.evictLocked()
 }
 
-func (c *CachedUTXOSet) Close() {
-	if c.db != nil {
-		c.db.Close()
+// dropLocked removes e from the cache, dirty or not
+func (c *CachedUTXOSet) dropLocked(e *cacheEntry) {
+	if e.elem != nil {
+		c.lru.Remove(e.elem)
+	}
+	if e.dirty {
+		if !e.spent {
+			c.unindexDirtyLocked(e)
+		}
+		delete(c.dirty, e.key)
 	}
+	delete(c.entries, e.key)
+	c.usage -= e.size
 }
 
-// =======================================================
-// HELPERS
-// =======================================================
-func appendMissing(slice []string, k string) []string {
-	for _, s := range slice {
-		if s == k {
-			return slice
+func (c *CachedUTXOSet) unindexDirtyLocked(e *cacheEntry) {
+	for _, addr := range e.utxo.Vout.ScriptPubKey.Addresses {
+		if set, ok := c.dirtyAddr[addr]; ok {
+			delete(set, e.key)
+			if len(set) == 0 {
+				delete(c.dirtyAddr, addr)
+			}
 		}
 	}
-	return append(slice, k)
 }
 
-func removeKey(slice []string, k string) []string {
-	for i, s := range slice {
-		if s == k {
-			slice[i] = slice[len(slice)-1]
-			return slice[:len(slice)-1]
+// evictLocked drops least recently used clean entries until within budget
+func (c *CachedUTXOSet) evictLocked() {
+	for c.usage > c.maxBytes {
+		back := c.lru.Back()
+		if back == nil {
+			break // only dirty entries left
 		}
+		e := c.lru.Remove(back).(*cacheEntry)
+		delete(c.entries, e.key)
+		c.usage -= e.size
+		c.evictions++
+		metrics.UTXOCacheEvictions.Inc()
 	}
-	return slice
+	metrics.UTXOCacheBytes.Set(float64(c.usage))
 }
@@ -1,9 +1,7 @@
 package model
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
 	"sync"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -12,10 +10,10 @@ import (
 type UTXOSet struct {
 	mu sync.RWMutex
 
-	// primary storage: "txid:vout" -> UTXO
+	// primary storage: utxoKey (txid bytes + varint vout) -> UTXO
 	utxos map[string]UTXO
 
-	// secondary index: address -> set("txid:vout")
+	// secondary index: address -> set(utxoKey)
 	addrIndex map[string]map[string]struct{}
 }
 
@@ -107,7 +105,7 @@ func (u *UTXOSet) Delete(txid string, vout int) error {
 
 	utxo, exists := u.utxos[key]
 	if !exists {
-		return fmt.Errorf("utxo not found: %s", key)
+		return fmt.Errorf("utxo not found: %s", viewKey(txid, vout))
 	}
 
 	delete(u.utxos, key)
@@ -133,7 +131,7 @@ func (u *UTXOSet) Put(txid string, vout int, voutData VOUT) error {
 
 	// prevent overwrite
 	if _, exists := u.utxos[key]; exists {
-		return fmt.Errorf("utxo already exists: %s", key)
+		return fmt.Errorf("utxo already exists: %s", viewKey(txid, vout))
 	}
 
 	utxo := UTXO{
@@ -155,11 +153,80 @@ func (u *UTXOSet) Put(txid string, vout int, voutData VOUT) error {
 	return nil
 }
 
-func makeUTXOKey(txid string, vout int) []byte {
-	return []byte("utxo:" + txid + ":" + strconv.Itoa(vout))
+// utxoChange is the final state of one outpoint (see UTXOView.flush)
+type utxoChange struct {
+	utxo   UTXO
+	exists bool
 }
 
+// applyChanges sets or removes each outpoint under one lock
+func (u *UTXOSet) applyChanges(changes []utxoChange) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.applyChangesLocked(changes)
+}
+
+func (u *UTXOSet) applyChangesLocked(changes []utxoChange) {
+	for _, c := range changes {
+		key := string(utxoKey(c.utxo.Txid, c.utxo.Index))
+
+		if old, ok := u.utxos[key]; ok {
+			delete(u.utxos, key)
+			for _, addr := range old.Vout.ScriptPubKey.Addresses {
+				if set, ok := u.addrIndex[addr]; ok {
+					delete(set, key)
+					if len(set) == 0 {
+						delete(u.addrIndex, addr)
+					}
+				}
+			}
+		}
+		if !c.exists {
+			continue
+		}
+
+		u.utxos[key] = c.utxo
+		for _, addr := range c.utxo.Vout.ScriptPubKey.Addresses {
+			if _, ok := u.addrIndex[addr]; !ok {
+				u.addrIndex[addr] = make(map[string]struct{})
+			}
+			u.addrIndex[addr][key] = struct{}{}
+		}
+	}
+}
+
+// applyCommitted: see utxoCommitTarget. The set is memory only, so db has
+// nothing left to do.
+func (u *UTXOSet) applyCommitted(changes []utxoChange, db *badger.DB) error {
+	u.applyChanges(changes)
+	return nil
+}
+
+// UpdateWithTransaction spends tx's inputs and adds its outputs under one
+// lock: a missing input or existing output changes nothing
+func (u *UTXOSet) UpdateWithTransaction(tx Transaction) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	changes, err := txChanges(tx, func(txid string, index int) (UTXO, bool, error) {
+		utxo, ok := u.utxos[string(utxoKey(txid, index))]
+		return utxo, ok, nil
+	})
+	if err != nil {
+		return err
+	}
+	u.applyChangesLocked(changes)
+	return nil
+}
+
+// Close: nothing to release, the DB belongs to the caller
+func (u *UTXOSet) Close() {}
+
 func (u *UTXOSet) LoadFromBadger(db *badger.DB) error {
+	if err := CheckSchemaVersion(db); err != nil {
+		return err
+	}
+
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
@@ -170,9 +237,7 @@ func (u *UTXOSet) LoadFromBadger(db *badger.DB) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 
-		prefix := []byte("utxo:")
-
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		for it.Seek(utxoKeyPrefix); it.ValidForPrefix(utxoKeyPrefix); it.Next() {
 			item := it.Item()
 
 			val, err := item.ValueCopy(nil)
@@ -180,15 +245,9 @@ func (u *UTXOSet) LoadFromBadger(db *badger.DB) error {
 				return err
 			}
 
-			// Try binary deserialization first (new format)
-			utxo, err := deserializeUTXOBinary(val)
+			utxo, err := decodeUTXO(item.Key(), val)
 			if err != nil {
-				// Fallback to JSON for backward compatibility (old data)
-				var jsonUTXO UTXO
-				if err := json.Unmarshal(val, &jsonUTXO); err != nil {
-					return fmt.Errorf("failed to deserialize UTXO (binary and json): %v", err)
-				}
-				utxo = jsonUTXO
+				return err
 			}
 
 			key := string(utxoKey(utxo.Txid, utxo.Index))
@@ -207,68 +266,3 @@ func (u *UTXOSet) LoadFromBadger(db *badger.DB) error {
 
 	return err
 }
-
-func (u *UTXOSet) PutWithDB(
-	db *badger.DB,
-	txid string,
-	vout int,
-	voutData VOUT,
-) error {
-
-	if err := u.Put(txid, vout, voutData); err != nil {
-		return err
-	}
-
-	utxo := UTXO{
-		Txid:  txid,
-		Index: vout,
-		Vout:  voutData,
-	}
-
-	data, _ := json.Marshal(utxo)
-
-	return db.Update(func(txn *badger.Txn) error {
-		return txn.Set(makeUTXOKey(txid, vout), data)
-	})
-}
-
-func (u *UTXOSet) DeleteWithDB(
-	db *badger.DB,
-	txid string,
-	vout int,
-) error {
-
-	if err := u.Delete(txid, vout); err != nil {
-		return err
-	}
-
-	return db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(makeUTXOKey(txid, vout))
-	})
-}
-
-func CommitBlockWithDB(
-	block *Block,
-	utxoSet *UTXOSet,
-	db *badger.DB,
-) error {
-
-	for _, tx := range block.Transactions {
-
-		for _, vin := range tx.Vin {
-			if vin.Txid != "" {
-				if err := utxoSet.DeleteWithDB(db, vin.Txid, vin.Vout); err != nil {
-					return err
-				}
-			}
-		}
-
-		for i, out := range tx.Vout {
-			if err := utxoSet.PutWithDB(db, tx.Txid, i, out); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
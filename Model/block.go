@@ -3,12 +3,11 @@ package model
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
-	"os"
 	"sync"
 	"time"
 
+	badger "github.com/dgraph-io/badger/v4"
 	"github.com/minio/sha256-simd"
 )
 
@@ -19,84 +18,234 @@ type Block struct {
 	Transactions []Transaction
 	PrevHash     []byte
 	Hash         []byte
-	Nonce        int
+	Bits         uint32 // compact difficulty target
+	Nonce        uint32
 	MerkleRoot   []byte
 	Size         int
+
+	// Height is not part of the header; it is committed by the coinbase
+	Height int32
 }
 
 type Blockchain struct {
 	mu           sync.Mutex
 	Blocks       []*Block
 	CurrentBlock *Block
+	Params       *ChainParams
+
+	// db persists blocks (see blockstore.go); nil = in-memory only
+	db *badger.DB
+
+	// index holds every known block by hash, side branches included
+	// (see blockindex.go); Blocks is the active chain through it
+	index map[string]*blockNode
+
+	// TxOrphans, when set, get their parents' confirmations: ProcessBlock
+	// promotes them into the mempool (see OrphanPool.PromoteForBlock)
+	TxOrphans *OrphanPool
 }
 
 func (bc *Blockchain) AddBlock(txs []Transaction) {
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(txs, prevBlock.Hash)
+	newBlock := NewBlock(txs, prevBlock.Hash, prevBlock.Height+1)
 	bc.Blocks = append(bc.Blocks, newBlock)
 }
 
-func NewBlock(txs []Transaction, prevHash []byte) *Block {
+func NewBlock(txs []Transaction, prevHash []byte, height int32) *Block {
 	block := &Block{
 		Timestamp:    time.Now().Unix(),
 		Transactions: txs,
 		PrevHash:     prevHash,
 		Nonce:        0,
+		Height:       height,
 	}
 	block.MerkleRoot = ComputeMerkleRoot(txs)
 	block.Hash = block.BlockHash()
 	return block
 }
 
-func NewGenesisBlock() *Block {
-	return NewBlock([]Transaction{}, []byte{})
+// NewGenesisBlock: empty block at the easiest target (not mined, never validated).
+// Timestamp comes from params so every node derives the same genesis hash.
+func NewGenesisBlock(params *ChainParams) *Block {
+	genesis := NewBlock([]Transaction{}, []byte{}, 0)
+	genesis.Timestamp = params.GenesisTimestamp
+	genesis.Bits = params.PowLimitBits
+	genesis.Hash = genesis.BlockHash()
+	return genesis
 }
 
-func NewBlockchain() *Blockchain {
-	genesis := NewGenesisBlock()
+// NewBlockchain reloads the active chain from db, or starts a new one from
+// genesis (stored immediately). db may be nil for an in-memory chain.
+func NewBlockchain(params *ChainParams, db *badger.DB) (*Blockchain, error) {
 	bc := &Blockchain{
-		Blocks:       []*Block{genesis},
-		CurrentBlock: NewBlock([]Transaction{}, genesis.Hash),
+		Params: params,
+		db:     db,
 	}
-	return bc
+
+	if db != nil {
+		if err := CheckSchemaVersion(db); err != nil {
+			return nil, err
+		}
+		replayed, err := RecoverChainState(db)
+		if err != nil {
+			return nil, fmt.Errorf("recover chain state: %v", err)
+		}
+		if replayed {
+			fmt.Println("[chain] replayed an interrupted commit")
+		}
+
+		blocks, err := loadActiveChain(db)
+		if err != nil {
+			return nil, fmt.Errorf("load chain: %v", err)
+		}
+		bc.Blocks = blocks
+	}
+
+	if len(bc.Blocks) == 0 {
+		genesis := NewGenesisBlock(params)
+		if db != nil {
+			// genesis spends and creates nothing: the empty UTXO set is at genesis
+			if err := db.Update(func(txn *badger.Txn) error {
+				if err := storeBlockTxn(txn, genesis); err != nil {
+					return err
+				}
+				return txn.Set(utxoBestKey, genesis.Hash)
+			}); err != nil {
+				return nil, fmt.Errorf("store genesis: %v", err)
+			}
+		}
+		bc.Blocks = []*Block{genesis}
+	} else if !bytes.Equal(bc.Blocks[0].Hash, NewGenesisBlock(params).Hash) {
+		return nil, fmt.Errorf("stored chain has a different genesis")
+	}
+
+	if err := bc.buildIndex(); err != nil {
+		return nil, fmt.Errorf("build block index: %v", err)
+	}
+
+	tip := bc.Tip()
+	bc.CurrentBlock = NewBlock([]Transaction{}, tip.Hash, tip.Height+1)
+	return bc, nil
 }
 
-func (bc *Blockchain) AddTransactionToBlock(tx Transaction) error {
+// AppendBlock persists block as the new tip and appends it to Blocks.
+// The block must already be validated and committed to the UTXO set.
+func (bc *Blockchain) AppendBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.appendBlockLocked(block)
+}
 
+func (bc *Blockchain) appendBlockLocked(block *Block) error {
+	if !bytes.Equal(block.PrevHash, bc.Tip().Hash) {
+		return fmt.Errorf("block does not extend tip")
+	}
+	if bc.db != nil {
+		if err := bc.db.Update(func(txn *badger.Txn) error {
+			return storeBlockTxn(txn, block)
+		}); err != nil {
+			return fmt.Errorf("store block: %v", err)
+		}
+	}
+	bc.linkBlockLocked(block)
+	return nil
+}
+
+// linkBlockLocked appends block to the in-memory active chain
+func (bc *Blockchain) linkBlockLocked(block *Block) {
+	bc.Blocks = append(bc.Blocks, block)
+	if _, ok := bc.index[string(block.Hash)]; !ok {
+		bc.addNodeLocked(block)
+	}
+}
+
+// DisconnectTip reverts the tip block in utxoSet (via its undo data) and
+// makes its parent the tip. Returns the removed block.
+func (bc *Blockchain) DisconnectTip(utxoSet UTXOProvider) (*Block, error) {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	cb := bc.CurrentBlock
+	tip, err := bc.disconnectTipLocked(utxoSet)
+	if err != nil {
+		return nil, err
+	}
+	bc.resetCurrentBlockLocked()
+	return tip, nil
+}
 
-	if cb.CurrentSize()+tx.Size() > MaxBlockSizeBytes {
-		return fmt.Errorf("current block full, must finalize first")
+// disconnectTipLocked: the block stays in the index as a side branch
+func (bc *Blockchain) disconnectTipLocked(utxoSet UTXOProvider) (*Block, error) {
+	if bc.db == nil {
+		return nil, fmt.Errorf("disconnect needs a db (undo data)")
+	}
+	if len(bc.Blocks) < 2 {
+		return nil, fmt.Errorf("cannot disconnect genesis")
 	}
 
-	cb.Transactions = append(cb.Transactions, tx)
-	cb.Size += tx.Size()
+	// UTXO changes and the chain tip move in one commit
+	tip := bc.Tip()
+	if err := disconnectBlock(tip, utxoSet, bc.db, func(w kvWriter) error {
+		return unlinkTipTxn(w, tip)
+	}); err != nil {
+		return nil, err
+	}
 
-	return nil
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	return tip, nil
+}
+
+// resetCurrentBlockLocked starts an empty CurrentBlock on the tip
+func (bc *Blockchain) resetCurrentBlockLocked() {
+	tip := bc.Tip()
+	bc.CurrentBlock = NewBlock([]Transaction{}, tip.Hash, tip.Height+1)
+}
+
+// Tip returns the last block of the chain
+func (bc *Blockchain) Tip() *Block {
+	return bc.Blocks[len(bc.Blocks)-1]
 }
 
-func (bc *Blockchain) FinalizeCurrentBlock(
-	utxoSet *UTXOSet,
-) error {
+// TxLocation: where a tx is confirmed on the active chain
+type TxLocation struct {
+	Height int32
+	Index  int // position in the block
+}
+
+// LocateTxs walks the active chain down from the tip until every txid is
+// found; txids not on it are left out of the result
+func (bc *Blockchain) LocateTxs(txids []string) map[string]TxLocation {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	cb := bc.CurrentBlock
-
-	if err := VerifyBlock(cb, utxoSet); err != nil {
-		bc.CurrentBlock = NewBlock([]Transaction{}, bc.Blocks[len(bc.Blocks)-1].Hash)
-		return err
+	want := make(map[string]bool, len(txids))
+	for _, txid := range txids {
+		want[txid] = true
 	}
+	found := make(map[string]TxLocation, len(want))
+	for h := len(bc.Blocks) - 1; h >= 0 && len(found) < len(want); h-- {
+		b := bc.Blocks[h]
+		for i := range b.Transactions {
+			if txid := b.Transactions[i].Txid; want[txid] {
+				found[txid] = TxLocation{Height: b.Height, Index: i}
+			}
+		}
+	}
+	return found
+}
 
-	cb.MerkleRoot = ComputeMerkleRoot(cb.Transactions)
-	cb.Hash = cb.BlockHash()
+func (bc *Blockchain) AddTransactionToBlock(tx Transaction) error {
 
-	bc.Blocks = append(bc.Blocks, cb)
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 
-	bc.CurrentBlock = NewBlock([]Transaction{}, cb.Hash)
+	cb := bc.CurrentBlock
+
+	if cb.CurrentSize()+tx.Size() > MaxBlockSizeBytes {
+		return fmt.Errorf("current block full, must finalize first")
+	}
+
+	cb.Transactions = append(cb.Transactions, tx)
+	cb.Size += tx.Size()
 
 	return nil
 }
@@ -120,11 +269,11 @@ func (b *Block) SerializeHeader() []byte {
 	// timestamp
 	binary.Write(buf, binary.LittleEndian, uint32(b.Timestamp))
 
-	// bits (difficulty compact) — set 0 for now
-	binary.Write(buf, binary.LittleEndian, uint32(0))
+	// bits (difficulty compact)
+	binary.Write(buf, binary.LittleEndian, b.Bits)
 
-	// nonce
-	binary.Write(buf, binary.LittleEndian, uint32(b.Nonce))
+	// nonce (must stay last, Solve patches it in place)
+	binary.Write(buf, binary.LittleEndian, b.Nonce)
 
 	return buf.Bytes()
 }
@@ -150,39 +299,21 @@ func (b *Block) BlockHash() []byte {
 
 // Global blockchain instance (singleton)
 var globalBlockchain *Blockchain
-var blockchainFilepath string
 
 // InitBlockchain - Initialize blockchain singleton once at startup
-// Load from file if exists, otherwise create new
-func InitBlockchain(filepath string) *Blockchain {
+// Reload from db if a chain is stored, otherwise create new
+func InitBlockchain(db *badger.DB, params *ChainParams) (*Blockchain, error) {
 	if globalBlockchain != nil {
-		return globalBlockchain // Already initialized
+		return globalBlockchain, nil // Already initialized
 	}
 
-	// blockchainFilepath = filepath
-
-	// // Try to load from file
-	// data, err := os.ReadFile(filepath)
-	// if err != nil {
-	// 	// File not exist, create new blockchain
-	// 	globalBlockchain = NewBlockchain()
-	// 	return globalBlockchain
-	// }
-
-	var blocks []*Block
-	// if err := json.Unmarshal(data, &blocks); err != nil {
-	// 	// Parse error, create new blockchain
-	// 	globalBlockchain = NewBlockchain()
-	// 	return globalBlockchain
-	// }
-
-	if len(blocks) == 0 {
-		globalBlockchain = NewBlockchain()
-		return globalBlockchain
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		return nil, err
 	}
 
-	globalBlockchain = &Blockchain{Blocks: blocks}
-	return globalBlockchain
+	globalBlockchain = bc
+	return globalBlockchain, nil
 }
 
 // GetBlockchain - Get the global blockchain instance
@@ -193,15 +324,3 @@ func GetBlockchain() *Blockchain {
 	}
 	return globalBlockchain
 }
-
-// SaveBlockchain - Save current blockchain to file
-func SaveBlockchain() error {
-	if globalBlockchain == nil {
-		return fmt.Errorf("blockchain not initialized")
-	}
-	data, err := json.MarshalIndent(globalBlockchain.Blocks, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(blockchainFilepath, data, 0644)
-}
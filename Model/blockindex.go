@@ -0,0 +1,134 @@
+package model
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+var (
+	ErrDuplicateBlock = errors.New("block already known")
+	ErrOrphanBlock    = errors.New("parent block unknown")
+	ErrInvalidParent  = errors.New("parent block is invalid")
+
+	// ErrBlockInvalid: the block failed validation, as opposed to failing
+	// to be stored; only such blocks are marked invalid
+	ErrBlockInvalid = errors.New("block invalid")
+)
+
+// blockNode is one entry of the block index: every known block, on the
+// active chain or on a side branch, linked to its parent.
+type blockNode struct {
+	block  *Block
+	parent *blockNode
+
+	// work is the cumulative work of the branch ending at this block
+	work *big.Int
+
+	// invalid is set when the block failed validation; descendants are rejected
+	invalid bool
+}
+
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+	work := CalcWork(block.Bits)
+	if parent != nil {
+		work.Add(work, parent.work)
+	}
+	return &blockNode{block: block, parent: parent, work: work}
+}
+
+// ancestor walks back to the node at height on this branch (nil if above n)
+func (n *blockNode) ancestor(height int32) *blockNode {
+	for n != nil && n.block.Height > height {
+		n = n.parent
+	}
+	if n == nil || n.block.Height != height {
+		return nil
+	}
+	return n
+}
+
+// ancestorBlock is an AncestorFunc for the branch ending at n
+func (n *blockNode) ancestorBlock(height int32) *Block {
+	if a := n.ancestor(height); a != nil {
+		return a.block
+	}
+	return nil
+}
+
+// findFork returns the last common block of the branches ending at a and b
+func findFork(a, b *blockNode) *blockNode {
+	if a.block.Height > b.block.Height {
+		a = a.ancestor(b.block.Height)
+	} else {
+		b = b.ancestor(a.block.Height)
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+	return a
+}
+
+// addNodeLocked indexes block under its (already indexed) parent
+func (bc *Blockchain) addNodeLocked(block *Block) *blockNode {
+	node := newBlockNode(block, bc.index[string(block.PrevHash)])
+	bc.index[string(block.Hash)] = node
+	return node
+}
+
+// tipNode is the index entry of the active chain tip
+func (bc *Blockchain) tipNode() *blockNode {
+	return bc.index[string(bc.Tip().Hash)]
+}
+
+// buildIndex indexes the active chain, then any stored side-branch blocks
+func (bc *Blockchain) buildIndex() error {
+	bc.index = make(map[string]*blockNode)
+	for _, b := range bc.Blocks {
+		bc.addNodeLocked(b)
+	}
+	if bc.db == nil {
+		return nil
+	}
+
+	side, err := loadSideBlocks(bc.db, bc.index)
+	if err != nil {
+		return err
+	}
+	for _, b := range side {
+		if _, ok := bc.index[string(b.PrevHash)]; ok {
+			bc.addNodeLocked(b)
+		}
+	}
+	return nil
+}
+
+// loadSideBlocks returns stored blocks not in known, ordered by height so
+// parents come before children
+func loadSideBlocks(db *badger.DB, known map[string]*blockNode) ([]*Block, error) {
+	var side []*Block
+
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(blockKeyPrefix); it.ValidForPrefix(blockKeyPrefix); it.Next() {
+			hash := it.Item().Key()[len(blockKeyPrefix):]
+			if _, ok := known[string(hash)]; ok {
+				continue
+			}
+			b, err := loadBlockTxn(txn, append([]byte{}, hash...))
+			if err != nil {
+				return err
+			}
+			side = append(side, b)
+		}
+		return nil
+	})
+
+	sort.SliceStable(side, func(i, j int) bool { return side[i].Height < side[j].Height })
+	return side, err
+}
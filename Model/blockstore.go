@@ -0,0 +1,190 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"project/helper"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Badger layout for blocks (shares the DB with the UTXO set):
+//
+//	block:<hash>        → Block.Serialize()
+//	height:<u32 BE>     → hash of the active-chain block at that height
+//	chain:tip           → hash of the best block
+var (
+	blockKeyPrefix  = []byte("block:")
+	heightKeyPrefix = []byte("height:")
+	chainTipKey     = []byte("chain:tip")
+)
+
+const blockHeaderSize = 80
+
+func blockKey(hash []byte) []byte {
+	return append(append([]byte{}, blockKeyPrefix...), hash...)
+}
+
+func heightKey(height int32) []byte {
+	k := append([]byte{}, heightKeyPrefix...)
+	return binary.BigEndian.AppendUint32(k, uint32(height))
+}
+
+// Serialize: header (80 bytes) | height (4 bytes LE) | varint tx count | txs (Transaction.Serialize)
+func (b *Block) Serialize() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(b.SerializeHeader())
+	binary.Write(buf, binary.LittleEndian, uint32(b.Height))
+
+	helper.WriteVarInt(buf, uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		buf.Write(b.Transactions[i].Serialize())
+	}
+	return buf.Bytes()
+}
+
+// DeserializeBlock is the inverse of Block.Serialize; Hash and Size are recomputed
+func DeserializeBlock(data []byte) (*Block, error) {
+	r := bytes.NewReader(data)
+
+	header := make([]byte, blockHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("truncated block header")
+	}
+
+	if version := binary.LittleEndian.Uint32(header[0:4]); version != 1 {
+		return nil, fmt.Errorf("unsupported block version %d", version)
+	}
+
+	b := &Block{
+		PrevHash:   append([]byte{}, header[4:36]...),
+		MerkleRoot: append([]byte{}, header[36:68]...),
+		Timestamp:  int64(binary.LittleEndian.Uint32(header[68:72])),
+		Bits:       binary.LittleEndian.Uint32(header[72:76]),
+		Nonce:      binary.LittleEndian.Uint32(header[76:80]),
+	}
+	// genesis has no parent: stored padded, restore as empty like NewGenesisBlock
+	if bytes.Equal(b.PrevHash, make([]byte, 32)) {
+		b.PrevHash = []byte{}
+	}
+
+	var height uint32
+	if err := binary.Read(r, binary.LittleEndian, &height); err != nil {
+		return nil, fmt.Errorf("truncated block height")
+	}
+	b.Height = int32(height)
+
+	count, err := readCount(r, 10) // smallest tx: version + 2 counts + locktime
+	if err != nil {
+		return nil, fmt.Errorf("tx count: %w", err)
+	}
+
+	b.Transactions = make([]Transaction, count)
+	for i := range b.Transactions {
+		tx, err := readTransaction(r)
+		if err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+		b.Transactions[i] = *tx
+		b.Size += tx.Size()
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after block: %d bytes", r.Len())
+	}
+
+	b.Hash = b.BlockHash()
+	return b, nil
+}
+
+// storeBlockTxn writes the block and makes it the tip of the active chain
+func storeBlockTxn(w kvWriter, b *Block) error {
+	if err := w.Set(blockKey(b.Hash), b.Serialize()); err != nil {
+		return err
+	}
+	if err := w.Set(heightKey(b.Height), b.Hash); err != nil {
+		return err
+	}
+	return w.Set(chainTipKey, b.Hash)
+}
+
+// unlinkTipTxn makes tip's parent the tip; the block itself stays stored
+func unlinkTipTxn(w kvWriter, tip *Block) error {
+	if err := w.Delete(heightKey(tip.Height)); err != nil {
+		return err
+	}
+	return w.Set(chainTipKey, tip.PrevHash)
+}
+
+func loadBlockTxn(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(blockKey(hash))
+	if err != nil {
+		return nil, fmt.Errorf("block %x: %w", hash, err)
+	}
+	var b *Block
+	err = item.Value(func(val []byte) error {
+		var derr error
+		b, derr = DeserializeBlock(val)
+		return derr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("block %x: %w", hash, err)
+	}
+	if !bytes.Equal(b.Hash, hash) {
+		return nil, fmt.Errorf("block %x: stored data hashes to %x", hash, b.Hash)
+	}
+	return b, nil
+}
+
+// loadActiveChain reads blocks 0..tip via the height index.
+// Returns nil, nil when the DB has no chain yet.
+func loadActiveChain(db *badger.DB) ([]*Block, error) {
+	var blocks []*Block
+
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(chainTipKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tipHash, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		tip, err := loadBlockTxn(txn, tipHash)
+		if err != nil {
+			return err
+		}
+
+		blocks = make([]*Block, tip.Height+1)
+		blocks[tip.Height] = tip
+
+		for h := tip.Height - 1; h >= 0; h-- {
+			item, err := txn.Get(heightKey(h))
+			if err != nil {
+				return fmt.Errorf("height %d: %w", h, err)
+			}
+			hash, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			b, err := loadBlockTxn(txn, hash)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(blocks[h+1].PrevHash, b.Hash) {
+				return fmt.Errorf("height index broken at %d", h)
+			}
+			blocks[h] = b
+		}
+		return nil
+	})
+
+	return blocks, err
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func openTestDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := CheckSchemaVersion(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBlockSerializeRoundTrip(t *testing.T) {
+	tx, _, _ := newSpendFixture(t)
+	coinbase := NewCoinbaseTx(7, "0102030405060708090a0b0c0d0e0f1011121314", 50, 3)
+	b := newTestBlock(t, []Transaction{coinbase, *tx}, []byte{}, 7, 0x207fffff)
+	b.Size = coinbase.Size() + tx.Size()
+
+	decoded, err := DeserializeBlock(b.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, b) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", decoded, b)
+	}
+
+	if _, err := DeserializeBlock(append(b.Serialize(), 0)); err == nil {
+		t.Fatal("trailing data accepted")
+	}
+}
+
+func TestBlockchainReloadsFromDB(t *testing.T) {
+	db := openTestDB(t)
+	params := RegtestChainParams()
+
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for h := int32(1); h <= 3; h++ {
+		cb := NewCoinbaseTx(h, "0102030405060708090a0b0c0d0e0f1011121314", params.BlockSubsidy(h), 0)
+		b := newTestBlock(t, []Transaction{cb}, bc.Tip().Hash, h, params.PowLimitBits)
+		b.Size = cb.Size()
+		if err := bc.AppendBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Blocks) != 4 {
+		t.Fatalf("reloaded %d blocks, want 4", len(reloaded.Blocks))
+	}
+	for i := range bc.Blocks {
+		if !reflect.DeepEqual(reloaded.Blocks[i], bc.Blocks[i]) {
+			t.Fatalf("block %d differs after reload", i)
+		}
+	}
+
+	// a different genesis must not silently adopt the stored chain
+	other := RegtestChainParams()
+	other.GenesisTimestamp++
+	if _, err := NewBlockchain(other, db); err == nil {
+		t.Fatal("chain with foreign genesis accepted")
+	}
+}
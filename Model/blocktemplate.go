@@ -0,0 +1,367 @@
+package model
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// packageTotals: count, serialized size and fees of a set of txs
+type packageTotals struct {
+	count int
+	size  int
+	fees  int64
+}
+
+func (p packageTotals) plus(q packageTotals) packageTotals {
+	return packageTotals{p.count + q.count, p.size + q.size, p.fees + q.fees}
+}
+
+// feeRate in fee units per byte
+func (p packageTotals) feeRate() float64 {
+	if p.size == 0 {
+		return 0
+	}
+	return float64(p.fees) / float64(p.size)
+}
+
+// mempoolEntry: a pool tx with its fee and its in-pool relatives
+type mempoolEntry struct {
+	tx   *Transaction
+	size int
+	fee  int64
+	seq  uint64 // arrival order: parents always have a lower seq than children
+
+	added time.Time
+
+	parents  map[string]struct{} // in-pool txs it spends
+	children map[string]struct{} // in-pool txs spending it
+
+	// anc: the tx itself plus all its in-pool ancestors
+	anc packageTotals
+}
+
+func newMempoolEntry(tx *Transaction, fee int64, seq uint64) *mempoolEntry {
+	return &mempoolEntry{
+		tx:       tx,
+		size:     tx.Size(),
+		fee:      fee,
+		seq:      seq,
+		parents:  make(map[string]struct{}),
+		children: make(map[string]struct{}),
+	}
+}
+
+// link connects e, already in entries, to its in-pool parents and sets e.anc
+func (e *mempoolEntry) link(entries map[string]*mempoolEntry) {
+	for _, vin := range e.tx.Vin {
+		if p, ok := entries[vin.Txid]; ok && p != e {
+			e.parents[vin.Txid] = struct{}{}
+			p.children[e.tx.Txid] = struct{}{}
+		}
+	}
+	e.anc = ancestorTotals(e, entries)
+}
+
+func (e *mempoolEntry) own() packageTotals {
+	return packageTotals{1, e.size, e.fee}
+}
+
+// ancestorTotals recomputes e.anc; the parents' anc must be current.
+// A single parent (the common chain case) costs O(1), several a walk of the
+// ancestor set, as shared ancestors may only be counted once.
+func ancestorTotals(e *mempoolEntry, entries map[string]*mempoolEntry) packageTotals {
+	switch len(e.parents) {
+	case 0:
+		return e.own()
+	case 1:
+		for p := range e.parents {
+			return entries[p].anc.plus(e.own())
+		}
+	}
+
+	total := e.own()
+	seen := make(map[string]bool)
+	stack := make([]string, 0, len(e.parents))
+	for p := range e.parents {
+		stack = append(stack, p)
+	}
+	for len(stack) > 0 {
+		txid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[txid] {
+			continue
+		}
+		seen[txid] = true
+		a := entries[txid]
+		total = total.plus(a.own())
+		for p := range a.parents {
+			stack = append(stack, p)
+		}
+	}
+	return total
+}
+
+// descendantTotals: e itself plus all its in-pool descendants
+func descendantTotals(e *mempoolEntry, entries map[string]*mempoolEntry) packageTotals {
+	var total packageTotals
+	seen := make(map[string]bool)
+	stack := []*mempoolEntry{e}
+	for len(stack) > 0 {
+		d := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[d.tx.Txid] {
+			continue
+		}
+		seen[d.tx.Txid] = true
+		total = total.plus(d.own())
+		for c := range d.children {
+			stack = append(stack, entries[c])
+		}
+	}
+	return total
+}
+
+// maxTemplateFailures: packages in a row that may fail to fit before the
+// template is considered full
+const maxTemplateFailures = 1000
+
+// maxTemplateDescendantUpdates: descendants of a selected tx re-scored right
+// away (Bitcoin Core's default descendant limit); deeper ones are re-scored
+// when reached, which keeps long chains cheap
+const maxTemplateDescendantUpdates = 25
+
+// modifiedEntry: a tx some of whose ancestors are selected, scored by what
+// is left of its package (Bitcoin Core's mapModifiedTx)
+type modifiedEntry struct {
+	e     *mempoolEntry
+	anc   packageTotals
+	index int // in modifiedHeap
+}
+
+// compareScore: higher package fee rate first, then arrival order
+func compareScore(a *mempoolEntry, ancA packageTotals, b *mempoolEntry, ancB packageTotals) int {
+	ra, rb := ancA.feeRate(), ancB.feeRate()
+	switch {
+	case ra > rb:
+		return -1
+	case ra < rb:
+		return 1
+	}
+	return compareSeq(a, b)
+}
+
+// modifiedHeap: best score on top (container/heap)
+type modifiedHeap []*modifiedEntry
+
+func (h modifiedHeap) Len() int { return len(h) }
+func (h modifiedHeap) Less(i, j int) bool {
+	return compareScore(h[i].e, h[i].anc, h[j].e, h[j].anc) < 0
+}
+func (h modifiedHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *modifiedHeap) Push(x any) {
+	m := x.(*modifiedEntry)
+	m.index = len(*h)
+	*h = append(*h, m)
+}
+func (h *modifiedHeap) Pop() any {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
+
+// templateBuilder: state of one buildBlockTemplate run
+type templateBuilder struct {
+	entries  map[string]*mempoolEntry
+	selected map[string]bool
+	failed   map[string]bool
+
+	modified map[string]*modifiedEntry
+	heap     modifiedHeap
+}
+
+// score: e's package totals as far as the builder knows
+func (b *templateBuilder) score(e *mempoolEntry) packageTotals {
+	if m, ok := b.modified[e.tx.Txid]; ok {
+		return m.anc
+	}
+	return e.anc
+}
+
+func (b *templateBuilder) setModified(e *mempoolEntry, anc packageTotals) {
+	if m, ok := b.modified[e.tx.Txid]; ok {
+		m.anc = anc
+		heap.Fix(&b.heap, m.index)
+		return
+	}
+	m := &modifiedEntry{e: e, anc: anc}
+	b.modified[e.tx.Txid] = m
+	heap.Push(&b.heap, m)
+}
+
+func (b *templateBuilder) dropModified(txid string) {
+	if m, ok := b.modified[txid]; ok {
+		heap.Remove(&b.heap, m.index)
+		delete(b.modified, txid)
+	}
+}
+
+// updateDescendants takes the just selected s out of the scores of its
+// descendants, nearest first, up to maxTemplateDescendantUpdates of them
+func (b *templateBuilder) updateDescendants(s *mempoolEntry) {
+	seen := map[string]bool{s.tx.Txid: true}
+	queue := []*mempoolEntry{s}
+	updated := 0
+	for len(queue) > 0 && updated < maxTemplateDescendantUpdates {
+		d := queue[0]
+		queue = queue[1:]
+		for c := range d.children {
+			if seen[c] || updated >= maxTemplateDescendantUpdates {
+				continue
+			}
+			seen[c] = true
+			child := b.entries[c]
+			queue = append(queue, child)
+			if b.selected[c] || b.failed[c] {
+				continue
+			}
+			anc := b.score(child)
+			b.setModified(child, packageTotals{anc.count - 1, anc.size - s.size, anc.fees - s.fee})
+			updated++
+		}
+	}
+}
+
+// buildBlockTemplate selects txs for a block of at most maxBytes.
+//
+// Candidates are taken by ancestor fee rate (the tx plus its not yet selected
+// in-pool ancestors), best first; a package that does not fit is skipped and
+// smaller ones keep filling the space. Each package goes in in arrival order,
+// so parents always precede their children.
+//
+// As in Bitcoin Core, a selected package is taken out of the scores of its
+// descendants, which are re-ranked in a heap of modified entries. Only the
+// nearest maxTemplateDescendantUpdates descendants of each tx are updated;
+// a candidate found with a stale score is re-scored and put back instead of
+// selected.
+func buildBlockTemplate(entries map[string]*mempoolEntry, maxBytes int) MempoolSnapshot {
+	cands := make([]*mempoolEntry, 0, len(entries))
+	for _, e := range entries {
+		// a package larger than the block never fits, whatever gets
+		// selected before it
+		if e.anc.size <= maxBytes {
+			cands = append(cands, e)
+		}
+	}
+	slices.SortFunc(cands, func(a, b *mempoolEntry) int {
+		return compareScore(a, a.anc, b, b.anc)
+	})
+
+	b := &templateBuilder{
+		entries:  entries,
+		selected: make(map[string]bool),
+		failed:   make(map[string]bool),
+		modified: make(map[string]*modifiedEntry),
+	}
+	var snap MempoolSnapshot
+	failures := 0
+	next := 0
+
+	for {
+		// the best of the sorted candidates (skipping those re-scored in
+		// the heap) and of the heap
+		for next < len(cands) {
+			txid := cands[next].tx.Txid
+			if !b.selected[txid] && !b.failed[txid] && b.modified[txid] == nil {
+				break
+			}
+			next++
+		}
+		var e *mempoolEntry
+		switch {
+		case len(b.heap) > 0 && (next == len(cands) ||
+			compareScore(b.heap[0].e, b.heap[0].anc, cands[next], cands[next].anc) < 0):
+			e = b.heap[0].e
+		case next < len(cands):
+			e = cands[next]
+		default:
+			return snap
+		}
+
+		pkg, ok := unselectedPackage(e, entries, b.selected, maxBytes-snap.Size)
+		if !ok {
+			// the room only shrinks: it will not fit later either
+			b.failed[e.tx.Txid] = true
+			b.dropModified(e.tx.Txid)
+			failures++
+			if failures >= maxTemplateFailures {
+				return snap
+			}
+			continue
+		}
+
+		var total packageTotals
+		for _, p := range pkg {
+			total = total.plus(p.own())
+		}
+		if total != b.score(e) {
+			// an ancestor was selected past the descendant update limit
+			b.setModified(e, total)
+			continue
+		}
+		failures = 0
+
+		slices.SortFunc(pkg, compareSeq)
+		for _, p := range pkg {
+			b.selected[p.tx.Txid] = true
+			b.dropModified(p.tx.Txid)
+			snap.TxIDs = append(snap.TxIDs, p.tx.Txid)
+			snap.Size += p.size
+			snap.Fees += p.fee
+		}
+		for _, p := range pkg {
+			b.updateDescendants(p)
+		}
+	}
+}
+
+// unselectedPackage: e and its ancestors not in selected; false if their
+// size exceeds room
+func unselectedPackage(
+	e *mempoolEntry,
+	entries map[string]*mempoolEntry,
+	selected map[string]bool,
+	room int,
+) ([]*mempoolEntry, bool) {
+	var pkg []*mempoolEntry
+	size := 0
+	seen := make(map[string]bool)
+	stack := []*mempoolEntry{e}
+	for len(stack) > 0 {
+		a := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[a.tx.Txid] || selected[a.tx.Txid] {
+			continue
+		}
+		seen[a.tx.Txid] = true
+
+		size += a.size
+		if size > room {
+			return nil, false
+		}
+		pkg = append(pkg, a)
+		for p := range a.parents {
+			stack = append(stack, entries[p])
+		}
+	}
+	return pkg, true
+}
+
+func compareSeq(a, b *mempoolEntry) int {
+	return cmp.Compare(a.seq, b.seq)
+}
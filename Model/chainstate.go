@@ -0,0 +1,248 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"project/helper"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Chain state keys:
+//
+//	chainstate:best      → hash of the block the on-disk UTXO set belongs to,
+//	                       written with every UTXO commit
+//	chainstate:journal   → pending commit too big for one Badger transaction
+//	chainstate:utxostats → UTXO set stats and MuHash, see utxostats.go
+var (
+	utxoBestKey      = []byte("chainstate:best")
+	commitJournalKey = []byte("chainstate:journal")
+)
+
+// kvWriter is satisfied by *badger.Txn and *badger.WriteBatch
+type kvWriter interface {
+	Set(key, val []byte) error
+	Delete(key []byte) error
+}
+
+type kvOp struct {
+	key, val []byte
+	del      bool
+}
+
+// opList collects writes so they can be committed as one unit
+type opList []kvOp
+
+func (l *opList) Set(key, val []byte) error {
+	*l = append(*l, kvOp{key: key, val: val})
+	return nil
+}
+
+func (l *opList) Delete(key []byte) error {
+	*l = append(*l, kvOp{key: key, del: true})
+	return nil
+}
+
+func applyOps(w kvWriter, ops []kvOp) error {
+	for _, op := range ops {
+		var err error
+		if op.del {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Set(op.key, op.val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeOps: varint count | per op: del byte | varint key len | key | varint val len | val
+func encodeOps(ops []kvOp) []byte {
+	buf := new(bytes.Buffer)
+	helper.WriteVarInt(buf, uint64(len(ops)))
+	for _, op := range ops {
+		if op.del {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		helper.WriteVarInt(buf, uint64(len(op.key)))
+		buf.Write(op.key)
+		helper.WriteVarInt(buf, uint64(len(op.val)))
+		buf.Write(op.val)
+	}
+	return buf.Bytes()
+}
+
+func decodeOps(data []byte) ([]kvOp, error) {
+	r := bytes.NewReader(data)
+	count, err := readCount(r, 3)
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	ops := make([]kvOp, count)
+	for i := range ops {
+		flag, err := r.ReadByte()
+		if err != nil || flag > 1 {
+			return nil, fmt.Errorf("journal op %d: bad flag", i)
+		}
+		ops[i].del = flag == 1
+		if ops[i].key, err = readScript(r); err != nil {
+			return nil, fmt.Errorf("journal op %d key: %w", i, err)
+		}
+		if ops[i].val, err = readScript(r); err != nil {
+			return nil, fmt.Errorf("journal op %d value: %w", i, err)
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("journal: %w", ErrTxTrailingData)
+	}
+	return ops, nil
+}
+
+// writeAtomic commits ops all-or-nothing. Normally that is one Badger
+// transaction; a commit too big for that is first written whole to the
+// journal key, then applied in batches and the journal removed. Ops are plain
+// sets/deletes, so replaying a journal after a crash is safe
+// (see RecoverChainState).
+func writeAtomic(db *badger.DB, ops []kvOp) error {
+	err := db.Update(func(txn *badger.Txn) error {
+		return applyOps(txn, ops)
+	})
+	if !errors.Is(err, badger.ErrTxnTooBig) {
+		return err
+	}
+
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set(commitJournalKey, encodeOps(ops))
+	}); err != nil {
+		return fmt.Errorf("write commit journal: %w", err)
+	}
+	return applyJournal(db, ops)
+}
+
+func applyJournal(db *badger.DB, ops []kvOp) error {
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+
+	if err := applyOps(batch, ops); err != nil {
+		return err
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(commitJournalKey)
+	})
+}
+
+// RecoverChainState completes a commit that was interrupted after its journal
+// was written. Must run before the UTXO set is loaded; NewBlockchain calls it.
+// Returns true if a journal was replayed.
+func RecoverChainState(db *badger.DB) (bool, error) {
+	var journal []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(commitJournalKey)
+		if err != nil {
+			return err
+		}
+		journal, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ops, err := decodeOps(journal)
+	if err != nil {
+		return false, err
+	}
+	if err := applyJournal(db, ops); err != nil {
+		return false, fmt.Errorf("replay commit journal: %w", err)
+	}
+	return true, nil
+}
+
+// readUTXOBest returns the block the on-disk UTXO set belongs to (nil if unset)
+func readUTXOBest(db *badger.DB) ([]byte, error) {
+	var best []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(utxoBestKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		best, err = item.ValueCopy(nil)
+		return err
+	})
+	return best, err
+}
+
+// RecoverUTXOSet brings utxoSet (freshly loaded from the DB) to the chain tip.
+//
+// The UTXO set and the chain tip are committed together, so they only differ
+// after a crash between separate writes (e.g. CommitBlock then AppendBlock)
+// or with a DB from before the marker existed. The set is rewound with undo
+// data to the fork point and the active chain replayed from stored blocks.
+func (bc *Blockchain) RecoverUTXOSet(utxoSet UTXOProvider) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.db == nil {
+		return nil
+	}
+
+	best, err := readUTXOBest(bc.db)
+	if err != nil {
+		return err
+	}
+	tip := bc.tipNode()
+
+	if best == nil {
+		fmt.Printf("[chain] no UTXO best-block marker, assuming height %d\n", tip.block.Height)
+		return bc.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(utxoBestKey, tip.block.Hash)
+		})
+	}
+	if bytes.Equal(best, tip.block.Hash) {
+		return nil
+	}
+
+	node, ok := bc.index[string(best)]
+	if !ok {
+		return fmt.Errorf("utxo set belongs to unknown block %x", best)
+	}
+	fork := findFork(node, tip)
+
+	for n := node; n != fork; n = n.parent {
+		if err := disconnectBlock(n.block, utxoSet, bc.db, nil); err != nil {
+			return fmt.Errorf("recover: %v", err)
+		}
+	}
+
+	var path []*blockNode
+	for n := tip; n != fork; n = n.parent {
+		path = append([]*blockNode{n}, path...)
+	}
+	for _, n := range path {
+		view := NewUTXOView(utxoSet)
+		if err := VerifyBlockWithView(n.block, view, bc.Params); err != nil {
+			return fmt.Errorf("recover: block %x: %v", n.block.Hash, err)
+		}
+		if err := commitBlockView(n.block, view, utxoSet, bc.db, nil); err != nil {
+			return fmt.Errorf("recover: block %x: %v", n.block.Hash, err)
+		}
+	}
+
+	fmt.Printf("[chain] recovered UTXO set: rewound %d, replayed %d blocks to height %d\n",
+		node.block.Height-fork.block.Height, len(path), tip.block.Height)
+	return nil
+}
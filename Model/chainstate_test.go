@@ -0,0 +1,160 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestWriteAtomicFallsBackToJournal(t *testing.T) {
+	// small memtable so the commit cannot fit one transaction
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil).WithMemTableSize(1 << 20).WithValueThreshold(1 << 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var ops opList
+	for i := 0; i < 20000; i++ {
+		ops.Set([]byte(fmt.Sprintf("k%06d", i)), bytes.Repeat([]byte{byte(i)}, 100))
+	}
+
+	err = db.Update(func(txn *badger.Txn) error { return applyOps(txn, ops) })
+	if !errors.Is(err, badger.ErrTxnTooBig) {
+		t.Fatalf("test setup: expected ErrTxnTooBig, got %v", err)
+	}
+
+	if err := writeAtomic(db, ops); err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(txn *badger.Txn) error {
+		for _, op := range ops {
+			if _, err := txn.Get(op.key); err != nil {
+				return fmt.Errorf("%s: %v", op.key, err)
+			}
+		}
+		if _, err := txn.Get(commitJournalKey); !errors.Is(err, badger.ErrKeyNotFound) {
+			return fmt.Errorf("journal left behind: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverChainStateReplaysJournal(t *testing.T) {
+	db := openTestDB(t)
+
+	// crash after the journal was written, before it was applied
+	var ops opList
+	ops.Set([]byte("utxo:a"), []byte{1})
+	ops.Delete([]byte("utxo:b"))
+	ops.Set(utxoBestKey, []byte{0xbe})
+	if err := db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("utxo:b"), []byte{2}); err != nil {
+			return err
+		}
+		return txn.Set(commitJournalKey, encodeOps(ops))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := RecoverChainState(db)
+	if err != nil || !replayed {
+		t.Fatalf("replayed=%v err=%v", replayed, err)
+	}
+
+	err = db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte("utxo:a")); err != nil {
+			return fmt.Errorf("set not replayed: %v", err)
+		}
+		if _, err := txn.Get([]byte("utxo:b")); !errors.Is(err, badger.ErrKeyNotFound) {
+			return fmt.Errorf("delete not replayed: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, _ := readUTXOBest(db); !bytes.Equal(best, []byte{0xbe}) {
+		t.Fatalf("best marker = %x", best)
+	}
+
+	if replayed, err := RecoverChainState(db); err != nil || replayed {
+		t.Fatalf("second recovery: replayed=%v err=%v", replayed, err)
+	}
+}
+
+func TestRecoverUTXOSetBehindTip(t *testing.T) {
+	db := openTestDB(t)
+	params := RegtestChainParams()
+
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoSet := NewUTXOSet()
+
+	_, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	for h := int32(1); h <= 3; h++ {
+		cb := NewCoinbaseTx(h, addr, params.BlockSubsidy(h), 0)
+		b := newTestBlock(t, []Transaction{cb}, bc.Tip().Hash, h, params.PowLimitBits)
+		if _, err := bc.ProcessBlock(b, utxoSet, nil); err != nil {
+			t.Fatal(err)
+		}
+		if best, _ := readUTXOBest(db); !bytes.Equal(best, b.Hash) {
+			t.Fatalf("best marker not committed with block %d", h)
+		}
+	}
+	tip := bc.Tip()
+	want := loadSetFromDB(t, db)
+
+	// UTXO set rewound two blocks without moving the chain tip, as if the
+	// process died between two separate writes
+	if err := DisconnectBlock(tip, utxoSet, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := DisconnectBlock(bc.Blocks[2], utxoSet, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart
+	reloaded, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewUTXOSet()
+	if err := loaded.LoadFromBadger(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.RecoverUTXOSet(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if best, _ := readUTXOBest(db); !bytes.Equal(best, tip.Hash) {
+		t.Fatal("best marker not back at tip")
+	}
+	if !equalUTXOKeys(loadSetFromDB(t, db), want) {
+		t.Fatal("on-disk utxo set not repaired")
+	}
+	if _, ok := loaded.Get(tip.Transactions[0].Txid, 0); !ok {
+		t.Fatal("in-memory set missing the tip coinbase")
+	}
+}
+
+func equalUTXOKeys(a, b map[string]UTXO) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, u := range a {
+		if w, ok := b[k]; !ok || w.Vout.Value != u.Vout.Value {
+			return false
+		}
+	}
+	return true
+}
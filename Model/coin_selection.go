@@ -0,0 +1,238 @@
+package model
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// SelectionParams describes the payment being funded.
+// Sizes are serialized bytes (Transaction.Size) so fees match CreateTransaction.
+type SelectionParams struct {
+	Amount  int64
+	FeeRate int64 // units per byte
+
+	InputSize   int // one signed input
+	PaymentSize int // payment output
+	ChangeSize  int // change output
+
+	Dust int64
+}
+
+// TxSize returns the serialized size of a tx with nIn signed inputs
+func (p SelectionParams) TxSize(nIn int, withChange bool) int {
+	nOut := 1
+	outBytes := p.PaymentSize
+	if withChange {
+		nOut++
+		outBytes += p.ChangeSize
+	}
+	// version + vin count + inputs + vout count + outputs + locktime
+	return 4 + varIntSize(nIn) + nIn*p.InputSize + varIntSize(nOut) + outBytes + 4
+}
+
+func (p SelectionParams) Fee(nIn int, withChange bool) int64 {
+	return p.FeeRate * int64(p.TxSize(nIn, withChange))
+}
+
+// CoinSelection is the result of a selector: inputs, fee and change (0 = no change output)
+type CoinSelection struct {
+	Inputs []UTXO
+	Fee    int64
+	Change int64
+}
+
+// CoinSelector picks inputs for a payment.
+// utxos arrive oldest first (see Wallet.GetSpendableUTXOs); implementations must be
+// deterministic for the same input.
+type CoinSelector interface {
+	SelectCoins(utxos []UTXO, p SelectionParams) (CoinSelection, error)
+}
+
+// DefaultCoinSelector is used when a wallet has no Selector set
+var DefaultCoinSelector CoinSelector = BranchAndBoundSelector{Fallback: LargestFirstSelector{}}
+
+// finish decides change/no-change for a set of inputs totalling total.
+// Returns false if inputs don't cover amount + fee.
+func (p SelectionParams) finish(inputs []UTXO, total int64) (CoinSelection, bool) {
+	n := len(inputs)
+	if total < p.Amount+p.Fee(n, false) {
+		return CoinSelection{}, false
+	}
+
+	withChange := p.Fee(n, true)
+	if rest := total - p.Amount - withChange; rest >= p.Dust {
+		return CoinSelection{Inputs: inputs, Fee: withChange, Change: rest}, true
+	}
+
+	// change would be dust → give it to the miner
+	return CoinSelection{Inputs: inputs, Fee: total - p.Amount}, true
+}
+
+// accumulate adds utxos in the given order until the payment is funded
+func accumulate(utxos []UTXO, p SelectionParams) (CoinSelection, error) {
+	var picked []UTXO
+	var total int64
+	for _, u := range utxos {
+		picked = append(picked, u)
+		total += u.Vout.Value
+		if sel, ok := p.finish(picked, total); ok {
+			return sel, nil
+		}
+	}
+	return CoinSelection{}, ErrInsufficientFunds
+}
+
+// sortedCopy sorts by value (asc/desc) with outpoint tie-break for determinism
+func sortedCopy(utxos []UTXO, desc bool) []UTXO {
+	out := make([]UTXO, len(utxos))
+	copy(out, utxos)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Vout.Value != out[j].Vout.Value {
+			if desc {
+				return out[i].Vout.Value > out[j].Vout.Value
+			}
+			return out[i].Vout.Value < out[j].Vout.Value
+		}
+		if out[i].Txid != out[j].Txid {
+			return out[i].Txid < out[j].Txid
+		}
+		return out[i].Index < out[j].Index
+	})
+	return out
+}
+
+// LargestFirstSelector spends the biggest outputs first (fewest inputs)
+type LargestFirstSelector struct{}
+
+func (LargestFirstSelector) SelectCoins(utxos []UTXO, p SelectionParams) (CoinSelection, error) {
+	return accumulate(sortedCopy(utxos, true), p)
+}
+
+// SmallestFirstSelector spends the smallest outputs first (consolidates dust-ish coins)
+type SmallestFirstSelector struct{}
+
+func (SmallestFirstSelector) SelectCoins(utxos []UTXO, p SelectionParams) (CoinSelection, error) {
+	return accumulate(sortedCopy(utxos, false), p)
+}
+
+// OldestFirstSelector spends outputs in the order the wallet received them
+type OldestFirstSelector struct{}
+
+func (OldestFirstSelector) SelectCoins(utxos []UTXO, p SelectionParams) (CoinSelection, error) {
+	return accumulate(utxos, p)
+}
+
+// bnbMaxTries bounds the branch-and-bound search
+const bnbMaxTries = 100_000
+
+// BranchAndBoundSelector looks for an input set that pays amount + fee with no
+// change output, wasting at most the cost of creating a change output.
+// If no such set exists it defers to Fallback (LargestFirst when nil).
+type BranchAndBoundSelector struct {
+	Fallback CoinSelector
+}
+
+func (s BranchAndBoundSelector) SelectCoins(utxos []UTXO, p SelectionParams) (CoinSelection, error) {
+	if sel, ok := branchAndBound(utxos, p); ok {
+		return sel, nil
+	}
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = LargestFirstSelector{}
+	}
+	return fallback.SelectCoins(utxos, p)
+}
+
+func branchAndBound(utxos []UTXO, p SelectionParams) (CoinSelection, bool) {
+	// effective value = value minus the fee to spend it
+	inputFee := p.FeeRate * int64(p.InputSize)
+
+	var pool []UTXO
+	for _, u := range sortedCopy(utxos, true) {
+		if u.Vout.Value > inputFee {
+			pool = append(pool, u)
+		}
+	}
+	if len(pool) == 0 {
+		return CoinSelection{}, false
+	}
+
+	eff := make([]int64, len(pool))
+	remaining := make([]int64, len(pool)+1) // suffix sums of effective values
+	for i := len(pool) - 1; i >= 0; i-- {
+		eff[i] = pool[i].Vout.Value - inputFee
+		remaining[i] = remaining[i+1] + eff[i]
+	}
+
+	target := p.Amount + p.Fee(0, false)
+	upper := target + p.Fee(0, true) - p.Fee(0, false) + p.Dust // cost of change
+
+	var best []int
+	chosen := make([]int, 0, len(pool))
+	tries := 0
+
+	var search func(i int, sum int64) bool
+	search = func(i int, sum int64) bool {
+		tries++
+		if tries > bnbMaxTries {
+			return true // give up
+		}
+		if sum >= target {
+			if sum <= upper {
+				best = append([]int(nil), chosen...)
+				return true
+			}
+			return false
+		}
+		if i >= len(pool) || sum+remaining[i] < target {
+			return false
+		}
+
+		// include pool[i]
+		chosen = append(chosen, i)
+		if search(i+1, sum+eff[i]) {
+			return true
+		}
+		chosen = chosen[:len(chosen)-1]
+
+		// exclude pool[i] (and equal-valued siblings, which give the same sums)
+		j := i + 1
+		for j < len(pool) && eff[j] == eff[i] {
+			j++
+		}
+		return search(j, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return CoinSelection{}, false
+	}
+
+	inputs := make([]UTXO, len(best))
+	total := int64(0)
+	for k, idx := range best {
+		inputs[k] = pool[idx]
+		total += pool[idx].Vout.Value
+	}
+	if total < p.Amount+p.Fee(len(inputs), false) {
+		return CoinSelection{}, false // vin varint grew past the estimate
+	}
+
+	// exact match: everything above amount goes to the fee, no change
+	return CoinSelection{Inputs: inputs, Fee: total - p.Amount}, true
+}
+
+func varIntSize(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	default:
+		return 9
+	}
+}
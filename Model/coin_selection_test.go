@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func selectionFixture(values ...int64) []UTXO {
+	utxos := make([]UTXO, len(values))
+	for i, v := range values {
+		utxos[i] = UTXO{Txid: fmt.Sprintf("%064x", i+1), Index: 0, Vout: VOUT{Value: v}}
+	}
+	return utxos
+}
+
+func testSelectionParams(amount int64) SelectionParams {
+	return SelectionParams{
+		Amount:      amount,
+		FeeRate:     1,
+		InputSize:   p2pkhInputSize,
+		PaymentSize: 34,
+		ChangeSize:  34,
+		Dust:        DustThreshold,
+	}
+}
+
+func inputValues(sel CoinSelection) []int64 {
+	var vals []int64
+	for _, u := range sel.Inputs {
+		vals = append(vals, u.Vout.Value)
+	}
+	return vals
+}
+
+func TestCoinSelectors(t *testing.T) {
+	utxos := selectionFixture(3000, 50_000, 1000, 20_000) // receive order
+	p := testSelectionParams(3500)
+
+	cases := []struct {
+		name     string
+		selector CoinSelector
+		want     []int64
+	}{
+		{"largest", LargestFirstSelector{}, []int64{50_000}},
+		{"smallest", SmallestFirstSelector{}, []int64{1000, 3000}},
+		{"oldest", OldestFirstSelector{}, []int64{3000, 50_000}},
+	}
+
+	for _, c := range cases {
+		sel, err := c.selector.SelectCoins(utxos, p)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := inputValues(sel); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: inputs %v, want %v", c.name, got, c.want)
+		}
+
+		total := int64(0)
+		for _, v := range inputValues(sel) {
+			total += v
+		}
+		if total != p.Amount+sel.Fee+sel.Change {
+			t.Errorf("%s: inputs %d != amount+fee+change", c.name, total)
+		}
+
+		// same input → same result
+		again, _ := c.selector.SelectCoins(utxos, p)
+		if !reflect.DeepEqual(again, sel) {
+			t.Errorf("%s: non-deterministic selection", c.name)
+		}
+	}
+}
+
+func TestBranchAndBoundAvoidsChange(t *testing.T) {
+	p := testSelectionParams(10_000)
+	// 7000 + 3400 covers 10_000 + fee for 2 inputs with ~ no waste
+	utxos := selectionFixture(50_000, 7000, 3400, 20_000)
+
+	sel, err := BranchAndBoundSelector{}.SelectCoins(utxos, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Change != 0 {
+		t.Fatalf("expected changeless selection, got change %d (inputs %v)", sel.Change, inputValues(sel))
+	}
+	if got := inputValues(sel); !reflect.DeepEqual(got, []int64{7000, 3400}) {
+		t.Fatalf("inputs %v", got)
+	}
+
+	// no exact match → fallback (largest first) with change
+	sel, err = BranchAndBoundSelector{}.SelectCoins(selectionFixture(50_000), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Change == 0 {
+		t.Fatal("fallback should produce change")
+	}
+}
+
+func TestCoinSelectorInsufficient(t *testing.T) {
+	_, err := LargestFirstSelector{}.SelectCoins(selectionFixture(100, 200), testSelectionParams(1000))
+	if err != ErrInsufficientFunds {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// CoinbaseVout is the prev index of the single coinbase input (prev txid is "")
+const CoinbaseVout = 0xffffffff
+
+// coinbase scriptSig = <height 4 bytes LE> <extra nonce 8 bytes LE>
+const (
+	coinbaseMinScriptSize = 2
+	coinbaseMaxScriptSize = 100
+)
+
+// NewCoinbaseTx builds the first transaction of a block, paying value to addr.
+// The height push keeps coinbase txids unique across blocks; extraNonce gives
+// the miner more search space once the header nonce is exhausted.
+func NewCoinbaseTx(height int32, addr string, value int64, extraNonce uint64) Transaction {
+	script := make([]byte, 0, 14)
+	script = append(script, 0x04)
+	script = binary.LittleEndian.AppendUint32(script, uint32(height))
+	script = append(script, 0x08)
+	script = binary.LittleEndian.AppendUint64(script, extraNonce)
+
+	tx := Transaction{
+		Version: 1,
+		Vin: []VIN{
+			{
+				Txid:      "",
+				Vout:      CoinbaseVout,
+				ScriptSig: ScriptSigFromBytes(script),
+			},
+		},
+		Vout: []VOUT{
+			{
+				Value:        value,
+				N:            0,
+				ScriptPubKey: MakeP2PKHScriptPubKey(addr),
+			},
+		},
+	}
+	tx.Txid = tx.ComputeTxID()
+	return tx
+}
+
+// IsCoinbase: exactly one input with empty prev txid and CoinbaseVout index
+func (t *Transaction) IsCoinbase() bool {
+	return len(t.Vin) == 1 && t.Vin[0].Txid == "" && t.Vin[0].Vout == CoinbaseVout
+}
+
+// CoinbaseHeight reads the block height committed in the coinbase scriptSig
+func (t *Transaction) CoinbaseHeight() (int32, error) {
+	if !t.IsCoinbase() {
+		return 0, fmt.Errorf("not a coinbase")
+	}
+	script, err := hex.DecodeString(t.Vin[0].ScriptSig.Hex)
+	if err != nil {
+		return 0, err
+	}
+	if len(script) < 5 || script[0] != 0x04 {
+		return 0, fmt.Errorf("coinbase missing height push")
+	}
+	return int32(binary.LittleEndian.Uint32(script[1:5])), nil
+}
+
+// CheckCoinbase validates coinbase structure, the height it commits to and that
+// it pays no more than subsidy + fees.
+func CheckCoinbase(tx *Transaction, height int32, fees int64, params *ChainParams) error {
+	if !tx.IsCoinbase() {
+		return fmt.Errorf("first tx is not coinbase")
+	}
+
+	script, err := hex.DecodeString(tx.Vin[0].ScriptSig.Hex)
+	if err != nil {
+		return fmt.Errorf("bad coinbase script: %v", err)
+	}
+	if len(script) < coinbaseMinScriptSize || len(script) > coinbaseMaxScriptSize {
+		return fmt.Errorf("bad coinbase script size %d", len(script))
+	}
+
+	cbHeight, err := tx.CoinbaseHeight()
+	if err != nil {
+		return err
+	}
+	if cbHeight != height {
+		return fmt.Errorf("coinbase height %d, block height %d", cbHeight, height)
+	}
+
+	if len(tx.Vout) == 0 {
+		return fmt.Errorf("coinbase has no outputs")
+	}
+	total := int64(0)
+	for _, out := range tx.Vout {
+		if !MoneyRange(out.Value) {
+			return fmt.Errorf("invalid coinbase output value")
+		}
+		total += out.Value
+		if !MoneyRange(total) {
+			return fmt.Errorf("coinbase output total out of range")
+		}
+	}
+
+	if !MoneyRange(fees) {
+		return fmt.Errorf("block fees %d out of range", fees)
+	}
+	maxReward := params.BlockSubsidy(height) + fees
+	if total > maxReward {
+		return fmt.Errorf("coinbase pays %d, max %d (subsidy+fees)", total, maxReward)
+	}
+
+	if tx.Txid != tx.ComputeTxID() {
+		return fmt.Errorf("coinbase txid mismatch")
+	}
+
+	return nil
+}
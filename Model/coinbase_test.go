@@ -0,0 +1,79 @@
+package model
+
+import "testing"
+
+func TestBlockSubsidyHalving(t *testing.T) {
+	p := &ChainParams{InitialSubsidy: 5000, SubsidyHalvingInterval: 10}
+
+	cases := map[int32]int64{0: 5000, 9: 5000, 10: 2500, 25: 1250, 10 * 64: 0}
+	for h, want := range cases {
+		if got := p.BlockSubsidy(h); got != want {
+			t.Errorf("subsidy(%d) = %d, want %d", h, got, want)
+		}
+	}
+}
+
+func TestVerifyBlockCoinbaseReward(t *testing.T) {
+	tx, utxoSet, _ := newSpendFixture(t) // pays fee 100
+	params := RegtestChainParams()
+	addr := "0102030405060708090a0b0c0d0e0f1011121314"
+	height := int32(1)
+
+	maxReward := params.BlockSubsidy(height) + 100
+
+	ok := newTestBlock(t, []Transaction{NewCoinbaseTx(height, addr, maxReward, 0), *tx}, nil, height, params.PowLimitBits)
+	if err := VerifyBlock(ok, utxoSet, params); err != nil {
+		t.Fatalf("valid block rejected: %v", err)
+	}
+
+	over := newTestBlock(t, []Transaction{NewCoinbaseTx(height, addr, maxReward+1, 0), *tx}, nil, height, params.PowLimitBits)
+	if err := VerifyBlock(over, utxoSet, params); err == nil {
+		t.Fatal("coinbase paying more than subsidy+fees accepted")
+	}
+
+	wrongHeight := newTestBlock(t, []Transaction{NewCoinbaseTx(height+1, addr, 1, 0), *tx}, nil, height, params.PowLimitBits)
+	if err := VerifyBlock(wrongHeight, utxoSet, params); err == nil {
+		t.Fatal("coinbase with wrong height accepted")
+	}
+
+	noCoinbase := newTestBlock(t, []Transaction{*tx}, nil, height, params.PowLimitBits)
+	if err := VerifyBlock(noCoinbase, utxoSet, params); err == nil {
+		t.Fatal("block without coinbase accepted")
+	}
+}
+
+func TestVerifyBlockCoinbaseOverflow(t *testing.T) {
+	params := RegtestChainParams()
+	addr := "0102030405060708090a0b0c0d0e0f1011121314"
+	height := int32(1)
+
+	// 16 * 2^60 wraps int64 to 0, which would pass total <= subsidy unguarded
+	cb := NewCoinbaseTx(height, addr, 1<<60, 0)
+	for len(cb.Vout) < 16 {
+		cb.Vout = append(cb.Vout, cb.Vout[0])
+	}
+	cb.Txid = cb.ComputeTxID()
+
+	block := newTestBlock(t, []Transaction{cb}, nil, height, params.PowLimitBits)
+	if err := VerifyBlock(block, NewUTXOSet(), params); err == nil {
+		t.Fatal("coinbase with wrapping outputs accepted")
+	}
+	if err := VerifyBlockSerial(block, NewUTXOSet(), params); err == nil {
+		t.Fatal("serial: coinbase with wrapping outputs accepted")
+	}
+}
+
+func TestCheckTxInputsOverflow(t *testing.T) {
+	prev := UTXO{Txid: "aa", Index: 0, Vout: VOUT{Value: 1000}}
+	lookup := func(txid string, vout int) (UTXO, bool) { return prev, txid == prev.Txid }
+
+	tx := Transaction{Vin: []VIN{{Txid: prev.Txid, Vout: 0}}}
+	for i := 0; i < 16; i++ {
+		tx.Vout = append(tx.Vout, VOUT{Value: 1 << 60})
+	}
+	tx.Txid = tx.ComputeTxID()
+
+	if _, _, err := checkTxInputs(&tx, lookup); err == nil {
+		t.Fatal("outputs wrapping to 0 accepted")
+	}
+}
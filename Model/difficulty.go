@@ -0,0 +1,136 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// retarget never moves difficulty by more than this factor per window
+const retargetMaxAdjust = 4
+
+// medianTimeSpan is the number of blocks median-time-past is taken over
+const medianTimeSpan = 11
+
+// MaxFutureBlockTime: how far a block timestamp may run ahead of the local clock
+const MaxFutureBlockTime = 2 * time.Hour
+
+// AncestorFunc returns the block at height on the branch being extended (nil if unknown)
+type AncestorFunc func(height int32) *Block
+
+// CalcNextBits returns the nBits required for the block after prev.
+//
+// Every RetargetInterval blocks the target is scaled by actual/expected time
+// of the last window (the RetargetInterval blocks ending at prev, so
+// expected = (RetargetInterval-1) * TargetSpacing), clamped to [1/4, 4] and
+// capped at the pow limit. Other heights keep prev.Bits.
+func CalcNextBits(params *ChainParams, prev *Block, ancestor AncestorFunc) (uint32, error) {
+	height := prev.Height + 1
+	interval := params.RetargetInterval
+
+	if interval <= 1 || height%interval != 0 {
+		return prev.Bits, nil
+	}
+
+	first := ancestor(height - interval)
+	if first == nil {
+		return 0, fmt.Errorf("missing ancestor at height %d for retarget", height-interval)
+	}
+
+	expected := int64(prev.Height-first.Height) * int64(params.TargetSpacing.Seconds())
+	actual := prev.Timestamp - first.Timestamp
+	if expected <= 0 {
+		return prev.Bits, nil
+	}
+
+	next := CompactToBig(prev.Bits)
+	switch {
+	case actual*retargetMaxAdjust < expected:
+		next.Div(next, big.NewInt(retargetMaxAdjust))
+	case actual > expected*retargetMaxAdjust:
+		next.Mul(next, big.NewInt(retargetMaxAdjust))
+	default:
+		next.Mul(next, big.NewInt(actual))
+		next.Div(next, big.NewInt(expected))
+	}
+
+	if limit := params.PowLimit(); next.Cmp(limit) > 0 {
+		next = limit
+	}
+
+	return BigToCompact(next), nil
+}
+
+// MedianTimePast returns the median timestamp of the medianTimeSpan blocks
+// ending at prev (fewer near genesis). A new block must be later than it.
+func MedianTimePast(prev *Block, ancestor AncestorFunc) int64 {
+	times := []int64{prev.Timestamp}
+	for h := prev.Height - 1; h >= 0 && len(times) < medianTimeSpan; h-- {
+		b := ancestor(h)
+		if b == nil {
+			break
+		}
+		times = append(times, b.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// ancestorAt looks up the active chain by height (Blocks[i].Height == i)
+func (bc *Blockchain) ancestorAt(height int32) *Block {
+	if height < 0 || int(height) >= len(bc.Blocks) {
+		return nil
+	}
+	return bc.Blocks[height]
+}
+
+// NextBits returns the difficulty the next block on the tip must carry
+func (bc *Blockchain) NextBits() (uint32, error) {
+	return CalcNextBits(bc.Params, bc.Tip(), bc.ancestorAt)
+}
+
+// NextTimestamp returns the timestamp for a new block on the tip: the clock,
+// raised past median-time-past when recent blocks are ahead of it
+func (bc *Blockchain) NextTimestamp() int64 {
+	ts := time.Now().Unix()
+	if mtp := MedianTimePast(bc.Tip(), bc.ancestorAt); ts <= mtp {
+		ts = mtp + 1
+	}
+	return ts
+}
+
+// CheckBlockContext validates header fields that depend on the chain:
+// parent link, height, timestamp and the retarget rule
+func (bc *Blockchain) CheckBlockContext(block *Block) error {
+	return checkBlockContext(bc.Params, block, bc.Tip(), bc.ancestorAt, time.Now())
+}
+
+// checkBlockContext checks block against prev, the block it builds on;
+// ancestor resolves heights on prev's branch and now is the local clock
+func checkBlockContext(params *ChainParams, block, prev *Block, ancestor AncestorFunc, now time.Time) error {
+	if !bytes.Equal(block.PrevHash, prev.Hash) {
+		return fmt.Errorf("block does not extend %x", prev.Hash)
+	}
+	if block.Height != prev.Height+1 {
+		return fmt.Errorf("bad height %d, expected %d", block.Height, prev.Height+1)
+	}
+
+	if mtp := MedianTimePast(prev, ancestor); block.Timestamp <= mtp {
+		return fmt.Errorf("timestamp %d not after median time past %d", block.Timestamp, mtp)
+	}
+	if limit := now.Add(MaxFutureBlockTime).Unix(); block.Timestamp > limit {
+		return fmt.Errorf("timestamp %d too far in the future (limit %d)", block.Timestamp, limit)
+	}
+
+	want, err := CalcNextBits(params, prev, ancestor)
+	if err != nil {
+		return err
+	}
+	if block.Bits != want {
+		return fmt.Errorf("bad difficulty bits %08x, expected %08x", block.Bits, want)
+	}
+
+	return nil
+}
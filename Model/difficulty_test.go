@@ -0,0 +1,145 @@
+package model
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// syntheticChain builds headers only: heights 0..n-1, gap seconds apart
+func syntheticChain(params *ChainParams, bits uint32, n int, gap int64) []*Block {
+	chain := make([]*Block, n)
+	ts := int64(1_700_000_000)
+	for i := 0; i < n; i++ {
+		chain[i] = &Block{Height: int32(i), Timestamp: ts, Bits: bits}
+		ts += gap
+	}
+	return chain
+}
+
+func lookup(chain []*Block) AncestorFunc {
+	return func(h int32) *Block {
+		if h < 0 || int(h) >= len(chain) {
+			return nil
+		}
+		return chain[h]
+	}
+}
+
+func retargetParams() *ChainParams {
+	p := DefaultChainParams()
+	p.TargetSpacing = 10 * time.Second
+	p.RetargetInterval = 4
+	return p
+}
+
+func TestRetargetKeepsBitsInsideWindow(t *testing.T) {
+	p := retargetParams()
+	chain := syntheticChain(p, 0x1e00ffff, 3, 1)
+
+	bits, err := CalcNextBits(p, chain[2], lookup(chain)) // next height 3
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits != 0x1e00ffff {
+		t.Fatalf("bits changed off a retarget boundary: %08x", bits)
+	}
+}
+
+func TestRetargetOnTimeKeepsDifficulty(t *testing.T) {
+	p := retargetParams()
+	chain := syntheticChain(p, 0x1e00ffff, 4, 10)
+
+	bits, _ := CalcNextBits(p, chain[3], lookup(chain))
+	if bits != 0x1e00ffff {
+		t.Fatalf("on-time window changed bits to %08x", bits)
+	}
+
+	// twice as slow → target doubles
+	slow := syntheticChain(p, 0x1e00ffff, 4, 20)
+	bits, _ = CalcNextBits(p, slow[3], lookup(slow))
+	want := new(big.Int).Mul(CompactToBig(0x1e00ffff), big.NewInt(2))
+	if bits != BigToCompact(want) {
+		t.Fatalf("bits %08x, want %08x", bits, BigToCompact(want))
+	}
+}
+
+func TestRetargetClampsAndCaps(t *testing.T) {
+	p := retargetParams()
+
+	// blocks every second → clamped to 4x harder
+	fast := syntheticChain(p, 0x1e00ffff, 4, 1)
+	bits, _ := CalcNextBits(p, fast[3], lookup(fast))
+	want := new(big.Int).Div(CompactToBig(0x1e00ffff), big.NewInt(4))
+	if bits != BigToCompact(want) {
+		t.Fatalf("fast: bits %08x, want %08x", bits, BigToCompact(want))
+	}
+
+	// very slow blocks → easier, but never above the pow limit
+	slow := syntheticChain(p, 0x1f00ffff, 4, 1000)
+	bits, _ = CalcNextBits(p, slow[3], lookup(slow))
+	if bits != p.PowLimitBits {
+		t.Fatalf("slow: bits %08x, want pow limit %08x", bits, p.PowLimitBits)
+	}
+
+	// slow from a harder target → 4x easier
+	slow = syntheticChain(p, 0x1d00ffff, 4, 1000)
+	bits, _ = CalcNextBits(p, slow[3], lookup(slow))
+	want = new(big.Int).Mul(CompactToBig(0x1d00ffff), big.NewInt(4))
+	if bits != BigToCompact(want) {
+		t.Fatalf("slow: bits %08x, want %08x", bits, BigToCompact(want))
+	}
+}
+
+func TestCheckBlockContextEnforcesBits(t *testing.T) {
+	p := RegtestChainParams()
+	p.RetargetInterval = 0 // constant difficulty
+	bc, err := NewBlockchain(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := newTestBlock(t, nil, bc.Tip().Hash, 1, p.PowLimitBits)
+	if err := bc.CheckBlockContext(good); err != nil {
+		t.Fatalf("valid block rejected: %v", err)
+	}
+
+	wrong := newTestBlock(t, nil, bc.Tip().Hash, 1, 0x1f7fffff)
+	if err := bc.CheckBlockContext(wrong); err == nil {
+		t.Fatal("block with wrong bits accepted")
+	}
+}
+
+func TestCheckBlockContextTimestamp(t *testing.T) {
+	p := RegtestChainParams()
+	p.RetargetInterval = 0
+	chain := syntheticChain(p, p.PowLimitBits, 12, 10)
+	chain[10].Timestamp = chain[2].Timestamp // out of order blocks are allowed
+	prev := chain[11]
+	now := time.Unix(prev.Timestamp, 0)
+
+	// last 11 timestamps are ts[1..11] with ts[10] moved down to ts[2]: median is ts[5]
+	mtp := MedianTimePast(prev, lookup(chain))
+	if mtp != chain[5].Timestamp {
+		t.Fatalf("median time past %d, want %d", mtp, chain[5].Timestamp)
+	}
+
+	check := func(ts int64) error {
+		b := &Block{Height: prev.Height + 1, Timestamp: ts, Bits: p.PowLimitBits}
+		return checkBlockContext(p, b, prev, lookup(chain), now)
+	}
+	if err := check(mtp); err == nil {
+		t.Fatal("block at median time past accepted")
+	}
+	if err := check(mtp + 1); err != nil {
+		t.Fatalf("block after median time past rejected: %v", err)
+	}
+
+	limit := now.Add(MaxFutureBlockTime).Unix()
+	if err := check(limit); err != nil {
+		t.Fatalf("block at the future limit rejected: %v", err)
+	}
+	if err := check(limit + 1); err == nil {
+		t.Fatal("block beyond the future limit accepted")
+	}
+}
@@ -1,15 +1,48 @@
 package model
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 )
 
+// Mempool holds unconfirmed transactions: InMemoryMempool, or RedisMempool
+// to share one pool between processes.
+type Mempool interface {
+	// AddTransaction stores tx, which pays fee (inputs minus outputs). An
+	// input another pool tx spends fails it with ErrMempoolDoubleSpend.
+	AddTransaction(tx *Transaction, fee int64) error
+	RemoveTransaction(tx *Transaction) error
+	GetTransaction(txid string) *Transaction
+
+	// IsSpent: some mempool tx spends txid:vout
+	IsSpent(txid string, vout int) bool
+	// GetOutput: output of a mempool tx
+	GetOutput(txid string, vout int) (VOUT, bool)
+
+	// RemoveForBlock drops txs confirmed by block and those conflicting
+	// with it, with their descendants
+	RemoveForBlock(block *Block)
+	// Drain empties the pool and returns its txs in arrival order
+	Drain() []*Transaction
+
+	// SnapshotUntilSize: block template of at most maxBytes, highest
+	// ancestor fee rate first, parents before children
+	SnapshotUntilSize(maxBytes int) MempoolSnapshot
+	Size() int       // transactions
+	TotalBytes() int // serialized size of all transactions
+}
+
+// ErrMempoolDoubleSpend: an input of the tx is already spent by a pool tx
+var ErrMempoolDoubleSpend = errors.New("input already spent in mempool")
+
 type InMemoryMempool struct {
 	mu sync.RWMutex
 
-	// txid -> Transaction
-	txs map[string]*Transaction
+	// txid -> tx with its fee and in-pool parents/children
+	entries map[string]*mempoolEntry
 
 	// spent map: "txid:vout" -> spending txid
 	spent map[string]string
@@ -17,23 +50,40 @@ type InMemoryMempool struct {
 	// unconfirmed outputs: "txid:vout" -> VOUT
 	outputs map[string]VOUT
 
-	// ordered txids (arrival order)
-	order []string
+	// ordered txids (arrival order), compacted by compactOrderLocked
+	order []orderItem
 
-	// txid -> tx size (cache)
-	txSize map[string]int
+	// orderDead: items of removed txs still in order
+	orderDead int
+
+	// seq of the last added tx
+	seq uint64
 
 	// total mempool size (bytes)
 	totalSize int
+
+	limits MempoolLimits
+
+	// minimum fee rate, as of minFeeTime (see MinFeeRate)
+	minFee     float64
+	minFeeTime time.Time
+
+	now func() time.Time
 }
 
+// NewInMemoryMempool: pool with DefaultMempoolLimits
 func NewInMemoryMempool() *InMemoryMempool {
+	return NewInMemoryMempoolWithLimits(DefaultMempoolLimits())
+}
+
+func NewInMemoryMempoolWithLimits(limits MempoolLimits) *InMemoryMempool {
 	return &InMemoryMempool{
-		txs:     make(map[string]*Transaction),
+		entries: make(map[string]*mempoolEntry),
 		spent:   make(map[string]string),
 		outputs: make(map[string]VOUT),
-		order:   []string{},
-		txSize:  make(map[string]int),
+		order:   []orderItem{},
+		limits:  limits,
+		now:     time.Now,
 	}
 }
 
@@ -41,28 +91,47 @@ func (m *InMemoryMempool) GetTransaction(txid string) *Transaction {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	tx, ok := m.txs[txid]
+	e, ok := m.entries[txid]
 	if !ok {
 		return nil
 	}
-	return tx
+	return e.tx
 }
 
-func (m *InMemoryMempool) AddTransaction(tx *Transaction) error {
+// AddTransaction stores tx, which pays fee (inputs minus outputs).
+// Fails with ErrMempoolDoubleSpend if a pool tx spends one of its inputs,
+// ErrMempoolMinFee below MinFeeRate, and ErrMempoolFull if the pool is over
+// its byte limit and tx ranks lowest.
+func (m *InMemoryMempool) AddTransaction(tx *Transaction, fee int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, ok := m.txs[tx.Txid]; ok {
+	if _, ok := m.entries[tx.Txid]; ok {
 		return fmt.Errorf("tx already exists")
 	}
+	// checked again under the lock: the caller's check may be stale
+	for _, vin := range tx.Vin {
+		if vin.Txid == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)
+		if spender, ok := m.spent[key]; ok {
+			return fmt.Errorf("%w: %s by %s", ErrMempoolDoubleSpend, key, spender)
+		}
+	}
 
-	size := tx.Size()
+	now := m.now()
+	e := newMempoolEntry(tx, fee, m.seq+1)
+	e.added = now
+	if err := m.checkMinFeeLocked(e, now); err != nil {
+		return err
+	}
 
 	// 1️⃣ save tx
-	m.txs[tx.Txid] = tx
-	m.txSize[tx.Txid] = size
-	m.order = append(m.order, tx.Txid)
-	m.totalSize += size
+	m.seq++
+	m.entries[tx.Txid] = e
+	m.order = append(m.order, orderItem{tx.Txid, e.seq})
+	m.totalSize += e.size
 
 	// 2️⃣ mark inputs as spent
 	for _, vin := range tx.Vin {
@@ -79,6 +148,17 @@ func (m *InMemoryMempool) AddTransaction(tx *Transaction) error {
 		m.outputs[key] = out
 	}
 
+	// 4️⃣ link to in-pool parents, ancestor totals
+	e.link(m.entries)
+
+	// 5️⃣ limits
+	m.expireLocked(now)
+	m.trimLocked(now)
+	m.compactOrderLocked()
+	if _, ok := m.entries[tx.Txid]; !ok {
+		return ErrMempoolFull
+	}
+
 	return nil
 }
 func (m *InMemoryMempool) IsSpent(txid string, vout int) bool {
@@ -95,16 +175,34 @@ func (m *InMemoryMempool) GetOutput(txid string, vout int) (VOUT, bool) {
 	out, ok := m.outputs[fmt.Sprintf("%s:%d", txid, vout)]
 	return out, ok
 }
-func (m *InMemoryMempool) RemoveTransaction(tx *Transaction) {
+
+// RemoveTransaction drops tx alone; its children stay, with tx no longer
+// among their ancestors
+func (m *InMemoryMempool) RemoveTransaction(tx *Transaction) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.txs, tx.Txid)
-	m.totalSize -= m.txSize[tx.Txid]
-	delete(m.txSize, tx.Txid)
+	stale := make(map[string]bool)
+	m.removeLocked(tx.Txid, stale)
+	m.refreshLocked(stale)
+	m.compactOrderLocked()
+	return nil
+}
+
+// removeLocked drops txid and adds its remaining children to stale: their
+// ancestor totals are then out of date (see refreshLocked)
+func (m *InMemoryMempool) removeLocked(txid string, stale map[string]bool) *mempoolEntry {
+	e, ok := m.entries[txid]
+	if !ok {
+		return nil
+	}
+	delete(m.entries, txid)
+	delete(stale, txid)
+	m.totalSize -= e.size
+	m.orderDead++
 
 	// remove spent marks
-	for _, vin := range tx.Vin {
+	for _, vin := range e.tx.Vin {
 		if vin.Txid == "" {
 			continue
 		}
@@ -112,48 +210,194 @@ func (m *InMemoryMempool) RemoveTransaction(tx *Transaction) {
 	}
 
 	// remove outputs
-	for i := range tx.Vout {
-		delete(m.outputs, fmt.Sprintf("%s:%d", tx.Txid, i))
+	for i := range e.tx.Vout {
+		delete(m.outputs, fmt.Sprintf("%s:%d", txid, i))
+	}
+
+	// unlink relatives
+	for p := range e.parents {
+		if pe, ok := m.entries[p]; ok {
+			delete(pe.children, txid)
+		}
+	}
+	for c := range e.children {
+		if ce, ok := m.entries[c]; ok {
+			delete(ce.parents, txid)
+			stale[c] = true
+		}
 	}
 
-	// remove from order (lazy rebuild ok)
+	return e
+}
+
+// refreshLocked recomputes the ancestor totals of stale txs and of all their
+// descendants, parents first. Done once per removal batch: a block
+// confirming the head of a long chain costs one pass over the rest.
+func (m *InMemoryMempool) refreshLocked(stale map[string]bool) {
+	var todo []*mempoolEntry
+	seen := make(map[string]bool)
+	stack := make([]string, 0, len(stale))
+	for txid := range stale {
+		stack = append(stack, txid)
+	}
+	for len(stack) > 0 {
+		txid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		e, ok := m.entries[txid]
+		if !ok || seen[txid] {
+			continue
+		}
+		seen[txid] = true
+		todo = append(todo, e)
+		for c := range e.children {
+			stack = append(stack, c)
+		}
+	}
+
+	slices.SortFunc(todo, compareSeq)
+	for _, e := range todo {
+		e.anc = ancestorTotals(e, m.entries)
+	}
+}
+
+// RemoveForBlock drops txs confirmed by block and, with their descendants,
+// any mempool tx that double-spends one of its inputs
+func (m *InMemoryMempool) RemoveForBlock(block *Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stale := make(map[string]bool)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.IsCoinbase() {
+			continue
+		}
+		if m.removeLocked(tx.Txid, stale) != nil {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			spender, ok := m.spent[fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)]
+			if ok {
+				m.removeWithDescendantsLocked(spender, stale)
+			}
+		}
+	}
+	m.refreshLocked(stale)
+
+	m.expireLocked(m.now())
+	m.compactOrderLocked()
+}
+
+func (m *InMemoryMempool) removeWithDescendantsLocked(txid string, stale map[string]bool) {
+	stack := []string{txid}
+	for len(stack) > 0 {
+		e := m.removeLocked(stack[len(stack)-1], stale)
+		stack = stack[:len(stack)-1]
+		if e == nil {
+			continue
+		}
+		for c := range e.children {
+			stack = append(stack, c)
+		}
+	}
+}
+
+// Drain empties the mempool and returns its txs in arrival order
+func (m *InMemoryMempool) Drain() []*Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var txs []*Transaction
+	for _, it := range m.order {
+		if e, live := m.liveLocked(it); live {
+			txs = append(txs, e.tx)
+		}
+	}
+
+	m.entries = make(map[string]*mempoolEntry)
+	m.spent = make(map[string]string)
+	m.outputs = make(map[string]VOUT)
+	m.order = []orderItem{}
+	m.orderDead = 0
+	m.totalSize = 0
+
+	return txs
 }
 
 type MempoolSnapshot struct {
 	TxIDs []string
-	Size  int // tổng size của snapshot
+	Size  int   // tổng size của snapshot
+	Fees  int64 // tổng fee của snapshot
 }
 
+// SnapshotUntilSize: block template of at most maxBytes, by ancestor fee
+// rate (see buildBlockTemplate)
 func (m *InMemoryMempool) SnapshotUntilSize(maxBytes int) MempoolSnapshot {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	var res []string
-	size := 0
+	return buildBlockTemplate(m.entries, maxBytes)
+}
 
-	for _, txid := range m.order {
-		_, ok := m.txs[txid]
-		if !ok {
-			continue
-		}
+// MempoolEntryInfo: fee and package totals of a mempool tx. Ancestors and
+// descendants include the tx itself.
+type MempoolEntryInfo struct {
+	Txid string
+	Size int
+	Fee  int64
 
-		ts := m.txSize[txid]
-		if size+ts > maxBytes {
-			break
-		}
+	AncestorCount int
+	AncestorSize  int
+	AncestorFees  int64
 
-		res = append(res, txid)
-		size += ts
-	}
+	DescendantCount int
+	DescendantSize  int
+	DescendantFees  int64
+}
+
+// FeeRate: fee per byte of the tx alone
+func (i MempoolEntryInfo) FeeRate() float64 {
+	return packageTotals{1, i.Size, i.Fee}.feeRate()
+}
 
-	return MempoolSnapshot{
-		TxIDs: res,
-		Size:  size,
+// AncestorFeeRate: fee per byte of the tx with its ancestors, the score
+// block templates select by
+func (i MempoolEntryInfo) AncestorFeeRate() float64 {
+	return packageTotals{i.AncestorCount, i.AncestorSize, i.AncestorFees}.feeRate()
+}
+
+// Entry returns the fee and package totals of txid. Descendant totals are
+// computed on request, ancestor totals are kept up to date.
+func (m *InMemoryMempool) Entry(txid string) (MempoolEntryInfo, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.entries[txid]
+	if !ok {
+		return MempoolEntryInfo{}, false
 	}
+	desc := descendantTotals(e, m.entries)
+	return MempoolEntryInfo{
+		Txid:            txid,
+		Size:            e.size,
+		Fee:             e.fee,
+		AncestorCount:   e.anc.count,
+		AncestorSize:    e.anc.size,
+		AncestorFees:    e.anc.fees,
+		DescendantCount: desc.count,
+		DescendantSize:  desc.size,
+		DescendantFees:  desc.fees,
+	}, true
 }
 
 func (m *InMemoryMempool) Size() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return len(m.txs)
+	return len(m.entries)
+}
+
+func (m *InMemoryMempool) TotalBytes() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.totalSize
 }
@@ -0,0 +1,286 @@
+package model
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"project/metrics"
+)
+
+// RejectCode says why the mempool refused a tx; String gives a stable name
+// for logs and RPC replies
+type RejectCode int
+
+const (
+	RejectMalformed           RejectCode = iota + 1 // no inputs/outputs, txid mismatch, duplicate input, coinbase input, output <= 0
+	RejectDuplicate                                 // already in the mempool
+	RejectDoubleSpend                               // an input is spent by a mempool tx
+	RejectMissingInputs                             // an input is in neither the UTXO set nor the mempool
+	RejectPubKeyHashMismatch                        // P2PKH: the key does not hash to the output's address
+	RejectBadSignature                              // P2PKH: the signature does not verify
+	RejectScriptFailure                             // any other script error
+	RejectOutputsExceedInputs                       // outputs sum to more than inputs
+	RejectMinFee                                    // fee rate under the mempool minimum
+	RejectMempoolFull                               // evicted at once by the size limit
+)
+
+var rejectCodeNames = map[RejectCode]string{
+	RejectMalformed:           "malformed",
+	RejectDuplicate:           "duplicate",
+	RejectDoubleSpend:         "double-spend",
+	RejectMissingInputs:       "missing-inputs",
+	RejectPubKeyHashMismatch:  "pubkey-hash-mismatch",
+	RejectBadSignature:        "bad-signature",
+	RejectScriptFailure:       "script-failure",
+	RejectOutputsExceedInputs: "outputs-exceed-inputs",
+	RejectMinFee:              "mempool-min-fee",
+	RejectMempoolFull:         "mempool-full",
+}
+
+func (c RejectCode) String() string {
+	if name, ok := rejectCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("reject-%d", int(c))
+}
+
+// RejectError: a tx refused by the mempool. Input is the offending input
+// index, -1 when the reason is not tied to one.
+type RejectError struct {
+	Code  RejectCode
+	Txid  string
+	Input int
+	Err   error // detail, may be nil
+}
+
+func (e *RejectError) Error() string {
+	msg := fmt.Sprintf("tx %s: %s", e.Txid, e.Code)
+	if e.Input >= 0 {
+		msg += fmt.Sprintf(" (input %d)", e.Input)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *RejectError) Unwrap() error { return e.Err }
+
+// RejectCodeOf returns the code of the RejectError in err's chain, 0 if none
+func RejectCodeOf(err error) RejectCode {
+	var rej *RejectError
+	if errors.As(err, &rej) {
+		return rej.Code
+	}
+	return 0
+}
+
+func reject(t *Transaction, code RejectCode, input int, format string, args ...any) *RejectError {
+	var err error
+	if format != "" {
+		err = fmt.Errorf(format, args...)
+	}
+	return &RejectError{Code: code, Txid: t.Txid, Input: input, Err: err}
+}
+
+// AcceptResult: what an accepted (or test-accepted) tx pays
+type AcceptResult struct {
+	Txid    string
+	Size    int
+	Fee     int64
+	FeeRate float64
+}
+
+// TestAcceptTransaction runs every check of AcceptTransaction, mempool
+// minimum fee included, without adding t. Errors are *RejectError.
+func TestAcceptTransaction(t *Transaction, utxoSet UTXOProvider, mempool Mempool) (AcceptResult, error) {
+	res, err := CheckMempoolTx(t, utxoSet, mempool)
+	if err != nil {
+		return res, err
+	}
+	if mp, ok := mempool.(interface{ MinFeeRate() float64 }); ok {
+		if minRate := mp.MinFeeRate(); res.FeeRate < minRate {
+			return res, reject(t, RejectMinFee, -1, "%.2f < %.2f per byte", res.FeeRate, minRate)
+		}
+	}
+	return res, nil
+}
+
+// AcceptTransaction validates t and adds it to mempool. Errors are
+// *RejectError (see RejectCodeOf).
+func AcceptTransaction(t *Transaction, utxoSet UTXOProvider, mempool Mempool) (AcceptResult, error) {
+	res, err := CheckMempoolTx(t, utxoSet, mempool)
+	if err != nil {
+		return res, err
+	}
+
+	// the pool checks conflicts again: another submission may have won the
+	// input since CheckMempoolTx
+	if err := mempool.AddTransaction(t, res.Fee); err != nil {
+		switch {
+		case errors.Is(err, ErrMempoolDoubleSpend):
+			return res, &RejectError{Code: RejectDoubleSpend, Txid: t.Txid, Input: spentInput(t, mempool), Err: err}
+		case errors.Is(err, ErrMempoolMinFee):
+			return res, &RejectError{Code: RejectMinFee, Txid: t.Txid, Input: -1, Err: err}
+		case errors.Is(err, ErrMempoolFull):
+			return res, &RejectError{Code: RejectMempoolFull, Txid: t.Txid, Input: -1, Err: err}
+		case mempool.GetTransaction(t.Txid) != nil:
+			return res, &RejectError{Code: RejectDuplicate, Txid: t.Txid, Input: -1, Err: err}
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// spentInput: index of the first input of t a mempool tx spends, -1 if none
+func spentInput(t *Transaction, mempool Mempool) int {
+	for i, vin := range t.Vin {
+		if mempool.IsSpent(vin.Txid, vin.Vout) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CheckMempoolTx validates t for the mempool: for each input, resolve the
+// prev output (UTXO set or mempool) and run scriptSig + scriptPubKey
+// through the script engine (VerifyScript). Mempool policy (minimum fee,
+// size limit) is not checked. Errors are *RejectError.
+func CheckMempoolTx(t *Transaction, utxoSet UTXOProvider, mempool Mempool) (AcceptResult, error) {
+	start := time.Now()
+	defer func() {
+		metrics.FnDuration.
+			WithLabelValues("tx_verify_duration").
+			Observe(float64(time.Since(start).Milliseconds()))
+	}()
+
+	res := AcceptResult{Txid: t.Txid}
+
+	// -----------------------------
+	// 0) Basic sanity checks
+	// -----------------------------
+	if err := checkTxSanity(t); err != nil {
+		return res, err
+	}
+
+	if mempool.GetTransaction(t.Txid) != nil {
+		return res, reject(t, RejectDuplicate, -1, "")
+	}
+
+	inputSum := int64(0)
+
+	// -----------------------------
+	// 1) Verify each input
+	// -----------------------------
+	for inIdx, vin := range t.Vin {
+
+		// 1.1 Double-spend check (mempool)
+		if mempool.IsSpent(vin.Txid, vin.Vout) {
+			return res, reject(t, RejectDoubleSpend, inIdx, "%s already spent in mempool", viewKey(vin.Txid, vin.Vout))
+		}
+
+		// 1.2 Fetch referenced output
+		var prevOut VOUT
+
+		// (a) canonical UTXO
+		if utxo, found := utxoSet.Get(vin.Txid, vin.Vout); found {
+			prevOut = utxo.Vout
+		} else {
+			// (b) mempool output (chained tx)
+			out, ok := mempool.GetOutput(vin.Txid, vin.Vout)
+			if !ok {
+				return res, reject(t, RejectMissingInputs, inIdx, "%s not found", viewKey(vin.Txid, vin.Vout))
+			}
+			prevOut = out
+		}
+
+		// -----------------------------
+		// 2) SCRIPT & SIGNATURE VERIFY
+		// -----------------------------
+		if err := verifyInputCached(t, inIdx, prevOut, true); err != nil {
+			return res, &RejectError{Code: scriptRejectCode(prevOut, err), Txid: t.Txid, Input: inIdx, Err: err}
+		}
+
+		inputSum += prevOut.Value
+		if !MoneyRange(prevOut.Value) || !MoneyRange(inputSum) {
+			return res, reject(t, RejectMalformed, inIdx, "input value out of range")
+		}
+	}
+
+	// -----------------------------
+	// 3) Outputs (values checked by checkTxSanity)
+	// -----------------------------
+	outputSum := int64(0)
+	for _, out := range t.Vout {
+		outputSum += out.Value
+	}
+
+	if outputSum > inputSum {
+		return res, reject(t, RejectOutputsExceedInputs, -1, "outputs %d > inputs %d", outputSum, inputSum)
+	}
+
+	res.Size = t.Size()
+	res.Fee = inputSum - outputSum
+	res.FeeRate = packageTotals{1, res.Size, res.Fee}.feeRate()
+	return res, nil
+}
+
+// checkTxSanity runs the checks that need neither the UTXO set nor the
+// mempool: txid matches the content, inputs and outputs present, no
+// duplicate or coinbase input, output values in range. Errors are *RejectError.
+func checkTxSanity(t *Transaction) error {
+	if len(t.Vin) == 0 || len(t.Vout) == 0 {
+		return reject(t, RejectMalformed, -1, "no inputs or no outputs")
+	}
+
+	// Txid must match the content (the signature cache is keyed by it)
+	if t.Txid != t.ComputeTxID() {
+		return reject(t, RejectMalformed, -1, "txid does not match content")
+	}
+
+	seen := make(map[string]bool)
+	for inIdx, vin := range t.Vin {
+		// Coinbase is NOT allowed in mempool
+		if vin.Txid == "" {
+			return reject(t, RejectMalformed, inIdx, "coinbase input")
+		}
+		key := viewKey(vin.Txid, vin.Vout)
+		if seen[key] {
+			return reject(t, RejectMalformed, inIdx, "duplicate input %s", key)
+		}
+		seen[key] = true
+	}
+
+	outputSum := int64(0)
+	for i, out := range t.Vout {
+		if out.Value <= 0 || out.Value > MaxMoney {
+			return reject(t, RejectMalformed, -1, "output %d value %d", i, out.Value)
+		}
+		outputSum += out.Value
+		if !MoneyRange(outputSum) {
+			return reject(t, RejectMalformed, -1, "output total out of range")
+		}
+	}
+	return nil
+}
+
+// scriptRejectCode: in a P2PKH spend the only VERIFY is the pubkey hash
+// check and the final CHECKSIG decides the result
+func scriptRejectCode(prevOut VOUT, err error) RejectCode {
+	script, herr := hex.DecodeString(prevOut.ScriptPubKey.Hex)
+	if herr != nil {
+		return RejectScriptFailure
+	}
+	if _, ok := ExtractP2PKHHash(script); !ok {
+		return RejectScriptFailure
+	}
+	switch {
+	case errors.Is(err, ErrScriptVerify):
+		return RejectPubKeyHashMismatch
+	case errors.Is(err, ErrScriptEvalFalse):
+		return RejectBadSignature
+	}
+	return RejectScriptFailure
+}
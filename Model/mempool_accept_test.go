@@ -0,0 +1,220 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// acceptFixture: one confirmed output of 1000 owned by priv's address
+func acceptFixture(t *testing.T) (ed25519.PrivateKey, string, *UTXOSet) {
+	t.Helper()
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	utxoSet := NewUTXOSet()
+	if err := utxoSet.Put(cacheTxid(700), 0, VOUT{Value: 1000, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}); err != nil {
+		t.Fatal(err)
+	}
+	return priv, addr, utxoSet
+}
+
+func signedSpend(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool, value int64, ins ...VIN) *Transaction {
+	t.Helper()
+	_, pub := NewKeyPair()
+	tx := &Transaction{
+		Version: 1,
+		Vin:     ins,
+		Vout:    []VOUT{{Value: value, ScriptPubKey: MakeP2PKHScriptPubKey(AddressFromPub(pub))}},
+	}
+	if err := tx.SignEd25519(priv, utxoSet, mempool); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestAcceptRejectCodes(t *testing.T) {
+	fund := VIN{Txid: cacheTxid(700), Vout: 0}
+	otherPriv, _ := NewKeyPair()
+
+	cases := []struct {
+		name  string
+		build func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction
+		code  RejectCode
+		input int
+	}{
+		{"double spend", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			if _, err := AcceptTransaction(signedSpend(t, priv, utxoSet, mempool, 900, fund), utxoSet, mempool); err != nil {
+				t.Fatal(err)
+			}
+			return signedSpend(t, priv, utxoSet, mempool, 800, fund)
+		}, RejectDoubleSpend, 0},
+		{"missing input", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			// resolved before any script runs: no signature needed
+			tx := &Transaction{Version: 1, Vin: []VIN{{Txid: cacheTxid(701)}}, Vout: []VOUT{{Value: 1}}}
+			tx.Txid = tx.ComputeTxID()
+			return tx
+		}, RejectMissingInputs, 0},
+		{"bad signature", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			tx := signedSpend(t, priv, utxoSet, mempool, 900, fund)
+			breakSig(tx)
+			return tx
+		}, RejectBadSignature, 0},
+		{"pubkey hash mismatch", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			return signedSpend(t, otherPriv, utxoSet, mempool, 900, fund)
+		}, RejectPubKeyHashMismatch, 0},
+		{"outputs exceed inputs", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			return signedSpend(t, priv, utxoSet, mempool, 1001, fund)
+		}, RejectOutputsExceedInputs, -1},
+		{"duplicate input", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			return signedSpend(t, priv, utxoSet, mempool, 900, fund, fund)
+		}, RejectMalformed, 1},
+		{"already in mempool", func(t *testing.T, priv ed25519.PrivateKey, utxoSet UTXOProvider, mempool Mempool) *Transaction {
+			tx := signedSpend(t, priv, utxoSet, mempool, 900, fund)
+			if _, err := AcceptTransaction(tx, utxoSet, mempool); err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}, RejectDuplicate, -1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			priv, _, utxoSet := acceptFixture(t)
+			mempool := NewInMemoryMempool()
+			tx := c.build(t, priv, utxoSet, mempool)
+			size := mempool.Size()
+
+			for _, accept := range []func(*Transaction, UTXOProvider, Mempool) (AcceptResult, error){
+				TestAcceptTransaction, AcceptTransaction,
+			} {
+				_, err := accept(tx, utxoSet, mempool)
+				var rej *RejectError
+				if !errors.As(err, &rej) {
+					t.Fatalf("got %v, want a RejectError", err)
+				}
+				if rej.Code != c.code || rej.Input != c.input || rej.Txid != tx.Txid {
+					t.Fatalf("got %v (code %d, input %d), want %s input %d", err, rej.Code, rej.Input, c.code, c.input)
+				}
+			}
+			if mempool.Size() != size {
+				t.Fatal("rejected tx changed the mempool")
+			}
+		})
+	}
+}
+
+func TestTestAcceptDoesNotInsert(t *testing.T) {
+	priv, _, utxoSet := acceptFixture(t)
+	mempool := NewInMemoryMempool()
+	tx := signedSpend(t, priv, utxoSet, mempool, 900, VIN{Txid: cacheTxid(700)})
+
+	res, err := TestAcceptTransaction(tx, utxoSet, mempool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fee != 100 || res.Size != tx.Size() || mempool.Size() != 0 {
+		t.Fatalf("test accept: %+v, mempool %d", res, mempool.Size())
+	}
+
+	res, err = AcceptTransaction(tx, utxoSet, mempool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := mempool.Entry(tx.Txid); !ok || info.Fee != res.Fee {
+		t.Fatal("accepted tx missing or fee not recorded")
+	}
+}
+
+func TestAcceptMinFee(t *testing.T) {
+	priv, _, utxoSet := acceptFixture(t)
+	mempool := NewInMemoryMempool()
+	mempool.minFee = 1000 // far above 100 / ~150 bytes
+	mempool.minFeeTime = mempool.now()
+	tx := signedSpend(t, priv, utxoSet, mempool, 900, VIN{Txid: cacheTxid(700)})
+
+	if _, err := TestAcceptTransaction(tx, utxoSet, mempool); RejectCodeOf(err) != RejectMinFee {
+		t.Fatalf("test accept: %v", err)
+	}
+	_, err := AcceptTransaction(tx, utxoSet, mempool)
+	if RejectCodeOf(err) != RejectMinFee || !errors.Is(err, ErrMempoolMinFee) {
+		t.Fatalf("accept: %v", err)
+	}
+}
+
+// conflicting submissions racing each other: one gets in, the others are
+// double spends, and the pool stays consistent
+func TestAcceptConcurrentConflicts(t *testing.T) {
+	for name, open := range mempoolBackends() {
+		t.Run(name, func(t *testing.T) {
+			priv, _, utxoSet := acceptFixture(t)
+			mempool := open(t)
+			fund := VIN{Txid: cacheTxid(700), Vout: 0}
+
+			var txs []*Transaction
+			for i := 0; i < 8; i++ {
+				txs = append(txs, signedSpend(t, priv, utxoSet, mempool, int64(900-i), fund))
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, len(txs))
+			for i, tx := range txs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = AcceptTransaction(tx, utxoSet, mempool)
+				}()
+			}
+			wg.Wait()
+
+			var winner *Transaction
+			for i, err := range errs {
+				switch {
+				case err == nil:
+					if winner != nil {
+						t.Fatal("two conflicting txs accepted")
+					}
+					winner = txs[i]
+				case RejectCodeOf(err) != RejectDoubleSpend:
+					t.Fatalf("tx %d: %v", i, err)
+				}
+			}
+			if winner == nil || mempool.Size() != 1 {
+				t.Fatalf("winner %v, pool holds %d", winner, mempool.Size())
+			}
+
+			// the winner's spent mark survives, and goes with it
+			if err := mempool.RemoveTransaction(winner); err != nil {
+				t.Fatal(err)
+			}
+			if mempool.IsSpent(fund.Txid, fund.Vout) || mempool.Size() != 0 {
+				t.Fatal("pool inconsistent after removing the winner")
+			}
+		})
+	}
+}
+
+// staleMempool: a pool whose IsSpent answers before a conflicting add landed
+type staleMempool struct{ *InMemoryMempool }
+
+func (staleMempool) IsSpent(string, int) bool { return false }
+
+// the pool's own check decides when CheckMempoolTx saw a stale pool
+func TestAcceptDoubleSpendFromPool(t *testing.T) {
+	priv, _, utxoSet := acceptFixture(t)
+	mempool := NewInMemoryMempool()
+	fund := VIN{Txid: cacheTxid(700), Vout: 0}
+
+	first := signedSpend(t, priv, utxoSet, mempool, 900, fund)
+	if _, err := AcceptTransaction(first, utxoSet, mempool); err != nil {
+		t.Fatal(err)
+	}
+	second := signedSpend(t, priv, utxoSet, mempool, 800, fund)
+	_, err := AcceptTransaction(second, utxoSet, staleMempool{mempool})
+	if RejectCodeOf(err) != RejectDoubleSpend || !errors.Is(err, ErrMempoolDoubleSpend) {
+		t.Fatalf("second: %v", err)
+	}
+	if mempool.Size() != 1 || mempool.spent[viewKey(fund.Txid, fund.Vout)] != first.Txid {
+		t.Fatal("first tx's spent mark overwritten")
+	}
+}
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// MempoolLimits bound an InMemoryMempool
+type MempoolLimits struct {
+	// MaxBytes: total serialized size above which the lowest fee-rate
+	// packages are evicted with their descendants, down to
+	// trimTargetPercent of it (0 = unbounded)
+	MaxBytes int
+
+	// Expiry: age after which a tx is dropped with its descendants
+	// (0 = never)
+	Expiry time.Duration
+}
+
+const (
+	DefaultMempoolMaxBytes = 300 << 20
+	DefaultMempoolExpiry   = 14 * 24 * time.Hour
+
+	// IncrementalFeeRate: after an eviction the minimum fee rate is raised
+	// to the evicted package's rate plus this, so the tx that replaces it
+	// must pay strictly more
+	IncrementalFeeRate = 1.0
+
+	// MinFeeHalfLife: how fast the minimum fee rate decays back to zero
+	MinFeeHalfLife = 12 * time.Hour
+
+	// minOrderCompaction: removed entries kept in the order list before it
+	// is worth rebuilding
+	minOrderCompaction = 1024
+
+	// trimTargetPercent: a trim goes down to this share of MaxBytes, so its
+	// sort is paid once per that many bytes of inserts, not on every one
+	trimTargetPercent = 90
+)
+
+func DefaultMempoolLimits() MempoolLimits {
+	return MempoolLimits{
+		MaxBytes: DefaultMempoolMaxBytes,
+		Expiry:   DefaultMempoolExpiry,
+	}
+}
+
+var (
+	ErrMempoolMinFee = errors.New("fee rate below mempool minimum")
+	ErrMempoolFull   = errors.New("mempool full")
+)
+
+// orderItem: a tx in arrival order. Items of removed txs stay until the
+// next compaction; seq tells a re-added tx from its earlier stay.
+type orderItem struct {
+	txid string
+	seq  uint64
+}
+
+func (m *InMemoryMempool) liveLocked(it orderItem) (*mempoolEntry, bool) {
+	e, ok := m.entries[it.txid]
+	if !ok || e.seq != it.seq {
+		return nil, false
+	}
+	return e, true
+}
+
+// MinFeeRate: fee per byte a tx needs to enter the pool. Zero until an
+// eviction, then decays with MinFeeHalfLife.
+func (m *InMemoryMempool) MinFeeRate() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.minFeeRateLocked(m.now())
+}
+
+func (m *InMemoryMempool) minFeeRateLocked(now time.Time) float64 {
+	if m.minFee == 0 {
+		return 0
+	}
+	halvings := float64(now.Sub(m.minFeeTime)) / float64(MinFeeHalfLife)
+	rate := m.minFee * math.Pow(0.5, max(halvings, 0))
+	if rate < IncrementalFeeRate/2 {
+		return 0
+	}
+	return rate
+}
+
+func (m *InMemoryMempool) bumpMinFeeLocked(rate float64, now time.Time) {
+	m.minFee = max(m.minFeeRateLocked(now), rate)
+	m.minFeeTime = now
+}
+
+// checkMinFeeLocked rejects e if it pays less than the current minimum
+func (m *InMemoryMempool) checkMinFeeLocked(e *mempoolEntry, now time.Time) error {
+	minRate := m.minFeeRateLocked(now)
+	if rate := e.own().feeRate(); rate < minRate {
+		return fmt.Errorf("%w: %.2f < %.2f per byte", ErrMempoolMinFee, rate, minRate)
+	}
+	return nil
+}
+
+// Expire drops txs older than Limits.Expiry, with their descendants, and
+// returns how many went. Also done on every add and block.
+func (m *InMemoryMempool) Expire() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := len(m.entries)
+	m.expireLocked(m.now())
+	m.compactOrderLocked()
+	return before - len(m.entries)
+}
+
+// expireLocked: the order list is by arrival, so expired txs are at its
+// front; the items it walks over are dropped on the way
+func (m *InMemoryMempool) expireLocked(now time.Time) {
+	if m.limits.Expiry <= 0 {
+		return
+	}
+	cutoff := now.Add(-m.limits.Expiry)
+	stale := make(map[string]bool)
+
+	i := 0
+	for ; i < len(m.order); i++ {
+		e, live := m.liveLocked(m.order[i])
+		if live && !e.added.Before(cutoff) {
+			break
+		}
+		if live {
+			m.removeWithDescendantsLocked(e.tx.Txid, stale)
+		}
+	}
+	// every item before i is now dead
+	m.order = m.order[i:]
+	m.orderDead -= i
+
+	m.refreshLocked(stale)
+}
+
+// trimLocked: once the pool is over MaxBytes, evicts packages (a tx and its
+// descendants) lowest descendant fee rate first, newest first among equals,
+// down to trimTargetPercent of it. Each eviction raises the minimum fee rate
+// above the evicted package.
+func (m *InMemoryMempool) trimLocked(now time.Time) {
+	if m.limits.MaxBytes <= 0 || m.totalSize <= m.limits.MaxBytes {
+		return
+	}
+	target := m.limits.MaxBytes * trimTargetPercent / 100
+
+	desc := m.descendantTotalsLocked()
+	cands := make([]*mempoolEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		cands = append(cands, e)
+	}
+	slices.SortFunc(cands, func(a, b *mempoolEntry) int {
+		ra, rb := desc[a.tx.Txid].feeRate(), desc[b.tx.Txid].feeRate()
+		switch {
+		case ra < rb:
+			return -1
+		case ra > rb:
+			return 1
+		}
+		return compareSeq(b, a)
+	})
+
+	stale := make(map[string]bool)
+	for _, e := range cands {
+		if m.totalSize <= target {
+			break
+		}
+		if _, ok := m.entries[e.tx.Txid]; !ok {
+			continue // evicted as a descendant
+		}
+		m.bumpMinFeeLocked(desc[e.tx.Txid].feeRate()+IncrementalFeeRate, now)
+		m.removeWithDescendantsLocked(e.tx.Txid, stale)
+	}
+	m.refreshLocked(stale)
+}
+
+// descendantTotalsLocked: each tx plus its descendants, summed children
+// first in one pass over the order list. A descendant reachable along two
+// paths is counted on both, which only matters when ranking evictions.
+func (m *InMemoryMempool) descendantTotalsLocked() map[string]packageTotals {
+	desc := make(map[string]packageTotals, len(m.entries))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		e, live := m.liveLocked(m.order[i])
+		if !live {
+			continue
+		}
+		total := e.own()
+		for c := range e.children {
+			total = total.plus(desc[c])
+		}
+		desc[e.tx.Txid] = total
+	}
+	return desc
+}
+
+// compactOrderLocked rebuilds the order list once removed txs outnumber
+// the live ones
+func (m *InMemoryMempool) compactOrderLocked() {
+	if m.orderDead < minOrderCompaction || m.orderDead < len(m.entries) {
+		return
+	}
+	order := make([]orderItem, 0, len(m.entries))
+	for _, it := range m.order {
+		if _, live := m.liveLocked(it); live {
+			order = append(order, it)
+		}
+	}
+	m.order = order
+	m.orderDead = 0
+}
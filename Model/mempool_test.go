@@ -0,0 +1,544 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// mempoolBackends: every Mempool implementation. RedisMempool runs against an
+// in-process server, or a real one with MEMPOOL_REDIS_ADDR=localhost:6379
+// (its mempool:* keys are wiped).
+func mempoolBackends() map[string]func(t *testing.T) Mempool {
+	return map[string]func(t *testing.T) Mempool{
+		"memory": func(t *testing.T) Mempool { return NewInMemoryMempool() },
+		"redis":  func(t *testing.T) Mempool { return openTestRedisMempool(t, testRedisAddr(t)) },
+	}
+}
+
+// testRedisAddr: MEMPOOL_REDIS_ADDR, or a miniredis server for this test
+func testRedisAddr(t *testing.T) string {
+	if addr := os.Getenv("MEMPOOL_REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	return miniredis.RunT(t).Addr()
+}
+
+// openTestRedisMempool connects to addr; the first client of a test also
+// wipes the pool
+func openTestRedisMempool(t *testing.T, addr string) *RedisMempool {
+	m := NewRedisMempool(addr)
+	if err := m.Ping(); err != nil {
+		t.Skipf("redis: %v", err)
+	}
+	clearRedisMempool(t, m)
+	t.Cleanup(func() {
+		clearRedisMempool(t, m)
+		m.Close()
+	})
+	return m
+}
+
+func clearRedisMempool(t *testing.T, m *RedisMempool) {
+	keys, err := m.rdb.Keys(m.ctx, "mempool:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) > 0 {
+		if err := m.rdb.Del(m.ctx, keys...).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mempoolTestTx spends each of ins (output 0) into n outputs of value 1
+func mempoolTestTx(id int, n int, ins ...string) *Transaction {
+	tx := &Transaction{Version: 1, Txid: cacheTxid(id)}
+	for _, in := range ins {
+		tx.Vin = append(tx.Vin, VIN{Txid: in})
+	}
+	for i := 0; i < n; i++ {
+		tx.Vout = append(tx.Vout, VOUT{Value: 1, N: i,
+			ScriptPubKey: MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")})
+	}
+	return tx
+}
+
+func txids(txs []*Transaction) []string {
+	var res []string
+	for _, tx := range txs {
+		res = append(res, tx.Txid)
+	}
+	return res
+}
+
+func TestMempoolConformance(t *testing.T) {
+	for name, open := range mempoolBackends() {
+		t.Run(name, func(t *testing.T) {
+			m := open(t)
+			confirmed := cacheTxid(100)
+
+			parent := mempoolTestTx(1, 2, confirmed)
+			child := mempoolTestTx(2, 1, parent.Txid)
+			other := mempoolTestTx(3, 3, cacheTxid(101))
+			for _, tx := range []*Transaction{parent, child, other} {
+				if err := m.AddTransaction(tx, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.AddTransaction(parent, 0); err == nil {
+				t.Fatal("duplicate tx accepted")
+			}
+			if err := m.AddTransaction(mempoolTestTx(8, 1, confirmed), 0); !errors.Is(err, ErrMempoolDoubleSpend) {
+				t.Fatalf("conflicting tx: %v", err)
+			}
+
+			if got := m.GetTransaction(parent.Txid); got == nil || !reflect.DeepEqual(*got, *parent) {
+				t.Fatalf("GetTransaction = %+v", got)
+			}
+			if m.GetTransaction(cacheTxid(9)) != nil {
+				t.Fatal("unknown tx found")
+			}
+			if !m.IsSpent(confirmed, 0) || !m.IsSpent(parent.Txid, 0) || m.IsSpent(parent.Txid, 1) {
+				t.Fatal("spent marks wrong")
+			}
+			if out, ok := m.GetOutput(parent.Txid, 1); !ok || out.Value != 1 {
+				t.Fatal("unconfirmed output missing")
+			}
+			wantBytes := parent.Size() + child.Size() + other.Size()
+			if m.Size() != 3 || m.TotalBytes() != wantBytes {
+				t.Fatalf("size %d / %d bytes, want 3 / %d", m.Size(), m.TotalBytes(), wantBytes)
+			}
+
+			// equal fee rates: arrival order, other does not fit
+			snap := m.SnapshotUntilSize(parent.Size() + child.Size())
+			if !reflect.DeepEqual(snap.TxIDs, []string{parent.Txid, child.Txid}) ||
+				snap.Size != parent.Size()+child.Size() {
+				t.Fatalf("snapshot = %+v", snap)
+			}
+
+			// a block spending the parent's input elsewhere evicts parent and child
+			conflict := *mempoolTestTx(4, 1, confirmed)
+			m.RemoveForBlock(&Block{Transactions: []Transaction{conflict, *other}})
+			if m.Size() != 0 || m.TotalBytes() != 0 {
+				t.Fatalf("after block: %d txs, %d bytes", m.Size(), m.TotalBytes())
+			}
+			if m.IsSpent(confirmed, 0) {
+				t.Fatal("spent mark of a removed tx left behind")
+			}
+
+			if err := m.RemoveTransaction(parent); err != nil {
+				t.Fatal("removing an absent tx:", err)
+			}
+
+			a, b, c := mempoolTestTx(5, 1, cacheTxid(102)), mempoolTestTx(6, 1, cacheTxid(103)), mempoolTestTx(7, 1, cacheTxid(104))
+			for _, tx := range []*Transaction{a, b, c} {
+				if err := m.AddTransaction(tx, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.RemoveTransaction(b); err != nil {
+				t.Fatal(err)
+			}
+			if got := txids(m.Drain()); !reflect.DeepEqual(got, []string{a.Txid, c.Txid}) {
+				t.Fatalf("Drain = %v", got)
+			}
+			if m.Size() != 0 || m.TotalBytes() != 0 || len(m.SnapshotUntilSize(1<<20).TxIDs) != 0 {
+				t.Fatal("pool not empty after Drain")
+			}
+		})
+	}
+}
+
+// two processes sharing a Redis pool must not both get a spend of the same
+// output in, nor remove each other's spent marks
+func TestRedisMempoolConflict(t *testing.T) {
+	addr := testRedisAddr(t)
+	first := openTestRedisMempool(t, addr)
+	confirmed := cacheTxid(100)
+
+	const clients = 8
+	var (
+		wg       sync.WaitGroup
+		accepted []string
+		mu       sync.Mutex
+	)
+	for i := 0; i < clients; i++ {
+		m := first
+		if i > 0 {
+			m = NewRedisMempool(addr)
+			defer m.Close()
+		}
+		wg.Add(1)
+		go func(i int, m *RedisMempool) {
+			defer wg.Done()
+			tx := mempoolTestTx(1+i, 1, confirmed)
+			err := m.AddTransaction(tx, 0)
+			switch {
+			case err == nil:
+				mu.Lock()
+				accepted = append(accepted, tx.Txid)
+				mu.Unlock()
+			case !errors.Is(err, ErrMempoolDoubleSpend):
+				t.Errorf("client %d: %v", i, err)
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	if len(accepted) != 1 || first.Size() != 1 {
+		t.Fatalf("accepted %v, pool holds %d", accepted, first.Size())
+	}
+
+	// a mark another tx owns (written by an older build) stays on remove
+	winner := first.GetTransaction(accepted[0])
+	if err := first.rdb.Set(first.ctx, mempoolSpentKey(confirmed, 0), "other", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.RemoveTransaction(winner); err != nil {
+		t.Fatal(err)
+	}
+	if spender, _ := first.spender(confirmed, 0); spender != "other" || first.Size() != 0 {
+		t.Fatalf("after remove: mark %q, %d txs", spender, first.Size())
+	}
+}
+
+func TestBlockTemplate(t *testing.T) {
+	for name, open := range mempoolBackends() {
+		t.Run(name, func(t *testing.T) {
+			m := open(t)
+
+			// parent pays nothing, its child pays for both (CPFP) and beats
+			// mid, whose own rate is lower than the package's
+			parent := mempoolTestTx(1, 1, cacheTxid(100))
+			child := mempoolTestTx(2, 1, parent.Txid)
+			mid := mempoolTestTx(3, 1, cacheTxid(101))
+			low := mempoolTestTx(4, 1, cacheTxid(102))
+			big := mempoolTestTx(5, 40, cacheTxid(103))
+			add := func(tx *Transaction, rate int) {
+				t.Helper()
+				if err := m.AddTransaction(tx, int64(rate*tx.Size())); err != nil {
+					t.Fatal(err)
+				}
+			}
+			add(parent, 0)
+			add(low, 1)
+			add(big, 50)
+			add(mid, 3)
+			add(child, 10)
+
+			all := parent.Size() + child.Size() + mid.Size() + low.Size()
+			snap := m.SnapshotUntilSize(all)
+			want := []string{parent.Txid, child.Txid, mid.Txid, low.Txid}
+			if !reflect.DeepEqual(snap.TxIDs, want) || snap.Size != all {
+				t.Fatalf("template = %v (%d bytes), want %v (big does not fit)", snap.TxIDs, snap.Size, want)
+			}
+			wantFees := int64(10*child.Size() + 3*mid.Size() + low.Size())
+			if snap.Fees != wantFees {
+				t.Fatalf("fees = %d, want %d", snap.Fees, wantFees)
+			}
+
+			// the child alone never goes in without its parent
+			snap = m.SnapshotUntilSize(child.Size())
+			for _, txid := range snap.TxIDs {
+				if txid == child.Txid {
+					t.Fatal("child selected without its parent")
+				}
+			}
+
+			snap = m.SnapshotUntilSize(1 << 20)
+			if snap.TxIDs[0] != big.Txid || len(snap.TxIDs) != 5 {
+				t.Fatalf("template = %v", snap.TxIDs)
+			}
+		})
+	}
+}
+
+// scores follow the selection: a child that rode on its parent's fee drops
+// once the parent is in, a sibling of a selected tx rises
+func TestBlockTemplateRescoresDescendants(t *testing.T) {
+	for name, open := range mempoolBackends() {
+		t.Run(name, func(t *testing.T) {
+			m := open(t)
+			add := func(tx *Transaction, rate int) {
+				t.Helper()
+				if err := m.AddTransaction(tx, int64(rate*tx.Size())); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			parent := mempoolTestTx(1, 1, cacheTxid(100))
+			free := mempoolTestTx(2, 1, parent.Txid)
+			unrelated := mempoolTestTx(3, 1, cacheTxid(101))
+			add(parent, 10)
+			add(free, 0)
+			add(unrelated, 4)
+
+			snap := m.SnapshotUntilSize(parent.Size() + unrelated.Size())
+			if want := []string{parent.Txid, unrelated.Txid}; !reflect.DeepEqual(snap.TxIDs, want) {
+				t.Fatalf("template = %v, want parent + unrelated", snap.TxIDs)
+			}
+			if want := int64(10*parent.Size() + 4*unrelated.Size()); snap.Fees != want {
+				t.Fatalf("fees = %d, want %d", snap.Fees, want)
+			}
+
+			// base pays nothing; rich pulls it in, then sibling's own rate
+			// beats other
+			base := mempoolTestTx(4, 2, cacheTxid(102))
+			rich := mempoolTestTx(5, 1, base.Txid)
+			sibling := &Transaction{Version: 1, Txid: cacheTxid(6), Vin: []VIN{{Txid: base.Txid, Vout: 1}}, Vout: rich.Vout}
+			other := mempoolTestTx(7, 1, cacheTxid(103))
+			m.Drain()
+			add(base, 0)
+			add(rich, 20)
+			add(sibling, 6)
+			add(other, 4)
+
+			snap = m.SnapshotUntilSize(base.Size() + rich.Size() + sibling.Size())
+			if want := []string{base.Txid, rich.Txid, sibling.Txid}; !reflect.DeepEqual(snap.TxIDs, want) {
+				t.Fatalf("template = %v, want base, rich, sibling", snap.TxIDs)
+			}
+		})
+	}
+}
+
+// a long chain re-scores past maxTemplateDescendantUpdates without picking a
+// child on a stale score
+func TestBlockTemplateLongChain(t *testing.T) {
+	m := NewInMemoryMempool()
+	prev := cacheTxid(1000)
+	var chain []*Transaction
+	for i := 0; i < 3*maxTemplateDescendantUpdates; i++ {
+		tx := mempoolTestTx(i+1, 1, prev)
+		rate := 1
+		if i == 0 {
+			rate = 1000 // the head lifts children past the update limit above late
+		}
+		if err := m.AddTransaction(tx, int64(rate*tx.Size())); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, tx)
+		prev = tx.Txid
+	}
+	late := mempoolTestTx(5000, 1, cacheTxid(1001))
+	if err := m.AddTransaction(late, int64(2*late.Size())); err != nil {
+		t.Fatal(err)
+	}
+
+	// head, then late (2/byte) before any 1/byte child
+	snap := m.SnapshotUntilSize(chain[0].Size() + late.Size() + chain[1].Size())
+	if want := []string{chain[0].Txid, late.Txid, chain[1].Txid}; !reflect.DeepEqual(snap.TxIDs, want) {
+		t.Fatalf("template = %v", snap.TxIDs[:min(len(snap.TxIDs), 4)])
+	}
+	// with room for all, the children past the limit still rank below late
+	snap = m.SnapshotUntilSize(1 << 20)
+	if len(snap.TxIDs) != len(chain)+1 || snap.TxIDs[1] != late.Txid {
+		t.Fatalf("full template: %d txs, second %s", len(snap.TxIDs), snap.TxIDs[1])
+	}
+}
+
+func TestMempoolPackages(t *testing.T) {
+	m := NewInMemoryMempool()
+
+	// a → b → c, d spends a and c
+	a := mempoolTestTx(1, 2, cacheTxid(100))
+	b := mempoolTestTx(2, 1, a.Txid)
+	c := mempoolTestTx(3, 1, b.Txid)
+	d := mempoolTestTx(4, 1, c.Txid)
+	d.Vin = append(d.Vin, VIN{Txid: a.Txid, Vout: 1})
+	for i, tx := range []*Transaction{a, b, c, d} {
+		if err := m.AddTransaction(tx, int64(10*(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(tx *Transaction, ancCount int, ancFees int64, descCount int, descFees int64) {
+		t.Helper()
+		info, ok := m.Entry(tx.Txid)
+		if !ok {
+			t.Fatalf("%s missing", tx.Txid[:8])
+		}
+		if info.AncestorCount != ancCount || info.AncestorFees != ancFees ||
+			info.DescendantCount != descCount || info.DescendantFees != descFees {
+			t.Fatalf("%s: ancestors %d/%d descendants %d/%d, want %d/%d %d/%d",
+				tx.Txid[:8], info.AncestorCount, info.AncestorFees, info.DescendantCount, info.DescendantFees,
+				ancCount, ancFees, descCount, descFees)
+		}
+	}
+	check(a, 1, 10, 4, 100)
+	check(b, 2, 30, 3, 90)
+	check(d, 4, 100, 1, 40) // a counted once
+	wantSize := a.Size() + b.Size() + c.Size() + d.Size()
+	if info, _ := m.Entry(d.Txid); info.AncestorSize != wantSize {
+		t.Fatalf("ancestor size %d, want %d", info.AncestorSize, wantSize)
+	}
+
+	// a and b confirmed: c and d lose them as ancestors
+	m.RemoveForBlock(&Block{Transactions: []Transaction{*a, *b}})
+	check(c, 1, 30, 2, 70)
+	check(d, 2, 70, 1, 40)
+
+	if err := m.RemoveTransaction(c); err != nil {
+		t.Fatal(err)
+	}
+	check(d, 1, 40, 1, 40)
+}
+
+func TestMempoolLimits(t *testing.T) {
+	// room for 3 txs, and the trim target too
+	size := mempoolTestTx(1, 1, cacheTxid(100)).Size()
+	m := NewInMemoryMempoolWithLimits(MempoolLimits{MaxBytes: 3*size + size/2})
+	clock := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return clock }
+
+	add := func(tx *Transaction, rate int) error {
+		return m.AddTransaction(tx, int64(rate*tx.Size()))
+	}
+	hi := mempoolTestTx(1, 1, cacheTxid(100))
+	lowParent := mempoolTestTx(2, 1, cacheTxid(101))
+	lowChild := mempoolTestTx(3, 1, lowParent.Txid)
+	for _, c := range []struct {
+		tx   *Transaction
+		rate int
+	}{{hi, 5}, {lowParent, 1}, {lowChild, 2}} {
+		if err := add(c.tx, c.rate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.MinFeeRate() != 0 {
+		t.Fatal("min fee set before any eviction")
+	}
+
+	// over the limit: the parent's package (rate 1.5) goes, child included
+	next := mempoolTestTx(4, 1, cacheTxid(102))
+	if err := add(next, 3); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 2 || m.GetTransaction(lowParent.Txid) != nil || m.GetTransaction(lowChild.Txid) != nil {
+		t.Fatalf("after eviction: %d txs", m.Size())
+	}
+	if got := m.MinFeeRate(); got != 1.5+IncrementalFeeRate {
+		t.Fatalf("min fee rate %v, want %v", got, 1.5+IncrementalFeeRate)
+	}
+	if m.IsSpent(cacheTxid(101), 0) {
+		t.Fatal("spent mark of an evicted tx left behind")
+	}
+
+	if err := add(mempoolTestTx(5, 1, cacheTxid(103)), 2); !errors.Is(err, ErrMempoolMinFee) {
+		t.Fatalf("below min fee: %v", err)
+	}
+	if err := add(mempoolTestTx(6, 1, cacheTxid(104)), 4); err != nil {
+		t.Fatal(err)
+	}
+	// full again; a newcomer tying with next is the one evicted, and
+	// raises the minimum all the same
+	if err := add(mempoolTestTx(7, 1, cacheTxid(105)), 3); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("lowest newcomer: %v", err)
+	}
+	if m.Size() != 3 || m.GetTransaction(next.Txid) == nil {
+		t.Fatal("pool changed by a rejected tx")
+	}
+	if got := m.MinFeeRate(); got != 3+IncrementalFeeRate {
+		t.Fatalf("min fee rate %v, want %v", got, 3+IncrementalFeeRate)
+	}
+
+	// the minimum decays by half every MinFeeHalfLife, then drops to zero
+	clock = clock.Add(MinFeeHalfLife)
+	if got := m.MinFeeRate(); got != (3+IncrementalFeeRate)/2 {
+		t.Fatalf("min fee after one half-life: %v", got)
+	}
+	clock = clock.Add(3 * MinFeeHalfLife)
+	if got := m.MinFeeRate(); got != 0 {
+		t.Fatalf("min fee after four half-lives: %v", got)
+	}
+}
+
+// a trim goes below the limit, so the next inserts do not trim at all
+func TestMempoolTrimTarget(t *testing.T) {
+	size := mempoolTestTx(1, 1, cacheTxid(100)).Size()
+	m := NewInMemoryMempoolWithLimits(MempoolLimits{MaxBytes: 10 * size})
+
+	for i := 1; i <= 11; i++ {
+		if err := m.AddTransaction(mempoolTestTx(i, 1, cacheTxid(100+i)), int64(i*size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 11 > 10 txs: trimmed to 9 (90%), the two cheapest gone
+	if m.Size() != 9 || m.GetTransaction(cacheTxid(1)) != nil || m.GetTransaction(cacheTxid(2)) != nil {
+		t.Fatalf("after trim: %d txs", m.Size())
+	}
+	if err := m.AddTransaction(mempoolTestTx(12, 1, cacheTxid(112)), int64(12*size)); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 10 || m.GetTransaction(cacheTxid(3)) == nil {
+		t.Fatalf("insert under the limit trimmed: %d txs", m.Size())
+	}
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	m := NewInMemoryMempoolWithLimits(MempoolLimits{Expiry: time.Hour})
+	clock := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return clock }
+
+	a := mempoolTestTx(1, 1, cacheTxid(100))
+	b := mempoolTestTx(2, 1, cacheTxid(101))
+	child := mempoolTestTx(3, 1, a.Txid)
+	c := mempoolTestTx(4, 1, cacheTxid(102))
+	for _, tx := range []*Transaction{a, b, child, c} {
+		if err := m.AddTransaction(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(20 * time.Minute)
+	}
+
+	// a and b are over an hour old; child is not, but goes with a
+	clock = clock.Add(10 * time.Minute)
+	if n := m.Expire(); n != 3 {
+		t.Fatalf("expired %d txs, want 3", n)
+	}
+	if got := txids(m.Drain()); !reflect.DeepEqual(got, []string{c.Txid}) {
+		t.Fatalf("left after expiry: %v", got)
+	}
+}
+
+func TestMempoolOrderCompaction(t *testing.T) {
+	m := NewInMemoryMempool()
+
+	keep := mempoolTestTx(0, 1, cacheTxid(100_000))
+	if err := m.AddTransaction(keep, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3*minOrderCompaction; i++ {
+		tx := mempoolTestTx(i, 1, cacheTxid(100_000+i))
+		if err := m.AddTransaction(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.RemoveTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.order) > minOrderCompaction+1 {
+		t.Fatalf("order holds %d items for %d txs", len(m.order), m.Size())
+	}
+
+	// a re-added tx is ordered by its latest arrival
+	a := mempoolTestTx(1, 1, cacheTxid(100_001))
+	b := mempoolTestTx(2, 1, cacheTxid(100_002))
+	for _, step := range []func() error{
+		func() error { return m.AddTransaction(a, 0) },
+		func() error { return m.AddTransaction(b, 0) },
+		func() error { return m.RemoveTransaction(a) },
+		func() error { return m.AddTransaction(a, 0) },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := txids(m.Drain()); !reflect.DeepEqual(got, []string{keep.Txid, b.Txid, a.Txid}) {
+		t.Fatalf("Drain = %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// MuHash is an order-independent hash of a set of byte strings, after
+// MuHash3072: each element is mapped to a number mod the prime
+// 2^3072 - 1103717 and the set hash is their product, so elements can be
+// added and removed in any order and two sets hash the same iff they hold
+// the same elements.
+//
+// Elements are expanded with SHA-256 in counter mode rather than ChaCha20,
+// so digests are not comparable with Bitcoin Core's.
+type MuHash struct {
+	num, den *big.Int
+}
+
+const muHashBytes = 384
+
+var muHashPrime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), 8*muHashBytes)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+// NewMuHash returns the hash of the empty set
+func NewMuHash() *MuHash {
+	return &MuHash{num: big.NewInt(1), den: big.NewInt(1)}
+}
+
+// muHashElement maps data to a number in [1, p)
+func muHashElement(data []byte) *big.Int {
+	seed := sha256.Sum256(data)
+	buf := make([]byte, 0, muHashBytes)
+	var block [36]byte
+	copy(block[:], seed[:])
+	for i := uint32(0); len(buf) < muHashBytes; i++ {
+		binary.LittleEndian.PutUint32(block[32:], i)
+		h := sha256.Sum256(block[:])
+		buf = append(buf, h[:]...)
+	}
+	slices.Reverse(buf) // little-endian number
+	x := new(big.Int).SetBytes(buf)
+	if x.Cmp(muHashPrime) >= 0 {
+		x.Sub(x, muHashPrime)
+	}
+	if x.Sign() == 0 {
+		x.SetInt64(1) // needs a ~2^-3072 SHA-256 output
+	}
+	return x
+}
+
+// Insert adds data to the set
+func (m *MuHash) Insert(data []byte) {
+	m.num.Mul(m.num, muHashElement(data))
+	m.num.Mod(m.num, muHashPrime)
+}
+
+// Remove takes data out of the set; it must have been inserted
+func (m *MuHash) Remove(data []byte) {
+	m.den.Mul(m.den, muHashElement(data))
+	m.den.Mod(m.den, muHashPrime)
+}
+
+// normalize folds the removals into num (one modular inverse)
+func (m *MuHash) normalize() {
+	if m.den.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+	m.num.Mul(m.num, new(big.Int).ModInverse(m.den, muHashPrime))
+	m.num.Mod(m.num, muHashPrime)
+	m.den.SetInt64(1)
+}
+
+// Digest: SHA-256 of the set's number, 384 bytes little-endian
+func (m *MuHash) Digest() [32]byte {
+	return sha256.Sum256(m.MarshalBinary())
+}
+
+// MarshalBinary returns the 384-byte state
+func (m *MuHash) MarshalBinary() []byte {
+	m.normalize()
+	buf := m.num.FillBytes(make([]byte, muHashBytes))
+	slices.Reverse(buf)
+	return buf
+}
+
+// UnmarshalMuHash restores a state from MarshalBinary
+func UnmarshalMuHash(b []byte) (*MuHash, error) {
+	if len(b) != muHashBytes {
+		return nil, fmt.Errorf("muhash: state is %d bytes, want %d", len(b), muHashBytes)
+	}
+	be := slices.Clone(b)
+	slices.Reverse(be)
+	num := new(big.Int).SetBytes(be)
+	if num.Sign() == 0 || num.Cmp(muHashPrime) >= 0 {
+		return nil, fmt.Errorf("muhash: state out of range")
+	}
+	return &MuHash{num: num, den: big.NewInt(1)}, nil
+}
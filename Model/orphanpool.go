@@ -0,0 +1,299 @@
+package model
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OrphanLimits bound an OrphanPool
+type OrphanLimits struct {
+	MaxCount  int           // orphans held; the oldest goes first
+	MaxTxSize int           // larger txs are not held at all
+	Expiry    time.Duration // age after which an orphan is dropped
+}
+
+const (
+	DefaultMaxOrphans      = 100
+	DefaultMaxOrphanTxSize = 100_000
+	DefaultOrphanExpiry    = 20 * time.Minute
+)
+
+func DefaultOrphanLimits() OrphanLimits {
+	return OrphanLimits{
+		MaxCount:  DefaultMaxOrphans,
+		MaxTxSize: DefaultMaxOrphanTxSize,
+		Expiry:    DefaultOrphanExpiry,
+	}
+}
+
+var (
+	ErrTxOrphaned     = errors.New("held as orphan")
+	ErrOrphanTooLarge = errors.New("orphan tx too large")
+)
+
+type orphanEntry struct {
+	tx    *Transaction
+	added time.Time
+	seq   uint64
+
+	// missing: "txid:vout" of inputs in neither the UTXO set nor the mempool
+	missing []string
+}
+
+// OrphanPool holds txs spending outputs not known yet, until their parents
+// arrive in the mempool or in a block (see Promote).
+type OrphanPool struct {
+	mu sync.Mutex
+
+	// txid -> orphan
+	orphans map[string]*orphanEntry
+
+	// missing outpoint "txid:vout" -> txids of orphans waiting for it
+	byOutpoint map[string]map[string]struct{}
+
+	seq    uint64
+	limits OrphanLimits
+	now    func() time.Time
+}
+
+func NewOrphanPool(limits OrphanLimits) *OrphanPool {
+	return &OrphanPool{
+		orphans:    make(map[string]*orphanEntry),
+		byOutpoint: make(map[string]map[string]struct{}),
+		limits:     limits,
+		now:        time.Now,
+	}
+}
+
+// MissingInputs: "txid:vout" of the inputs of t found in neither utxoSet
+// nor mempool
+func MissingInputs(t *Transaction, utxoSet UTXOProvider, mempool Mempool) []string {
+	var missing []string
+	for _, vin := range t.Vin {
+		if vin.Txid == "" {
+			continue
+		}
+		if _, ok := utxoSet.Get(vin.Txid, vin.Vout); ok {
+			continue
+		}
+		if _, ok := mempool.GetOutput(vin.Txid, vin.Vout); ok {
+			continue
+		}
+		missing = append(missing, viewKey(vin.Txid, vin.Vout))
+	}
+	return missing
+}
+
+// Add holds tx until the outpoints in missing appear. Expired orphans are
+// dropped first, then the oldest if the pool is full.
+func (p *OrphanPool) Add(tx *Transaction, missing []string) error {
+	if size := tx.Size(); size > p.limits.MaxTxSize {
+		return fmt.Errorf("%w: %d bytes", ErrOrphanTooLarge, size)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.orphans[tx.Txid]; ok {
+		return fmt.Errorf("orphan %s already held", tx.Txid)
+	}
+
+	now := p.now()
+	p.expireLocked(now)
+	for len(p.orphans) > 0 && len(p.orphans) >= p.limits.MaxCount {
+		p.removeLocked(p.oldestLocked())
+	}
+
+	p.seq++
+	e := &orphanEntry{tx: tx, added: now, seq: p.seq}
+	p.orphans[tx.Txid] = e
+	p.setMissingLocked(e, missing)
+	return nil
+}
+
+func (p *OrphanPool) Has(txid string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.orphans[txid]
+	return ok
+}
+
+func (p *OrphanPool) Remove(txid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(txid)
+}
+
+func (p *OrphanPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.orphans)
+}
+
+// setMissingLocked re-keys e under missing
+func (p *OrphanPool) setMissingLocked(e *orphanEntry, missing []string) {
+	p.unindexLocked(e)
+	e.missing = missing
+	for _, key := range missing {
+		waiting, ok := p.byOutpoint[key]
+		if !ok {
+			waiting = make(map[string]struct{})
+			p.byOutpoint[key] = waiting
+		}
+		waiting[e.tx.Txid] = struct{}{}
+	}
+}
+
+func (p *OrphanPool) unindexLocked(e *orphanEntry) {
+	for _, key := range e.missing {
+		delete(p.byOutpoint[key], e.tx.Txid)
+		if len(p.byOutpoint[key]) == 0 {
+			delete(p.byOutpoint, key)
+		}
+	}
+}
+
+func (p *OrphanPool) removeLocked(txid string) {
+	e, ok := p.orphans[txid]
+	if !ok {
+		return
+	}
+	p.unindexLocked(e)
+	delete(p.orphans, txid)
+}
+
+func (p *OrphanPool) oldestLocked() string {
+	var oldest *orphanEntry
+	for _, e := range p.orphans {
+		if oldest == nil || e.seq < oldest.seq {
+			oldest = e
+		}
+	}
+	return oldest.tx.Txid
+}
+
+func (p *OrphanPool) expireLocked(now time.Time) {
+	if p.limits.Expiry <= 0 {
+		return
+	}
+	for txid, e := range p.orphans {
+		if now.Sub(e.added) > p.limits.Expiry {
+			p.removeLocked(txid)
+		}
+	}
+}
+
+// Promote re-validates the orphans waiting for outputs of parents (just
+// accepted to mempool, or confirmed) and moves the valid ones into mempool,
+// then does the same for their own orphans. Orphans still missing other
+// inputs stay, re-keyed; invalid ones are dropped.
+// Returns the promoted txs, parents before children.
+func (p *OrphanPool) Promote(parents []*Transaction, utxoSet UTXOProvider, mempool Mempool) []*Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expireLocked(p.now())
+
+	var promoted []*Transaction
+	queue := slices.Clone(parents)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, e := range p.waitingLocked(parent) {
+			if _, ok := p.orphans[e.tx.Txid]; !ok {
+				continue
+			}
+			if missing := MissingInputs(e.tx, utxoSet, mempool); len(missing) > 0 {
+				p.setMissingLocked(e, missing)
+				continue
+			}
+
+			p.removeLocked(e.tx.Txid)
+			if _, err := AcceptTransaction(e.tx, utxoSet, mempool); err != nil {
+				continue
+			}
+			promoted = append(promoted, e.tx)
+			queue = append(queue, e.tx)
+		}
+	}
+	return promoted
+}
+
+// waitingLocked: orphans spending an output of parent, oldest first
+func (p *OrphanPool) waitingLocked(parent *Transaction) []*orphanEntry {
+	var res []*orphanEntry
+	seen := make(map[string]bool)
+	for i := range parent.Vout {
+		for txid := range p.byOutpoint[viewKey(parent.Txid, i)] {
+			if !seen[txid] {
+				seen[txid] = true
+				res = append(res, p.orphans[txid])
+			}
+		}
+	}
+	slices.SortFunc(res, func(a, b *orphanEntry) int { return cmp.Compare(a.seq, b.seq) })
+	return res
+}
+
+// PromoteForBlock drops orphans the block confirmed or conflicts with,
+// then promotes those waiting for its outputs
+func (p *OrphanPool) PromoteForBlock(block *Block, utxoSet UTXOProvider, mempool Mempool) []*Transaction {
+	spent := make(map[string]bool)
+	parents := make([]*Transaction, 0, len(block.Transactions))
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		parents = append(parents, tx)
+		for _, vin := range tx.Vin {
+			spent[viewKey(vin.Txid, vin.Vout)] = true
+		}
+	}
+
+	p.mu.Lock()
+	for txid, e := range p.orphans {
+		if slices.ContainsFunc(e.tx.Vin, func(vin VIN) bool {
+			return spent[viewKey(vin.Txid, vin.Vout)]
+		}) {
+			p.removeLocked(txid)
+		}
+	}
+	p.mu.Unlock()
+
+	return p.Promote(parents, utxoSet, mempool)
+}
+
+// ProcessTransaction admits tx to mempool (see AcceptTransaction), or holds
+// it in orphans when some input is unknown and it passes checkTxSanity: a
+// missing-inputs RejectError wrapping ErrTxOrphaned (orphans may be nil). Orphans waiting for tx are
+// promoted with it. Returns the txs added to mempool, tx first.
+func ProcessTransaction(tx *Transaction, utxoSet UTXOProvider, mempool Mempool, orphans *OrphanPool) ([]*Transaction, error) {
+	if mempool.GetTransaction(tx.Txid) != nil {
+		return nil, reject(tx, RejectDuplicate, -1, "")
+	}
+	if missing := MissingInputs(tx, utxoSet, mempool); len(missing) > 0 {
+		if orphans == nil {
+			return nil, reject(tx, RejectMissingInputs, -1, "%s not found", missing[0])
+		}
+		// a junk tx must not hold the txid of the real one while it waits
+		if err := checkTxSanity(tx); err != nil {
+			return nil, err
+		}
+		if err := orphans.Add(tx, missing); err != nil {
+			return nil, reject(tx, RejectMissingInputs, -1, "%s not found, %w", missing[0], err)
+		}
+		return nil, reject(tx, RejectMissingInputs, -1, "%s not found, %w", missing[0], ErrTxOrphaned)
+	}
+
+	if _, err := AcceptTransaction(tx, utxoSet, mempool); err != nil {
+		return nil, err
+	}
+	accepted := []*Transaction{tx}
+	if orphans != nil {
+		accepted = append(accepted, orphans.Promote(accepted, utxoSet, mempool)...)
+	}
+	return accepted, nil
+}
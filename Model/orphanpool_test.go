@@ -0,0 +1,210 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// orphanChain returns a confirmed-funded parent and its child, both signed;
+// the child is signed against a scratch mempool holding the parent
+func orphanChain(t *testing.T) (parent, child *Transaction, utxoSet *UTXOSet) {
+	t.Helper()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	utxoSet = NewUTXOSet()
+	fund := cacheTxid(500)
+	if err := utxoSet.Put(fund, 0, VOUT{Value: 1000, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}); err != nil {
+		t.Fatal(err)
+	}
+
+	parent = &Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: fund, Vout: 0}},
+		Vout:    []VOUT{{Value: 900, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}},
+	}
+	scratch := NewInMemoryMempool()
+	if err := parent.SignEd25519(priv, utxoSet, scratch); err != nil {
+		t.Fatal(err)
+	}
+	if err := scratch.AddTransaction(parent, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	child = &Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: parent.Txid, Vout: 0}},
+		Vout:    []VOUT{{Value: 800, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}},
+	}
+	if err := child.SignEd25519(priv, utxoSet, scratch); err != nil {
+		t.Fatal(err)
+	}
+	return parent, child, utxoSet
+}
+
+func TestOrphanPromotedWithParent(t *testing.T) {
+	parent, child, utxoSet := orphanChain(t)
+	mempool := NewInMemoryMempool()
+	orphans := NewOrphanPool(DefaultOrphanLimits())
+
+	if _, err := ProcessTransaction(child, utxoSet, mempool, orphans); !errors.Is(err, ErrTxOrphaned) {
+		t.Fatalf("child before parent: %v", err)
+	}
+	if !orphans.Has(child.Txid) || mempool.Size() != 0 {
+		t.Fatal("child not held as orphan")
+	}
+	if _, err := ProcessTransaction(child, utxoSet, mempool, nil); RejectCodeOf(err) != RejectMissingInputs || errors.Is(err, ErrTxOrphaned) {
+		t.Fatalf("without an orphan pool: %v", err)
+	}
+
+	accepted, err := ProcessTransaction(parent, utxoSet, mempool, orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := txids(accepted); !reflect.DeepEqual(got, []string{parent.Txid, child.Txid}) {
+		t.Fatalf("accepted %v", got)
+	}
+	if orphans.Size() != 0 || mempool.Size() != 2 {
+		t.Fatalf("orphans %d, mempool %d", orphans.Size(), mempool.Size())
+	}
+	if info, _ := mempool.Entry(child.Txid); info.Fee != 100 || info.AncestorCount != 2 {
+		t.Fatalf("promoted child entry: %+v", info)
+	}
+}
+
+func TestOrphanPromotedByBlock(t *testing.T) {
+	parent, child, utxoSet := orphanChain(t)
+	mempool := NewInMemoryMempool()
+	orphans := NewOrphanPool(DefaultOrphanLimits())
+
+	if _, err := ProcessTransaction(child, utxoSet, mempool, orphans); !errors.Is(err, ErrTxOrphaned) {
+		t.Fatal(err)
+	}
+
+	// an unrelated orphan double-spending the parent's input goes with the block
+	conflict := mempoolTestTx(7, 1, parent.Vin[0].Txid)
+	if err := orphans.Add(conflict, []string{viewKey(cacheTxid(999), 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	block := &Block{Transactions: []Transaction{*parent}}
+	if err := utxoSet.UpdateWithTransaction(*parent); err != nil {
+		t.Fatal(err)
+	}
+	promoted := orphans.PromoteForBlock(block, utxoSet, mempool)
+	if got := txids(promoted); !reflect.DeepEqual(got, []string{child.Txid}) {
+		t.Fatalf("promoted %v", got)
+	}
+	if orphans.Size() != 0 {
+		t.Fatal("conflicting orphan kept")
+	}
+}
+
+func TestOrphanInvalidDropped(t *testing.T) {
+	parent, child, utxoSet := orphanChain(t)
+	breakSig(child)
+	mempool := NewInMemoryMempool()
+	orphans := NewOrphanPool(DefaultOrphanLimits())
+
+	if _, err := ProcessTransaction(child, utxoSet, mempool, orphans); !errors.Is(err, ErrTxOrphaned) {
+		t.Fatal(err)
+	}
+	accepted, err := ProcessTransaction(parent, utxoSet, mempool, orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 1 || orphans.Size() != 0 || mempool.GetTransaction(child.Txid) != nil {
+		t.Fatal("invalid orphan promoted or kept")
+	}
+}
+
+func TestOrphanStillMissingRekeyed(t *testing.T) {
+	parent, _, utxoSet := orphanChain(t)
+	mempool := NewInMemoryMempool()
+	orphans := NewOrphanPool(DefaultOrphanLimits())
+
+	// spends the parent and an output nobody has seen
+	other := viewKey(cacheTxid(600), 0)
+	tx := mempoolTestTx(8, 1, parent.Txid, cacheTxid(600))
+	if err := orphans.Add(tx, []string{viewKey(parent.Txid, 0), other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProcessTransaction(parent, utxoSet, mempool, orphans); err != nil {
+		t.Fatal(err)
+	}
+	if !orphans.Has(tx.Txid) {
+		t.Fatal("orphan with a missing input dropped")
+	}
+	if got := orphans.orphans[tx.Txid].missing; !reflect.DeepEqual(got, []string{other}) {
+		t.Fatalf("missing = %v", got)
+	}
+	if _, ok := orphans.byOutpoint[viewKey(parent.Txid, 0)]; ok {
+		t.Fatal("index of a found input left behind")
+	}
+}
+
+func TestOrphanLimits(t *testing.T) {
+	orphans := NewOrphanPool(OrphanLimits{MaxCount: 3, MaxTxSize: 1000, Expiry: time.Minute})
+	clock := time.Unix(1_700_000_000, 0)
+	orphans.now = func() time.Time { return clock }
+
+	add := func(id int) error {
+		tx := mempoolTestTx(id, 1, cacheTxid(1000+id))
+		return orphans.Add(tx, []string{viewKey(cacheTxid(1000+id), 0)})
+	}
+	for id := 1; id <= 4; id++ {
+		if err := add(id); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(20 * time.Second)
+	}
+	if orphans.Size() != 3 || orphans.Has(cacheTxid(1)) {
+		t.Fatal("oldest orphan not evicted at the count limit")
+	}
+
+	if err := orphans.Add(mempoolTestTx(9, 100, cacheTxid(1009)), nil); !errors.Is(err, ErrOrphanTooLarge) {
+		t.Fatalf("large orphan: %v", err)
+	}
+
+	// 2 and 3 are over a minute old by now
+	clock = clock.Add(25 * time.Second)
+	if err := add(5); err != nil {
+		t.Fatal(err)
+	}
+	if orphans.Size() != 2 || !orphans.Has(cacheTxid(4)) || !orphans.Has(cacheTxid(5)) {
+		t.Fatalf("after expiry: %d orphans", orphans.Size())
+	}
+	if len(orphans.byOutpoint) != 2 {
+		t.Fatalf("outpoint index holds %d keys", len(orphans.byOutpoint))
+	}
+}
+
+// a malformed tx claiming an honest txid must not be held in its place
+func TestOrphanTxidNotSquatted(t *testing.T) {
+	parent, child, utxoSet := orphanChain(t)
+	mempool := NewInMemoryMempool()
+	orphans := NewOrphanPool(DefaultOrphanLimits())
+
+	junk := *child
+	junk.Vout = []VOUT{{Value: child.Vout[0].Value + 1, ScriptPubKey: child.Vout[0].ScriptPubKey}}
+	var rej *RejectError
+	if _, err := ProcessTransaction(&junk, utxoSet, mempool, orphans); !errors.As(err, &rej) || rej.Code != RejectMalformed {
+		t.Fatalf("junk under the child's txid: %v", err)
+	}
+	if orphans.Has(child.Txid) {
+		t.Fatal("junk held as orphan")
+	}
+
+	if _, err := ProcessTransaction(child, utxoSet, mempool, orphans); !errors.Is(err, ErrTxOrphaned) {
+		t.Fatal(err)
+	}
+	accepted, err := ProcessTransaction(parent, utxoSet, mempool, orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := txids(accepted); !reflect.DeepEqual(got, []string{parent.Txid, child.Txid}) {
+		t.Fatalf("accepted %v", got)
+	}
+}
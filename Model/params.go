@@ -0,0 +1,68 @@
+package model
+
+import (
+	"math/big"
+	"time"
+)
+
+// MaxMoney bounds every amount and every sum of amounts (21M coins, like
+// Bitcoin); checking each addition against it keeps int64 sums from wrapping
+const MaxMoney int64 = 21_000_000 * 100_000_000
+
+// MoneyRange reports whether v is a valid amount
+func MoneyRange(v int64) bool {
+	return v >= 0 && v <= MaxMoney
+}
+
+// ChainParams groups consensus settings that a node can configure
+type ChainParams struct {
+	// block subsidy = InitialSubsidy >> (height / SubsidyHalvingInterval)
+	InitialSubsidy         int64
+	SubsidyHalvingInterval int32
+
+	// easiest allowed target (compact nBits); genesis uses it
+	PowLimitBits uint32
+
+	// fixed so every node builds the same genesis block
+	GenesisTimestamp int64
+
+	// difficulty is retargeted every RetargetInterval blocks towards TargetSpacing
+	TargetSpacing    time.Duration
+	RetargetInterval int32
+}
+
+// DefaultChainParams returns a fresh copy of the default settings (Bitcoin-like schedule)
+func DefaultChainParams() *ChainParams {
+	return &ChainParams{
+		InitialSubsidy:         50 * 100_000_000,
+		SubsidyHalvingInterval: 210_000,
+		PowLimitBits:           0x1f00ffff, // ~65k hashes per block
+		GenesisTimestamp:       1767225600, // 2026-01-01 00:00:00 UTC
+		TargetSpacing:          5 * time.Second,
+		RetargetInterval:       12,
+	}
+}
+
+// RegtestChainParams: trivial difficulty (every other hash wins), for tests
+func RegtestChainParams() *ChainParams {
+	p := DefaultChainParams()
+	p.SubsidyHalvingInterval = 150
+	p.PowLimitBits = 0x207fffff
+	return p
+}
+
+func (p *ChainParams) PowLimit() *big.Int {
+	return CompactToBig(p.PowLimitBits)
+}
+
+// BlockSubsidy returns the newly created coins allowed in the coinbase at height
+func (p *ChainParams) BlockSubsidy(height int32) int64 {
+	if p.SubsidyHalvingInterval <= 0 {
+		return p.InitialSubsidy
+	}
+	halvings := height / p.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.InitialSubsidy >> uint(halvings)
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"project/helper"
+)
+
+// CompactToBig decodes nBits (Bitcoin compact format) into a target:
+// 1 byte exponent, 3 byte mantissa, bit 0x00800000 = sign
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	negative := bits&0x00800000 != 0
+	exponent := uint(bits >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(mantissa)
+	} else {
+		n = big.NewInt(mantissa)
+		n.Lsh(n, 8*(exponent-3))
+	}
+
+	if negative {
+		n.Neg(n)
+	}
+	return n
+}
+
+// BigToCompact encodes a target into nBits (inverse of CompactToBig, lossy)
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// keep the sign bit clear
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// HashToBig interprets a block hash as a little-endian 256-bit number (like Bitcoin)
+func HashToBig(hash []byte) *big.Int {
+	return new(big.Int).SetBytes(helper.ReverseBytes(hash))
+}
+
+// CalcWork = 2^256 / (target + 1): expected hashes to find a block at bits
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denom := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denom)
+}
+
+// CheckProofOfWork: target must be in (0, powLimit] and hash <= target
+func CheckProofOfWork(hash []byte, bits uint32, powLimit *big.Int) error {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("target %08x is not positive", bits)
+	}
+	if target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("target %08x above pow limit", bits)
+	}
+	if HashToBig(hash).Cmp(target) > 0 {
+		return fmt.Errorf("block hash %x does not meet target %08x", helper.ReverseBytes(hash), bits)
+	}
+	return nil
+}
+
+// CheckBlockHeader: stored hash matches the header and meets the claimed target
+func CheckBlockHeader(block *Block, params *ChainParams) error {
+	hash := block.BlockHash()
+	if !bytes.Equal(hash, block.Hash) {
+		return fmt.Errorf("block hash mismatch")
+	}
+	return CheckProofOfWork(hash, block.Bits, params.PowLimit())
+}
+
+// Solve searches the header nonce space for a hash meeting block.Bits.
+// Returns false if all 2^32 nonces fail (caller should change the coinbase
+// extra nonce / timestamp and retry) or stop is closed.
+func (b *Block) Solve(stop <-chan struct{}) bool {
+	target := CompactToBig(b.Bits)
+	header := b.SerializeHeader()
+	nonceAt := len(header) - 4
+
+	for nonce := uint32(0); ; nonce++ {
+		if nonce&0xffff == 0 && stop != nil {
+			select {
+			case <-stop:
+				return false
+			default:
+			}
+		}
+
+		binary.LittleEndian.PutUint32(header[nonceAt:], nonce)
+		hash := doubleSHA256(header)
+		if HashToBig(hash).Cmp(target) <= 0 {
+			b.Nonce = nonce
+			b.Hash = hash
+			return true
+		}
+
+		if nonce == ^uint32(0) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"math/big"
+	"testing"
+)
+
+// newTestBlock builds and mines a block at the given bits
+func newTestBlock(t *testing.T, txs []Transaction, prevHash []byte, height int32, bits uint32) *Block {
+	t.Helper()
+	b := NewBlock(txs, prevHash, height)
+	b.Bits = bits
+	// one second per height keeps every branch above its median time past,
+	// however fast a test builds it
+	b.Timestamp += int64(height)
+	if !b.Solve(nil) {
+		t.Fatal("could not solve test block")
+	}
+	return b
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1f00ffff, 0x207fffff, 0x1b0404cb, 0x03123456} {
+		if got := BigToCompact(CompactToBig(bits)); got != bits {
+			t.Errorf("BigToCompact(CompactToBig(%08x)) = %08x", bits, got)
+		}
+	}
+
+	// 0x1d00ffff is Bitcoin's genesis target: 0xffff << 208
+	want := new(big.Int).Lsh(big.NewInt(0xffff), 208)
+	if CompactToBig(0x1d00ffff).Cmp(want) != 0 {
+		t.Fatal("CompactToBig(0x1d00ffff) wrong")
+	}
+}
+
+func TestCheckBlockHeaderProofOfWork(t *testing.T) {
+	params := DefaultChainParams()
+	b := newTestBlock(t, nil, make([]byte, 32), 1, params.PowLimitBits)
+
+	if err := CheckBlockHeader(b, params); err != nil {
+		t.Fatalf("mined block rejected: %v", err)
+	}
+
+	// claim an easier target than allowed
+	easy := *b
+	easy.Bits = 0x207fffff
+	easy.Hash = easy.BlockHash()
+	if err := CheckBlockHeader(&easy, params); err == nil {
+		t.Fatal("target above pow limit accepted")
+	}
+
+	// find a nonce whose hash misses the target
+	bad := *b
+	for bad.Nonce = b.Nonce + 1; ; bad.Nonce++ {
+		bad.Hash = bad.BlockHash()
+		if HashToBig(bad.Hash).Cmp(CompactToBig(bad.Bits)) > 0 {
+			break
+		}
+	}
+	if err := CheckBlockHeader(&bad, params); err == nil {
+		t.Fatal("hash above target accepted")
+	}
+
+	// stored hash must match header
+	forged := *b
+	forged.Timestamp++
+	if err := CheckBlockHeader(&forged, params); err == nil {
+		t.Fatal("stale hash accepted")
+	}
+}
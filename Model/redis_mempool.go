@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"project/metrics"
 	"strconv"
@@ -49,43 +50,127 @@ func mempoolAddrKey(addr string) string {
 	return fmt.Sprintf("mempool:addr:%s", addr)
 }
 
-func (m *RedisMempool) AddTransaction(tx Transaction) error {
-	pipe := m.rdb.TxPipeline()
+// Redis layout, besides the keys above:
+//
+//	mempool:all   set of txids
+//	mempool:order zset txid → arrival sequence (mempool:seq)
+//	mempool:size  hash txid → serialized size
+//	mempool:fee   hash txid → fee
+//	mempool:bytes total serialized size
+const (
+	mempoolAllKey   = "mempool:all"
+	mempoolOrderKey = "mempool:order"
+	mempoolSeqKey   = "mempool:seq"
+	mempoolSizeKey  = "mempool:size"
+	mempoolFeeKey   = "mempool:fee"
+	mempoolBytesKey = "mempool:bytes"
+)
+
+// Ping checks the connection
+func (m *RedisMempool) Ping() error {
+	return m.rdb.Ping(m.ctx).Err()
+}
+
+// redisTxRetries: attempts of a WATCH transaction whose keys changed under it
+const redisTxRetries = 3
+
+// watch runs fn in a WATCH transaction on keys, again if another client
+// touched them first
+func (m *RedisMempool) watch(fn func(rtx *redis.Tx) error, keys ...string) error {
+	var err error
+	for i := 0; i < redisTxRetries; i++ {
+		err = m.rdb.Watch(m.ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
 
-	// 1. Save transaction
-	rawTx, _ := json.Marshal(tx)
-	pipe.Set(m.ctx, mempoolTxKey(tx.Txid), rawTx, 0)
-	pipe.SAdd(m.ctx, "mempool:all", tx.Txid)
+// AddTransaction refuses a tx spending an input another pool tx spends
+// (ErrMempoolDoubleSpend), also against other processes sharing the pool
+func (m *RedisMempool) AddTransaction(tx *Transaction, fee int64) error {
+	rawTx, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	size := tx.Size()
+	txKey := mempoolTxKey(tx.Txid)
 
-	// 2. Mark inputs as spent (double-spend protection)
+	var spentKeys []string
 	for _, vin := range tx.Vin {
 		if vin.Txid == "" {
 			continue // coinbase
 		}
-		pipe.Set(
-			m.ctx,
-			mempoolSpentKey(vin.Txid, vin.Vout),
-			tx.Txid,
-			0,
-		)
+		spentKeys = append(spentKeys, mempoolSpentKey(vin.Txid, vin.Vout))
 	}
 
-	// 3. Store unconfirmed outputs (UTXO tạm)
-	// 3. Store unconfirmed outputs (UTXO tạm)
-	for _, out := range tx.Vout {
-		rawOut, _ := json.Marshal(out)
-		outKey := mempoolOutKey(tx.Txid, out.N)
+	// WATCH txKey and the spent marks: a concurrent add of the same tx, or
+	// of one spending the same inputs, aborts this one
+	return m.watch(func(rtx *redis.Tx) error {
+		n, err := rtx.Exists(m.ctx, txKey).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("tx already exists")
+		}
+		if len(spentKeys) > 0 {
+			spenders, err := rtx.MGet(m.ctx, spentKeys...).Result()
+			if err != nil {
+				return err
+			}
+			for i, spender := range spenders {
+				if spender != nil {
+					return fmt.Errorf("%w: %s by %v", ErrMempoolDoubleSpend,
+						strings.TrimPrefix(spentKeys[i], "mempool:spent:"), spender)
+				}
+			}
+		}
+		seq, err := rtx.Incr(m.ctx, mempoolSeqKey).Result()
+		if err != nil {
+			return err
+		}
 
-		pipe.Set(m.ctx, outKey, rawOut, 0)
+		_, err = rtx.TxPipelined(m.ctx, func(pipe redis.Pipeliner) error {
+			// 1. Save transaction
+			pipe.Set(m.ctx, txKey, rawTx, 0)
+			pipe.SAdd(m.ctx, mempoolAllKey, tx.Txid)
+			pipe.ZAdd(m.ctx, mempoolOrderKey, redis.Z{Score: float64(seq), Member: tx.Txid})
+			pipe.HSet(m.ctx, mempoolSizeKey, tx.Txid, size)
+			pipe.HSet(m.ctx, mempoolFeeKey, tx.Txid, fee)
+			pipe.IncrBy(m.ctx, mempoolBytesKey, int64(size))
+
+			// 2. Mark inputs as spent (double-spend protection)
+			for _, key := range spentKeys {
+				pipe.Set(m.ctx, key, tx.Txid, 0)
+			}
+
+			// 3. Store unconfirmed outputs (UTXO tạm)
+			for i, out := range tx.Vout {
+				rawOut, _ := json.Marshal(out)
+				outKey := mempoolOutKey(tx.Txid, i)
+				pipe.Set(m.ctx, outKey, rawOut, 0)
+				for _, addr := range out.ScriptPubKey.Addresses {
+					pipe.SAdd(m.ctx, mempoolAddrKey(addr), outKey)
+				}
+			}
+			return nil
+		})
+		return err
+	}, append([]string{txKey}, spentKeys...)...)
+}
 
-		if len(out.ScriptPubKey.Addresses) > 0 {
-			addr := out.ScriptPubKey.Addresses[0]
-			pipe.SAdd(m.ctx, mempoolAddrKey(addr), outKey)
-		}
+func (m *RedisMempool) GetTransaction(txid string) *Transaction {
+	raw, err := m.rdb.Get(m.ctx, mempoolTxKey(txid)).Bytes()
+	if err != nil {
+		return nil
 	}
-
-	_, err := pipe.Exec(m.ctx)
-	return err
+	var tx Transaction
+	if err := json.Unmarshal(raw, &tx); err != nil {
+		return nil
+	}
+	return &tx
 }
 
 func (m *RedisMempool) IsSpent(txid string, vout int) bool {
@@ -117,33 +202,178 @@ func (m *RedisMempool) GetOutput(txid string, vout int) (VOUT, bool) {
 	_ = json.Unmarshal(raw, &out)
 	return out, true
 }
-func (m *RedisMempool) RemoveTransaction(tx Transaction) error {
-	pipe := m.rdb.TxPipeline()
+func (m *RedisMempool) RemoveTransaction(tx *Transaction) error {
+	txKey := mempoolTxKey(tx.Txid)
 
-	pipe.Del(m.ctx, mempoolTxKey(tx.Txid))
-	pipe.SRem(m.ctx, "mempool:all", tx.Txid)
-
-	// remove spent marks
+	var spentKeys []string
 	for _, vin := range tx.Vin {
-		if vin.Txid == "" {
+		if vin.Txid != "" {
+			spentKeys = append(spentKeys, mempoolSpentKey(vin.Txid, vin.Vout))
+		}
+	}
+
+	return m.watch(func(rtx *redis.Tx) error {
+		size, err := rtx.HGet(m.ctx, mempoolSizeKey, tx.Txid).Int64()
+		if errors.Is(err, redis.Nil) {
+			return nil // not in the pool
+		}
+		if err != nil {
+			return err
+		}
+		var spenders []any
+		if len(spentKeys) > 0 {
+			if spenders, err = rtx.MGet(m.ctx, spentKeys...).Result(); err != nil {
+				return err
+			}
+		}
+
+		_, err = rtx.TxPipelined(m.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(m.ctx, txKey)
+			pipe.SRem(m.ctx, mempoolAllKey, tx.Txid)
+			pipe.ZRem(m.ctx, mempoolOrderKey, tx.Txid)
+			pipe.HDel(m.ctx, mempoolSizeKey, tx.Txid)
+			pipe.HDel(m.ctx, mempoolFeeKey, tx.Txid)
+			pipe.DecrBy(m.ctx, mempoolBytesKey, size)
+
+			// remove our spent marks, never another tx's
+			for i, key := range spentKeys {
+				if spenders[i] == tx.Txid {
+					pipe.Del(m.ctx, key)
+				}
+			}
+
+			// remove unconfirmed outputs
+			for i, out := range tx.Vout {
+				outKey := mempoolOutKey(tx.Txid, i)
+				pipe.Del(m.ctx, outKey)
+				for _, addr := range out.ScriptPubKey.Addresses {
+					pipe.SRem(m.ctx, mempoolAddrKey(addr), outKey)
+				}
+			}
+			return nil
+		})
+		return err
+	}, append([]string{txKey}, spentKeys...)...)
+}
+
+// spender: txid of the mempool tx spending txid:vout
+func (m *RedisMempool) spender(txid string, vout int) (string, bool) {
+	spender, err := m.rdb.Get(m.ctx, mempoolSpentKey(txid, vout)).Result()
+	if err != nil {
+		return "", false
+	}
+	return spender, true
+}
+
+// RemoveForBlock: see InMemoryMempool.RemoveForBlock
+func (m *RedisMempool) RemoveForBlock(block *Block) {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.IsCoinbase() {
 			continue
 		}
-		pipe.Del(m.ctx, mempoolSpentKey(vin.Txid, vin.Vout))
+		if mtx := m.GetTransaction(tx.Txid); mtx != nil {
+			_ = m.RemoveTransaction(mtx)
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if spender, ok := m.spender(vin.Txid, vin.Vout); ok {
+				m.removeWithDescendants(spender)
+			}
+		}
 	}
+}
 
-	// remove unconfirmed outputs
-	for _, out := range tx.Vout {
-		outKey := mempoolOutKey(tx.Txid, out.N)
-		pipe.Del(m.ctx, outKey)
+func (m *RedisMempool) removeWithDescendants(txid string) {
+	tx := m.GetTransaction(txid)
+	if tx == nil {
+		return
+	}
+	_ = m.RemoveTransaction(tx)
 
-		if len(out.ScriptPubKey.Addresses) > 0 {
-			addr := out.ScriptPubKey.Addresses[0]
-			pipe.SRem(m.ctx, mempoolAddrKey(addr), outKey)
+	for i := range tx.Vout {
+		if child, ok := m.spender(txid, i); ok {
+			m.removeWithDescendants(child)
 		}
 	}
+}
 
-	_, err := pipe.Exec(m.ctx)
-	return err
+// orderedTxids: all txids in arrival order
+func (m *RedisMempool) orderedTxids() []string {
+	txids, err := m.rdb.ZRange(m.ctx, mempoolOrderKey, 0, -1).Result()
+	if err != nil {
+		return nil
+	}
+	return txids
+}
+
+func (m *RedisMempool) Drain() []*Transaction {
+	var txs []*Transaction
+	for _, txid := range m.orderedTxids() {
+		tx := m.GetTransaction(txid)
+		if tx == nil {
+			continue
+		}
+		if err := m.RemoveTransaction(tx); err != nil {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// SnapshotUntilSize loads the whole pool to build the template (see
+// buildBlockTemplate); entries removed meanwhile are left out
+func (m *RedisMempool) SnapshotUntilSize(maxBytes int) MempoolSnapshot {
+	order, err := m.rdb.ZRangeWithScores(m.ctx, mempoolOrderKey, 0, -1).Result()
+	if err != nil || len(order) == 0 {
+		return MempoolSnapshot{}
+	}
+	txids := make([]string, len(order))
+	txKeys := make([]string, len(order))
+	for i, z := range order {
+		txids[i] = z.Member.(string)
+		txKeys[i] = mempoolTxKey(txids[i])
+	}
+
+	raws, err := m.rdb.MGet(m.ctx, txKeys...).Result()
+	if err != nil {
+		return MempoolSnapshot{}
+	}
+	fees, err := m.rdb.HMGet(m.ctx, mempoolFeeKey, txids...).Result()
+	if err != nil {
+		return MempoolSnapshot{}
+	}
+
+	// arrival order: parents are linked before their children
+	entries := make(map[string]*mempoolEntry, len(order))
+	for i, z := range order {
+		raw, ok := raws[i].(string)
+		if !ok {
+			continue
+		}
+		var tx Transaction
+		if err := json.Unmarshal([]byte(raw), &tx); err != nil {
+			continue
+		}
+		feeStr, _ := fees[i].(string)
+		fee, _ := strconv.ParseInt(feeStr, 10, 64)
+
+		e := newMempoolEntry(&tx, fee, uint64(z.Score))
+		entries[tx.Txid] = e
+		e.link(entries)
+	}
+	return buildBlockTemplate(entries, maxBytes)
+}
+
+func (m *RedisMempool) Size() int {
+	n, _ := m.rdb.SCard(m.ctx, mempoolAllKey).Result()
+	return int(n)
+}
+
+func (m *RedisMempool) TotalBytes() int {
+	n, _ := m.rdb.Get(m.ctx, mempoolBytesKey).Int()
+	return n
 }
 
 func (m *RedisMempool) FindOutputsByAddress(addr string) []UTXO {
@@ -0,0 +1,191 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// ProcessBlock accepts a block from any branch.
+//
+// The header and chain context are checked against its parent, the block is
+// stored and indexed, and if its branch now has the most cumulative work the
+// active chain is switched to it (connect on the tip, or a reorg). Transactions
+// of disconnected blocks go back to mempool when still valid, and TxOrphans
+// whose parents got confirmed join it; mempool may be nil.
+//
+// Returns true when block became the new tip.
+func (bc *Blockchain) ProcessBlock(block *Block, utxoSet UTXOProvider, mempool Mempool) (bool, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.db == nil {
+		return false, fmt.Errorf("process block needs a db (undo data)")
+	}
+	if _, ok := bc.index[string(block.Hash)]; ok {
+		return false, ErrDuplicateBlock
+	}
+
+	parent, ok := bc.index[string(block.PrevHash)]
+	if !ok {
+		return false, ErrOrphanBlock
+	}
+	if parent.invalid {
+		return false, ErrInvalidParent
+	}
+
+	if err := CheckBlockHeader(block, bc.Params); err != nil {
+		return false, err
+	}
+	if err := VerifyMerkleRoot(block); err != nil {
+		return false, err
+	}
+	if err := checkBlockContext(bc.Params, block, parent.block, parent.ancestorBlock, time.Now()); err != nil {
+		return false, err
+	}
+
+	if err := bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(blockKey(block.Hash), block.Serialize())
+	}); err != nil {
+		return false, fmt.Errorf("store block: %v", err)
+	}
+	node := bc.addNodeLocked(block)
+
+	// first seen wins on equal work
+	if node.work.Cmp(bc.tipNode().work) <= 0 {
+		return false, nil
+	}
+
+	if err := bc.activateBranchLocked(node, utxoSet, mempool); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// activateBranchLocked makes node the tip: disconnect back to the fork point,
+// then connect node's branch. If a branch block fails validation it (and the
+// rest of the branch) is marked invalid; on any failure the previous chain
+// is restored.
+func (bc *Blockchain) activateBranchLocked(node *blockNode, utxoSet UTXOProvider, mempool Mempool) error {
+	oldTip := bc.tipNode()
+	fork := findFork(oldTip, node)
+
+	var disconnected []*Block // tip first
+	for bc.tipNode() != fork {
+		b, err := bc.disconnectTipLocked(utxoSet)
+		if err != nil {
+			if rerr := bc.restoreChainLocked(bc.tipNode(), disconnected, utxoSet); rerr != nil {
+				return fmt.Errorf("reorg: %v, restoring old chain failed: %v", err, rerr)
+			}
+			return fmt.Errorf("reorg: %v", err)
+		}
+		disconnected = append(disconnected, b)
+	}
+
+	var branch []*blockNode // fork+1 .. node
+	for n := node; n != fork; n = n.parent {
+		branch = append([]*blockNode{n}, branch...)
+	}
+
+	for i, n := range branch {
+		if err := bc.connectBlockLocked(n.block, utxoSet); err != nil {
+			// a storage failure says nothing about the block: it may be
+			// connected again later
+			if errors.Is(err, ErrBlockInvalid) {
+				for _, bad := range branch[i:] {
+					bad.invalid = true
+				}
+			}
+			if rerr := bc.restoreChainLocked(fork, disconnected, utxoSet); rerr != nil {
+				return fmt.Errorf("block %x: %w, restoring old chain failed: %v", n.block.Hash, err, rerr)
+			}
+			return fmt.Errorf("block %x: %w", n.block.Hash, err)
+		}
+	}
+
+	if len(disconnected) > 0 {
+		fmt.Printf("[chain] reorg: fork at height %d, disconnected %d, connected %d\n",
+			fork.block.Height, len(disconnected), len(branch))
+	}
+
+	if mempool != nil {
+		if len(disconnected) == 0 {
+			for _, n := range branch {
+				mempool.RemoveForBlock(n.block)
+			}
+		} else {
+			resyncMempool(mempool, disconnected, utxoSet)
+		}
+		if bc.TxOrphans != nil {
+			for _, n := range branch {
+				bc.TxOrphans.PromoteForBlock(n.block, utxoSet, mempool)
+			}
+		}
+	}
+
+	bc.resetCurrentBlockLocked()
+	return nil
+}
+
+// connectBlockLocked validates block against utxoSet, commits it and makes it
+// the tip. Validation errors wrap ErrBlockInvalid, commit errors do not.
+func (bc *Blockchain) connectBlockLocked(block *Block, utxoSet UTXOProvider) error {
+	// one overlay: validation leaves the block applied, commit flushes it
+	// together with the new chain tip
+	view := NewUTXOView(utxoSet)
+	if err := VerifyBlockWithView(block, view, bc.Params); err != nil {
+		return fmt.Errorf("%w: %w", ErrBlockInvalid, err)
+	}
+	if err := commitBlockView(block, view, utxoSet, bc.db, func(w kvWriter) error {
+		return storeBlockTxn(w, block)
+	}); err != nil {
+		return err
+	}
+	bc.linkBlockLocked(block)
+	return nil
+}
+
+// restoreChainLocked rewinds to fork and reconnects the previously active
+// blocks (disconnected is tip first)
+func (bc *Blockchain) restoreChainLocked(fork *blockNode, disconnected []*Block, utxoSet UTXOProvider) error {
+	for bc.tipNode() != fork {
+		if _, err := bc.disconnectTipLocked(utxoSet); err != nil {
+			return err
+		}
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := bc.connectBlockLocked(disconnected[i], utxoSet); err != nil {
+			return err
+		}
+	}
+	bc.resetCurrentBlockLocked()
+	return nil
+}
+
+// RevalidateMempool drops txs the UTXO set no longer funds, e.g. from a
+// shared Redis pool that outlived the node's last run
+func RevalidateMempool(mempool Mempool, utxoSet UTXOProvider) {
+	resyncMempool(mempool, nil, utxoSet)
+}
+
+// resyncMempool re-admits txs of disconnected blocks (oldest block first),
+// then the previous mempool contents, dropping anything the new chain
+// confirmed, conflicts with, or no longer funds.
+func resyncMempool(mempool Mempool, disconnected []*Block, utxoSet UTXOProvider) {
+	var candidates []*Transaction
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		txs := disconnected[i].Transactions
+		for j := range txs {
+			if !txs[j].IsCoinbase() {
+				candidates = append(candidates, &txs[j])
+			}
+		}
+	}
+	candidates = append(candidates, mempool.Drain()...)
+
+	for _, tx := range candidates {
+		_, _ = AcceptTransaction(tx, utxoSet, mempool)
+	}
+}
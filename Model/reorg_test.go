@@ -0,0 +1,131 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProcessBlockReorgByWork(t *testing.T) {
+	db := openTestDB(t)
+	params := RegtestChainParams()
+
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoSet := NewUTXOSet()
+	mempool := NewInMemoryMempool()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	_, otherPub := NewKeyPair()
+	other := AddressFromPub(otherPub)
+
+	extra := uint64(0)
+	mine := func(parent *Block, txs ...Transaction) *Block {
+		extra++ // distinct coinbase per branch
+		height := parent.Height + 1
+		cb := NewCoinbaseTx(height, addr, params.BlockSubsidy(height), extra)
+		return newTestBlock(t, append([]Transaction{cb}, txs...), parent.Hash, height, params.PowLimitBits)
+	}
+	process := func(b *Block) bool {
+		t.Helper()
+		isTip, err := bc.ProcessBlock(b, utxoSet, mempool)
+		if err != nil {
+			t.Fatalf("block at height %d: %v", b.Height, err)
+		}
+		return isTip
+	}
+
+	genesis := bc.Tip()
+	a1 := mine(genesis)
+	process(a1)
+
+	spend := Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: a1.Transactions[0].Txid, Vout: 0}},
+		Vout:    []VOUT{{Value: params.BlockSubsidy(1), N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(other)}},
+	}
+	if err := spend.SignEd25519(priv, utxoSet, mempool); err != nil {
+		t.Fatal(err)
+	}
+	a2 := mine(a1, spend)
+	if !process(a2) {
+		t.Fatal("a2 should extend the tip")
+	}
+
+	// equal work: first seen stays active
+	b2 := mine(a1)
+	if process(b2) {
+		t.Fatal("equal-work branch became tip")
+	}
+	if _, err := bc.ProcessBlock(b2, utxoSet, mempool); !errors.Is(err, ErrDuplicateBlock) {
+		t.Fatalf("duplicate: got %v", err)
+	}
+
+	// more work: reorg a2 → b2, b3; spend returns to the mempool
+	b3 := mine(b2)
+	if !process(b3) {
+		t.Fatal("heavier branch did not become tip")
+	}
+	if !bytes.Equal(bc.Tip().Hash, b3.Hash) || len(bc.Blocks) != 4 {
+		t.Fatalf("tip at height %d after reorg", bc.Tip().Height)
+	}
+	if !bytes.Equal(bc.Blocks[2].Hash, b2.Hash) {
+		t.Fatal("active chain not switched to b2")
+	}
+	if mempool.GetTransaction(spend.Txid) == nil {
+		t.Fatal("disconnected tx not returned to mempool")
+	}
+	if _, ok := utxoSet.Get(a1.Transactions[0].Txid, 0); !ok {
+		t.Fatal("a1 coinbase should be unspent on the new chain")
+	}
+	if _, ok := utxoSet.Get(a2.Transactions[0].Txid, 0); ok {
+		t.Fatal("a2 coinbase still in utxo set")
+	}
+
+	// heavier branch with an invalid block: rejected, b-chain restored
+	bad := Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: strings.Repeat("ee", 32), Vout: 0}},
+		Vout:    []VOUT{{Value: 1, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(other)}},
+	}
+	bad.Txid = bad.ComputeTxID()
+	c3 := mine(a2, bad)
+	process(c3)
+	c4 := mine(c3)
+	if _, err := bc.ProcessBlock(c4, utxoSet, mempool); !errors.Is(err, ErrBlockInvalid) {
+		t.Fatalf("branch with invalid block: got %v", err)
+	}
+	if !bytes.Equal(bc.Tip().Hash, b3.Hash) {
+		t.Fatal("old chain not restored after failed reorg")
+	}
+	if _, ok := utxoSet.Get(a2.Transactions[0].Txid, 0); ok {
+		t.Fatal("utxo set not restored after failed reorg")
+	}
+	if _, err := bc.ProcessBlock(mine(c4), utxoSet, mempool); !errors.Is(err, ErrInvalidParent) {
+		t.Fatalf("child of invalid block: got %v", err)
+	}
+
+	// unknown parent
+	orphan := mine(&Block{Hash: bytes.Repeat([]byte{1}, 32), Height: 5})
+	if _, err := bc.ProcessBlock(orphan, utxoSet, mempool); !errors.Is(err, ErrOrphanBlock) {
+		t.Fatalf("orphan: got %v", err)
+	}
+
+	// side branches survive a restart
+	reloaded, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reloaded.Tip().Hash, b3.Hash) {
+		t.Fatal("reloaded tip differs")
+	}
+	for _, b := range []*Block{a2, c3, c4} {
+		if _, ok := reloaded.index[string(b.Hash)]; !ok {
+			t.Fatalf("side block at height %d not indexed after reload", b.Height)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// On-disk UTXO schema.
+//
+//	schema:version  → u32 LE, written once the DB is in that format
+//
+// Version 3 (current): compact keys and values, see utxocompress.go
+//
+//	'U' txid varint(idx)     → encodeUTXOValue
+//	addr:<addr>:<txid>:<idx> → empty, address index (every address of the output)
+//
+// Version 2: the same keys; values never use script type 0x02, which older
+// builds cannot decode, so the version 2 → 3 migration rewrites nothing.
+//
+// Version 1:
+//
+//	utxo:<txid>:<idx> → serializeUTXOBinary
+//
+// Unversioned DBs (version 0) may hold, under utxo:, JSON or binary values,
+// and under bare <txid>:<idx> keys the old BadgerUTXOSet value layout.
+// MigrateSchema rewrites both in place.
+const UTXOSchemaVersion uint32 = 3
+
+var schemaVersionKey = []byte("schema:version")
+
+var (
+	ErrSchemaMigrationNeeded = errors.New("database needs migration")
+	ErrUnknownSchemaVersion  = errors.New("unknown database schema version")
+)
+
+// ReadSchemaVersion returns the stored version; ok is false if there is none
+func ReadSchemaVersion(db *badger.DB) (version uint32, ok bool, err error) {
+	err = db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaVersionKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) != 4 {
+				return fmt.Errorf("%w: bad version value %x", ErrUnknownSchemaVersion, val)
+			}
+			version, ok = binary.LittleEndian.Uint32(val), true
+			return nil
+		})
+	})
+	return version, ok, err
+}
+
+func writeSchemaVersion(db *badger.DB, version uint32) error {
+	val := make([]byte, 4)
+	binary.LittleEndian.PutUint32(val, version)
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set(schemaVersionKey, val)
+	})
+}
+
+// CheckSchemaVersion makes sure db is in the current format before anything
+// reads it. An empty DB is stamped with the current version; an older one
+// must be migrated first and a newer or unknown one is refused.
+func CheckSchemaVersion(db *badger.DB) error {
+	version, ok, err := ReadSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		empty, err := isEmptyDB(db)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return writeSchemaVersion(db, UTXOSchemaVersion)
+		}
+		version = 0
+	}
+
+	switch {
+	case version == UTXOSchemaVersion:
+		return nil
+	case version < UTXOSchemaVersion:
+		return fmt.Errorf("%w: schema version %d, current %d (run: go run ./cmd/utxotool migrate)",
+			ErrSchemaMigrationNeeded, version, UTXOSchemaVersion)
+	default:
+		return fmt.Errorf("%w: %d (this build reads %d)", ErrUnknownSchemaVersion, version, UTXOSchemaVersion)
+	}
+}
+
+func isEmptyDB(db *badger.DB) (bool, error) {
+	empty := true
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty, err
+}
+
+// MigrationStats counts UTXO entries by the format they were found in
+type MigrationStats struct {
+	FromVersion uint32
+	Binary      int // version 1 value
+	JSON        int
+	LegacyVOUT  int // utxo: key, BadgerUTXOSet value
+	Moved       int // bare <txid>:<idx> key, BadgerUTXOSet value
+
+	// key+value bytes of the rewritten entries, before and after
+	BytesBefore, BytesAfter int64
+
+	// address index rebuilt over the migrated entries
+	Addresses ReindexStats
+}
+
+func (s MigrationStats) Rewritten() int {
+	return s.Binary + s.JSON + s.LegacyVOUT + s.Moved
+}
+
+// MigrateSchema rewrites an older DB to the current schema in place.
+// All entries are decoded before anything is written, so an undecodable
+// entry aborts with the DB untouched. Then, each step finished before the
+// next starts: the new entries are written, the old ones deleted, the addr:
+// index rebuilt and the version key written. The batches are not atomic,
+// but an interrupted run leaves every UTXO under its old key, its new key
+// or both, and the version unchanged, so it can simply be run again.
+func MigrateSchema(db *badger.DB) (MigrationStats, error) {
+	var stats MigrationStats
+
+	version, ok, err := ReadSchemaVersion(db)
+	if err != nil {
+		return stats, err
+	}
+	if ok {
+		stats.FromVersion = version
+		if version == UTXOSchemaVersion {
+			return stats, nil
+		}
+		if version > UTXOSchemaVersion {
+			return stats, fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, version)
+		}
+		// version 2 values are valid version 3 values
+		if version == 2 {
+			return stats, finishMigration(db, &stats)
+		}
+	}
+
+	var sets, deletes opList
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+
+			var (
+				txid     string
+				idx      int
+				prefixed bool
+			)
+			if rest, found := strings.CutPrefix(key, "utxo:"); found {
+				if txid, idx, ok = parseOutpointKey(rest); !ok {
+					return fmt.Errorf("migrate: bad utxo key %q", key)
+				}
+				prefixed = true
+			} else if txid, idx, ok = parseOutpointKey(key); !ok || len(txid) != 64 {
+				continue // blocks, undo, chainstate, addr index, current utxos...
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			utxo, format, err := decodeLegacyUTXO(txid, idx, val, prefixed)
+			if err != nil {
+				return fmt.Errorf("migrate: %s: %v", key, err)
+			}
+			newKey, newVal, err := encodeUTXO(utxo)
+			if err != nil {
+				return fmt.Errorf("migrate: %s: %v", key, err)
+			}
+
+			switch {
+			case !prefixed:
+				stats.Moved++
+			case format == "binary":
+				stats.Binary++
+			case format == "json":
+				stats.JSON++
+			default:
+				stats.LegacyVOUT++
+			}
+			stats.BytesBefore += int64(len(key) + len(val))
+			stats.BytesAfter += int64(len(newKey) + len(newVal))
+
+			sets.Set(newKey, newVal)
+			deletes.Delete(item.KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// recomputed from the new entries on first use
+	deletes.Delete(utxoStatsKey)
+
+	// a delete never lands before the entry replacing it
+	if err := flushOps(db, sets); err != nil {
+		return stats, err
+	}
+	if err := flushOps(db, deletes); err != nil {
+		return stats, err
+	}
+	return stats, finishMigration(db, &stats)
+}
+
+// finishMigration rebuilds the address index (older builds indexed only the
+// first address, or none) and then marks db as current
+func finishMigration(db *badger.DB, stats *MigrationStats) error {
+	var err error
+	if stats.Addresses, err = reindexAddresses(db); err != nil {
+		return err
+	}
+	return writeSchemaVersion(db, UTXOSchemaVersion)
+}
+
+// flushOps writes ops through a WriteBatch: fast, not atomic
+func flushOps(db *badger.DB, ops opList) error {
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	if err := applyOps(batch, ops); err != nil {
+		return err
+	}
+	return batch.Flush()
+}
+
+// ReindexStats is what ReindexAddresses did
+type ReindexStats struct {
+	Outputs int // UTXOs scanned
+	Entries int // addr: entries they need
+	Added   int // missing entries written
+	Removed int // stale entries deleted
+}
+
+// ReindexAddresses rebuilds the addr: index from the UTXO entries: every
+// address of every output, nothing else. Needed for databases written by
+// builds that indexed only the first address, or none (the RAM set).
+// Not atomic, but safe to rerun if interrupted.
+func ReindexAddresses(db *badger.DB) (ReindexStats, error) {
+	if err := CheckSchemaVersion(db); err != nil {
+		return ReindexStats{}, err
+	}
+	return reindexAddresses(db)
+}
+
+// reindexAddresses: ReindexAddresses without the version check, for MigrateSchema
+func reindexAddresses(db *badger.DB) (ReindexStats, error) {
+	var stats ReindexStats
+	stale := make(map[string]struct{})
+	var ops opList
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		prefix := []byte("addr:")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			stale[string(it.Item().Key())] = struct{}{}
+		}
+		it.Close()
+
+		it = txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoKeyPrefix); it.ValidForPrefix(utxoKeyPrefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			utxo, err := decodeUTXO(item.Key(), val)
+			if err != nil {
+				return err
+			}
+			stats.Outputs++
+			for _, addr := range utxo.Vout.ScriptPubKey.Addresses {
+				akey := addrKey(addr, utxo.Txid, utxo.Index)
+				stats.Entries++
+				if _, ok := stale[string(akey)]; ok {
+					delete(stale, string(akey))
+					continue
+				}
+				ops.Set(akey, []byte{})
+				stats.Added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	for k := range stale {
+		ops.Delete([]byte(k))
+		stats.Removed++
+	}
+	return stats, flushOps(db, ops)
+}
+
+// legacyUTXOKey: version 0/1 key
+func legacyUTXOKey(txid string, vout int) []byte {
+	return []byte("utxo:" + txid + ":" + strconv.Itoa(vout))
+}
+
+// parseOutpointKey splits "<txid>:<idx>"
+func parseOutpointKey(s string) (string, int, bool) {
+	i := strings.LastIndexByte(s, ':')
+	if i <= 0 {
+		return "", 0, false
+	}
+	idx, err := strconv.Atoi(s[i+1:])
+	if err != nil || idx < 0 {
+		return "", 0, false
+	}
+	txid := s[:i]
+	if _, err := hex.DecodeString(txid); err != nil {
+		return "", 0, false
+	}
+	return txid, idx, true
+}
+
+// decodeLegacyUTXO tries every format a version 0/1 DB may contain. Values
+// under a utxo: key are binary or JSON (UTXOSet) or, from BadgerUTXOSet
+// installs, the VOUT layout; bare keys only ever held the VOUT layout.
+// A decoded entry must belong to the outpoint in its key.
+func decodeLegacyUTXO(txid string, idx int, val []byte, prefixed bool) (UTXO, string, error) {
+	if prefixed {
+		if utxo, err := deserializeUTXOBinary(val); err == nil &&
+			utxo.Txid == paddedTxid(txid) && utxo.Index == idx {
+			return utxo, "binary", nil
+		}
+
+		var utxo UTXO
+		if err := json.Unmarshal(val, &utxo); err == nil && utxo.Txid == txid && utxo.Index == idx {
+			return utxo, "json", nil
+		}
+	}
+
+	out, err := deserializeLegacyVOUT(val)
+	if err != nil {
+		return UTXO{}, "", fmt.Errorf("no known utxo format matches: %v", err)
+	}
+	out.N = idx
+	return UTXO{Txid: txid, Index: idx, Vout: out}, "vout", nil
+}
+
+// paddedTxid is txid as it comes back from deserializeUTXOBinary
+func paddedTxid(txid string) string {
+	return hex.EncodeToString(txidBytes32(txid))
+}
+
+// deserializeLegacyVOUT: old BadgerUTXOSet value
+// value int64 | u32 script len | script | u32 addr count | (u32 len | addr)*
+func deserializeLegacyVOUT(data []byte) (VOUT, error) {
+	var v VOUT
+	r := bytes.NewReader(data)
+
+	if err := binary.Read(r, binary.LittleEndian, &v.Value); err != nil {
+		return v, err
+	}
+	script, err := readLegacyBytes(r)
+	if err != nil {
+		return v, err
+	}
+	v.ScriptPubKey.Hex = bytesToHex(script)
+
+	var addrCount uint32
+	if err := binary.Read(r, binary.LittleEndian, &addrCount); err != nil {
+		return v, err
+	}
+	if int64(addrCount) > int64(r.Len())/4 {
+		return v, fmt.Errorf("address count %d too large", addrCount)
+	}
+	v.ScriptPubKey.Addresses = make([]string, 0, addrCount)
+	for i := 0; i < int(addrCount); i++ {
+		addr, err := readLegacyBytes(r)
+		if err != nil {
+			return v, err
+		}
+		v.ScriptPubKey.Addresses = append(v.ScriptPubKey.Addresses, string(addr))
+	}
+
+	if r.Len() != 0 {
+		return v, fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	return v, nil
+}
+
+func readLegacyBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,275 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// legacyVOUT: value layout of the old BadgerUTXOSet
+func legacyVOUT(out VOUT) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, out.Value)
+	script, _ := hex.DecodeString(out.ScriptPubKey.Hex)
+	binary.Write(buf, binary.LittleEndian, uint32(len(script)))
+	buf.Write(script)
+	binary.Write(buf, binary.LittleEndian, uint32(len(out.ScriptPubKey.Addresses)))
+	for _, a := range out.ScriptPubKey.Addresses {
+		binary.Write(buf, binary.LittleEndian, uint32(len(a)))
+		buf.WriteString(a)
+	}
+	return buf.Bytes()
+}
+
+func TestMigrateSchemaRewritesLegacyFormats(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	txid := func(b byte) string { return strings.Repeat(hex.EncodeToString([]byte{b}), 32) }
+	asJSON := UTXO{Txid: txid(1), Index: 0, Vout: VOUT{Value: 10, ScriptPubKey: spk}}
+	asBinary := UTXO{Txid: txid(2), Index: 3, Vout: VOUT{Value: 20, N: 3, ScriptPubKey: spk}}
+	asVOUT := UTXO{Txid: txid(3), Index: 1, Vout: VOUT{Value: 30, N: 1, ScriptPubKey: spk}}
+	bare := UTXO{Txid: txid(4), Index: 2, Vout: VOUT{Value: 40, N: 2, ScriptPubKey: spk}}
+
+	// an unversioned DB as written by older builds
+	jsonVal, _ := json.Marshal(asJSON)
+	bareKey := []byte(bare.Txid + ":2")
+	err = db.Update(func(txn *badger.Txn) error {
+		for k, v := range map[string][]byte{
+			string(legacyUTXOKey(asJSON.Txid, 0)):           jsonVal,
+			string(legacyUTXOKey(asBinary.Txid, 3)):         serializeUTXOBinary(asBinary),
+			string(legacyUTXOKey(asVOUT.Txid, 1)):           legacyVOUT(asVOUT.Vout),
+			string(bareKey):                                 legacyVOUT(bare.Vout),
+			string(addrKey(spk.Addresses[0], bare.Txid, 2)): nil,
+			string(addrKey(spk.Addresses[0], txid(9), 0)):   nil, // spent long ago
+			"chain:tip": {0xaa},
+		} {
+			if err := txn.Set([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewUTXOSet().LoadFromBadger(db); !errors.Is(err, ErrSchemaMigrationNeeded) {
+		t.Fatalf("load of unversioned DB: got %v, want ErrSchemaMigrationNeeded", err)
+	}
+
+	stats, err := MigrateSchema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Binary != 1 || stats.JSON != 1 || stats.LegacyVOUT != 1 || stats.Moved != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.Addresses != (ReindexStats{Outputs: 4, Entries: 4, Added: 3, Removed: 1}) {
+		t.Fatalf("address reindex = %+v", stats.Addresses)
+	}
+	if stats.BytesAfter >= stats.BytesBefore {
+		t.Fatalf("migration grew the entries: %d -> %d bytes", stats.BytesBefore, stats.BytesAfter)
+	}
+	if v, ok, _ := ReadSchemaVersion(db); !ok || v != UTXOSchemaVersion {
+		t.Fatalf("version after migration = %d (set %v)", v, ok)
+	}
+
+	loaded := loadSetFromDB(t, db)
+	if len(loaded) != 4 {
+		t.Fatalf("loaded %d utxos, want 4", len(loaded))
+	}
+	for _, want := range []UTXO{asJSON, asBinary, asVOUT, bare} {
+		got, ok := loaded[string(utxoKey(want.Txid, want.Index))]
+		if !ok || got.Vout.Value != want.Vout.Value || got.Vout.ScriptPubKey.Hex != spk.Hex {
+			t.Fatalf("%s:%d = %+v", want.Txid, want.Index, got)
+		}
+	}
+	err = db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get(bareKey); !errors.Is(err, badger.ErrKeyNotFound) {
+			t.Error("bare legacy key not removed")
+		}
+		if _, err := txn.Get(legacyUTXOKey(asJSON.Txid, 0)); !errors.Is(err, badger.ErrKeyNotFound) {
+			t.Error("version 1 key not removed")
+		}
+		if _, err := txn.Get([]byte("chain:tip")); err != nil {
+			t.Error("non-utxo key touched")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// idempotent
+	if stats, err := MigrateSchema(db); err != nil || stats.Rewritten() != 0 {
+		t.Fatalf("second migration: %+v %v", stats, err)
+	}
+}
+
+func TestMigrateSchemaResumesAfterCrash(t *testing.T) {
+	db := openTestDB(t)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	utxo := UTXO{Txid: strings.Repeat("cd", 32), Index: 0, Vout: VOUT{Value: 70, ScriptPubKey: spk}}
+	newKey, newVal, err := encodeUTXO(utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a version 1 DB, interrupted after the new entries were written and
+	// before the old ones were deleted
+	if err := writeSchemaVersion(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(legacyUTXOKey(utxo.Txid, 0), serializeUTXOBinary(utxo)); err != nil {
+			return err
+		}
+		return txn.Set(newKey, newVal)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadSetFromDB(t, db)
+	if got, ok := loaded[string(utxoKey(utxo.Txid, 0))]; len(loaded) != 1 || !ok || got.Vout.Value != 70 {
+		t.Fatalf("utxos after resumed migration: %+v", loaded)
+	}
+	set, err := NewBadgerUTXOSetFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := set.FindUTXOsByAddress(spk.Addresses[0]); len(found) != 1 {
+		t.Fatalf("address index after resumed migration: %+v", found)
+	}
+}
+
+func TestMigrateSchemaFromV2(t *testing.T) {
+	db := openTestDB(t)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	utxo := UTXO{Txid: strings.Repeat("ab", 32), Index: 1, Vout: VOUT{Value: 50, N: 1, ScriptPubKey: spk}}
+	key, val, err := encodeUTXO(utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSchemaVersion(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(txn *badger.Txn) error { return txn.Set(key, val) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckSchemaVersion(db); !errors.Is(err, ErrSchemaMigrationNeeded) {
+		t.Fatalf("check: got %v, want ErrSchemaMigrationNeeded", err)
+	}
+	stats, err := MigrateSchema(db)
+	if err != nil || stats.FromVersion != 2 || stats.Rewritten() != 0 {
+		t.Fatalf("migrate: %+v %v", stats, err)
+	}
+	if v, _, _ := ReadSchemaVersion(db); v != UTXOSchemaVersion {
+		t.Fatalf("version after migration = %d", v)
+	}
+	if got, ok := loadSetFromDB(t, db)[string(utxoKey(utxo.Txid, 1))]; !ok || got.Vout.Value != 50 {
+		t.Fatalf("utxo after migration: %+v", got)
+	}
+}
+
+func TestLoadRefusesUnknownSchema(t *testing.T) {
+	db := openTestDB(t)
+	if err := writeSchemaVersion(db, UTXOSchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewUTXOSet().LoadFromBadger(db); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Fatalf("load: got %v, want ErrUnknownSchemaVersion", err)
+	}
+	if _, err := NewBlockchain(RegtestChainParams(), db); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Fatalf("blockchain: got %v, want ErrUnknownSchemaVersion", err)
+	}
+	if _, err := MigrateSchema(db); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Fatalf("migrate: got %v, want ErrUnknownSchemaVersion", err)
+	}
+}
+
+func TestLoadRejectsCorruptUTXO(t *testing.T) {
+	db := openTestDB(t)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	key, val, err := encodeUTXO(UTXO{Txid: strings.Repeat("ab", 32), Vout: VOUT{Value: 1, ScriptPubKey: spk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, append(val, 0))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUTXOSet().LoadFromBadger(db); !errors.Is(err, ErrBadUTXOEncoding) {
+		t.Fatalf("trailing bytes: got %v, want ErrBadUTXOEncoding", err)
+	}
+}
+
+func TestReindexAddresses(t *testing.T) {
+	db := openTestDB(t)
+	alice := "0102030405060708090a0b0c0d0e0f1011121314"
+	bob := "1415161718191a1b1c1d1e1f2021222324252627"
+	shared := MakeP2PKHScriptPubKey(alice)
+	shared.Addresses = []string{alice, bob}
+
+	// UTXO entries only, as the RAM set used to leave them
+	err := db.Update(func(txn *badger.Txn) error {
+		for i, spk := range []ScriptPubKey{MakeP2PKHScriptPubKey(alice), shared} {
+			key, val, err := encodeUTXO(UTXO{Txid: cacheTxid(i), Vout: VOUT{Value: 5, ScriptPubKey: spk}})
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(key, val); err != nil {
+				return err
+			}
+		}
+		// stale entry of an output that no longer exists
+		return txn.Set(addrKey(bob, cacheTxid(9), 0), []byte{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := NewBadgerUTXOSetFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(disk.FindByAddress(alice)); got != 0 {
+		t.Fatalf("index before reindex: %d entries", got)
+	}
+
+	stats, err := ReindexAddresses(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ReindexStats{Outputs: 2, Entries: 3, Added: 3, Removed: 1}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got := len(disk.FindByAddress(alice)); got != 2 {
+		t.Fatalf("alice has %d outputs, want 2", got)
+	}
+	if got := disk.FindByAddress(bob); len(got) != 1 || got[0].Txid != cacheTxid(1) {
+		t.Fatalf("bob's outputs = %+v", got)
+	}
+
+	// idempotent
+	if stats, err := ReindexAddresses(db); err != nil || stats.Added != 0 || stats.Removed != 0 {
+		t.Fatalf("second reindex: %+v %v", stats, err)
+	}
+}
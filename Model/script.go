@@ -0,0 +1,413 @@
+package model
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/minio/sha256-simd"
+)
+
+// Opcodes understood by the script interpreter.
+// Values follow Bitcoin so scripts built by MakeP2PKHScriptPubKey keep their meaning.
+const (
+	OP_0              byte = 0x00
+	OP_PUSHDATA1      byte = 0x4c
+	OP_PUSHDATA2      byte = 0x4d
+	OP_PUSHDATA4      byte = 0x4e
+	OP_1NEGATE        byte = 0x4f
+	OP_1              byte = 0x51
+	OP_16             byte = 0x60
+	OP_NOP            byte = 0x61
+	OP_VERIFY         byte = 0x69
+	OP_RETURN         byte = 0x6a
+	OP_DROP           byte = 0x75
+	OP_DUP            byte = 0x76
+	OP_SWAP           byte = 0x7c
+	OP_EQUAL          byte = 0x87
+	OP_EQUALVERIFY    byte = 0x88
+	OP_SHA256         byte = 0xa8
+	OP_HASH160        byte = 0xa9
+	OP_CHECKSIG       byte = 0xac
+	OP_CHECKSIGVERIFY byte = 0xad
+)
+
+// Execution limits (same values as Bitcoin consensus)
+const (
+	MaxScriptSize        = 10000
+	MaxScriptElementSize = 520
+	MaxOpsPerScript      = 201
+	MaxStackSize         = 1000
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:              "OP_0",
+	OP_PUSHDATA1:      "OP_PUSHDATA1",
+	OP_PUSHDATA2:      "OP_PUSHDATA2",
+	OP_PUSHDATA4:      "OP_PUSHDATA4",
+	OP_1NEGATE:        "OP_1NEGATE",
+	OP_NOP:            "OP_NOP",
+	OP_VERIFY:         "OP_VERIFY",
+	OP_RETURN:         "OP_RETURN",
+	OP_DROP:           "OP_DROP",
+	OP_DUP:            "OP_DUP",
+	OP_SWAP:           "OP_SWAP",
+	OP_EQUAL:          "OP_EQUAL",
+	OP_EQUALVERIFY:    "OP_EQUALVERIFY",
+	OP_SHA256:         "OP_SHA256",
+	OP_HASH160:        "OP_HASH160",
+	OP_CHECKSIG:       "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+}
+
+var (
+	ErrScriptTooLarge      = errors.New("script too large")
+	ErrScriptMalformed     = errors.New("malformed push")
+	ErrScriptElementSize   = errors.New("push exceeds max element size")
+	ErrScriptOpCount       = errors.New("too many operations")
+	ErrScriptStackSize     = errors.New("stack size limit exceeded")
+	ErrScriptStackUnderrun = errors.New("stack underflow")
+	ErrScriptBadOpcode     = errors.New("unknown or disabled opcode")
+	ErrScriptReturn        = errors.New("OP_RETURN executed")
+	ErrScriptVerify        = errors.New("verify failed")
+	ErrScriptNotPushOnly   = errors.New("scriptSig is not push-only")
+	ErrScriptEvalFalse     = errors.New("script evaluated to false")
+)
+
+// SigChecker verifies sig against pubKey for the input being executed.
+// The sighash itself is bound by the caller (see Transaction.SigHash).
+type SigChecker func(sig, pubKey []byte) bool
+
+type scriptOp struct {
+	opcode byte
+	data   []byte // only for push opcodes
+}
+
+func isPushOpcode(op byte) bool {
+	return op <= OP_16 && op != 0x50 // 0x50 = OP_RESERVED
+}
+
+// parseScript splits raw script bytes into opcodes, resolving push data
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooLarge
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var n int
+		switch {
+		case op >= 0x01 && op <= 0x4b:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, ErrScriptMalformed
+			}
+			n = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, ErrScriptMalformed
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(script) {
+				return nil, ErrScriptMalformed
+			}
+			n = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		default:
+			ops = append(ops, scriptOp{opcode: op})
+			continue
+		}
+
+		if n < 0 || i+n > len(script) {
+			return nil, ErrScriptMalformed
+		}
+		ops = append(ops, scriptOp{opcode: op, data: script[i : i+n]})
+		i += n
+	}
+
+	return ops, nil
+}
+
+// DisassembleScript renders a script as ASM: pushes as hex, opcodes by name
+func DisassembleScript(script []byte) (string, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.opcode == OP_0:
+			parts = append(parts, "OP_0")
+		case op.opcode <= OP_PUSHDATA4:
+			parts = append(parts, hex.EncodeToString(op.data))
+		case op.opcode >= OP_1 && op.opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.opcode-OP_1+1))
+		default:
+			if name, ok := opcodeNames[op.opcode]; ok {
+				parts = append(parts, name)
+			} else {
+				parts = append(parts, fmt.Sprintf("OP_UNKNOWN_0x%02x", op.opcode))
+			}
+		}
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// ExtractP2PKHHash returns the 20-byte pubkey hash of a standard
+// OP_DUP OP_HASH160 <20> OP_EQUALVERIFY OP_CHECKSIG script
+func ExtractP2PKHHash(script []byte) ([]byte, bool) {
+	if len(script) != 25 ||
+		script[0] != OP_DUP || script[1] != OP_HASH160 || script[2] != 0x14 ||
+		script[23] != OP_EQUALVERIFY || script[24] != OP_CHECKSIG {
+		return nil, false
+	}
+	return script[3:23], true
+}
+
+// castToBool: any non-zero byte is true, except negative zero (0x80 as last byte)
+func castToBool(v []byte) bool {
+	for i, b := range v {
+		if b != 0 {
+			if i == len(v)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+type scriptEngine struct {
+	stack   [][]byte
+	opCount int
+	checker SigChecker
+}
+
+func (e *scriptEngine) push(v []byte) error {
+	if len(e.stack)+1 > MaxStackSize {
+		return ErrScriptStackSize
+	}
+	e.stack = append(e.stack, v)
+	return nil
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrScriptStackUnderrun
+	}
+	v := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return v, nil
+}
+
+func (e *scriptEngine) execute(script []byte) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	e.opCount = 0
+	for _, op := range ops {
+		if len(op.data) > MaxScriptElementSize {
+			return ErrScriptElementSize
+		}
+		if op.opcode > OP_16 {
+			e.opCount++
+			if e.opCount > MaxOpsPerScript {
+				return ErrScriptOpCount
+			}
+		}
+		if err := e.step(op); err != nil {
+			return fmt.Errorf("%w (opcode 0x%02x)", err, op.opcode)
+		}
+	}
+
+	return nil
+}
+
+func (e *scriptEngine) step(op scriptOp) error {
+	switch {
+	case op.opcode <= OP_PUSHDATA4:
+		return e.push(op.data)
+	case op.opcode == OP_1NEGATE:
+		return e.push([]byte{0x81})
+	case op.opcode >= OP_1 && op.opcode <= OP_16:
+		return e.push([]byte{op.opcode - OP_1 + 1})
+	}
+
+	switch op.opcode {
+	case OP_NOP:
+		return nil
+
+	case OP_VERIFY:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(v) {
+			return ErrScriptVerify
+		}
+		return nil
+
+	case OP_RETURN:
+		return ErrScriptReturn
+
+	case OP_DROP:
+		_, err := e.pop()
+		return err
+
+	case OP_DUP:
+		if len(e.stack) < 1 {
+			return ErrScriptStackUnderrun
+		}
+		return e.push(e.stack[len(e.stack)-1])
+
+	case OP_SWAP:
+		n := len(e.stack)
+		if n < 2 {
+			return ErrScriptStackUnderrun
+		}
+		e.stack[n-1], e.stack[n-2] = e.stack[n-2], e.stack[n-1]
+		return nil
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		eq := bytes.Equal(a, b)
+		if op.opcode == OP_EQUALVERIFY {
+			if !eq {
+				return ErrScriptVerify
+			}
+			return nil
+		}
+		return e.push(boolBytes(eq))
+
+	case OP_SHA256:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(v)
+		return e.push(h[:])
+
+	case OP_HASH160:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(HashPubKey(v))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ok := e.checker != nil && e.checker(sig, pubKey)
+		if op.opcode == OP_CHECKSIGVERIFY {
+			if !ok {
+				return ErrScriptVerify
+			}
+			return nil
+		}
+		return e.push(boolBytes(ok))
+	}
+
+	return ErrScriptBadOpcode
+}
+
+func boolBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// VerifyScript runs scriptSig, then scriptPubKey on the resulting stack.
+// scriptSig must be push-only and the final top of stack must be true.
+func VerifyScript(scriptSig, scriptPubKey []byte, checker SigChecker) error {
+	sigOps, err := parseScript(scriptSig)
+	if err != nil {
+		return fmt.Errorf("scriptSig: %w", err)
+	}
+	for _, op := range sigOps {
+		if !isPushOpcode(op.opcode) {
+			return ErrScriptNotPushOnly
+		}
+	}
+
+	e := &scriptEngine{checker: checker}
+
+	if err := e.execute(scriptSig); err != nil {
+		return fmt.Errorf("scriptSig: %w", err)
+	}
+	if err := e.execute(scriptPubKey); err != nil {
+		return fmt.Errorf("scriptPubKey: %w", err)
+	}
+
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptEvalFalse
+	}
+
+	return nil
+}
+
+// SigHash = double SHA256 of a copy of tx where every scriptSig is empty
+// except input inIdx, which carries the scriptPubKey being spent
+func (t *Transaction) SigHash(inIdx int, prevScript []byte) []byte {
+	txCopy := t.ShallowCopyEmptySigs()
+	txCopy.Vin[inIdx].ScriptSig.Hex = hex.EncodeToString(prevScript)
+
+	raw := txCopy.Serialize()
+	h1 := sha256.Sum256(raw)
+	h2 := sha256.Sum256(h1[:])
+	return h2[:]
+}
+
+// VerifyInputScript executes scriptSig of input inIdx against prevOut.ScriptPubKey
+func VerifyInputScript(t *Transaction, inIdx int, prevOut VOUT) error {
+	scriptSig, err := hex.DecodeString(t.Vin[inIdx].ScriptSig.Hex)
+	if err != nil {
+		return fmt.Errorf("bad scriptSig hex: %v", err)
+	}
+	scriptPubKey, err := hex.DecodeString(prevOut.ScriptPubKey.Hex)
+	if err != nil {
+		return fmt.Errorf("bad scriptPubKey hex: %v", err)
+	}
+
+	var sighash []byte
+	checker := func(sig, pubKey []byte) bool {
+		if len(sig) != ed25519.SignatureSize || len(pubKey) != ed25519.PublicKeySize {
+			return false
+		}
+		if sighash == nil {
+			sighash = t.SigHash(inIdx, scriptPubKey)
+		}
+		return ed25519.Verify(ed25519.PublicKey(pubKey), sighash, sig)
+	}
+
+	return VerifyScript(scriptSig, scriptPubKey, checker)
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// newSpendFixture funds a fresh key with one confirmed UTXO and returns a
+// signed tx spending it to a second address.
+func newSpendFixture(t *testing.T) (*Transaction, *UTXOSet, *InMemoryMempool) {
+	t.Helper()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	_, otherPub := NewKeyPair()
+
+	utxoSet := NewUTXOSet()
+	mempool := NewInMemoryMempool()
+
+	fundTxid := "11" + hex.EncodeToString(make([]byte, 31))
+	if err := utxoSet.Put(fundTxid, 0, VOUT{
+		Value:        1000,
+		N:            0,
+		ScriptPubKey: MakeP2PKHScriptPubKey(addr),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: fundTxid, Vout: 0}},
+		Vout: []VOUT{{
+			Value:        900,
+			N:            0,
+			ScriptPubKey: MakeP2PKHScriptPubKey(AddressFromPub(otherPub)),
+		}},
+	}
+	if err := tx.SignEd25519(priv, utxoSet, mempool); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+
+	return tx, utxoSet, mempool
+}
+
+func TestScriptP2PKHSpend(t *testing.T) {
+	tx, utxoSet, mempool := newSpendFixture(t)
+
+	prev, _ := utxoSet.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+	if err := VerifyInputScript(tx, 0, prev.Vout); err != nil {
+		t.Fatalf("valid spend rejected: %v", err)
+	}
+	if !VerifyForMempool(tx, utxoSet, mempool) {
+		t.Fatal("VerifyForMempool rejected valid tx")
+	}
+
+	// tampering with an output must break the signature
+	tx.Vout[0].Value = 901
+	if err := VerifyInputScript(tx, 0, prev.Vout); !errors.Is(err, ErrScriptVerify) && !errors.Is(err, ErrScriptEvalFalse) {
+		t.Fatalf("tampered tx: got %v", err)
+	}
+}
+
+func TestScriptWrongPubKeyHash(t *testing.T) {
+	tx, utxoSet, _ := newSpendFixture(t)
+
+	_, otherPub := NewKeyPair()
+	prev, _ := utxoSet.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+	prev.Vout.ScriptPubKey = MakeP2PKHScriptPubKey(AddressFromPub(otherPub))
+
+	if err := VerifyInputScript(tx, 0, prev.Vout); !errors.Is(err, ErrScriptVerify) {
+		t.Fatalf("expected EQUALVERIFY failure, got %v", err)
+	}
+}
+
+func TestScriptSigMustBePushOnly(t *testing.T) {
+	sig := []byte{0x01, 0xaa, OP_DUP}
+	spk := []byte{OP_DROP, OP_1}
+	if err := VerifyScript(sig, spk, nil); !errors.Is(err, ErrScriptNotPushOnly) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	// malformed push
+	if err := VerifyScript(nil, []byte{0x05, 0x01}, nil); !errors.Is(err, ErrScriptMalformed) {
+		t.Fatalf("malformed: got %v", err)
+	}
+
+	// op count
+	spk := []byte{OP_1}
+	for i := 0; i < MaxOpsPerScript+1; i++ {
+		spk = append(spk, OP_NOP)
+	}
+	if err := VerifyScript(nil, spk, nil); !errors.Is(err, ErrScriptOpCount) {
+		t.Fatalf("op count: got %v", err)
+	}
+
+	// stack size
+	spk = nil
+	for i := 0; i < MaxStackSize+1; i++ {
+		spk = append(spk, OP_1)
+	}
+	if err := VerifyScript(nil, spk, nil); !errors.Is(err, ErrScriptStackSize) {
+		t.Fatalf("stack size: got %v", err)
+	}
+
+	// OP_RETURN makes output unspendable
+	if err := VerifyScript([]byte{OP_1}, []byte{OP_RETURN}, nil); !errors.Is(err, ErrScriptReturn) {
+		t.Fatalf("op_return: got %v", err)
+	}
+}
+
+func TestDisassembleP2PKH(t *testing.T) {
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	want := "OP_DUP OP_HASH160 0102030405060708090a0b0c0d0e0f1011121314 OP_EQUALVERIFY OP_CHECKSIG"
+	if spk.ASM != want {
+		t.Fatalf("asm = %q", spk.ASM)
+	}
+}
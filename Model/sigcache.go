@@ -0,0 +1,99 @@
+package model
+
+import (
+	"project/metrics"
+	"strconv"
+	"sync"
+)
+
+// DefaultSigCacheSize bounds the shared cache (~ two full mempools of inputs)
+const DefaultSigCacheSize = 200_000
+
+// SigCache remembers inputs whose script and signature already verified.
+//
+// The key is txid + input index + the spent scriptPubKey: the txid commits to
+// every scriptSig and outpoint, the script pins what was actually checked.
+// CheckMempoolTx fills it, block validation consumes it. Both check
+// Txid == ComputeTxID() first, otherwise a tx could borrow another's entries.
+type SigCache struct {
+	mu      sync.RWMutex
+	entries map[string]struct{}
+	max     int
+}
+
+func NewSigCache(max int) *SigCache {
+	return &SigCache{
+		entries: make(map[string]struct{}),
+		max:     max,
+	}
+}
+
+// sigCache is shared by CheckMempoolTx and VerifyTxWithView
+var sigCache = NewSigCache(DefaultSigCacheSize)
+
+func sigCacheKey(t *Transaction, inIdx int, prevOut VOUT) string {
+	return t.Txid + ":" + strconv.Itoa(inIdx) + ":" + prevOut.ScriptPubKey.Hex
+}
+
+func (c *SigCache) Contains(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Add stores key; when full an arbitrary entry is evicted (map order is random)
+func (c *SigCache) Add(key string) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.entries) >= c.max {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = struct{}{}
+}
+
+func (c *SigCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *SigCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// verifyInputCached runs VerifyInputScript unless the input is cached.
+// store=true (mempool) caches a success; store=false (block) drops the entry
+// on a hit since a confirmed input is never checked again.
+func verifyInputCached(t *Transaction, inIdx int, prevOut VOUT, store bool) error {
+	key := sigCacheKey(t, inIdx, prevOut)
+
+	if sigCache.Contains(key) {
+		metrics.SigCacheLookups.WithLabelValues("hit").Inc()
+		if !store {
+			sigCache.Remove(key)
+		}
+		return nil
+	}
+	metrics.SigCacheLookups.WithLabelValues("miss").Inc()
+
+	if err := VerifyInputScript(t, inIdx, prevOut); err != nil {
+		return err
+	}
+	if store {
+		sigCache.Add(key)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSigCacheFilledByMempoolUsedByBlock(t *testing.T) {
+	tx, utxoSet, mempool := newSpendFixture(t)
+	prev, _ := utxoSet.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+	key := sigCacheKey(tx, 0, prev.Vout)
+
+	if !VerifyForMempool(tx, utxoSet, mempool) {
+		t.Fatal("valid tx rejected")
+	}
+	if !sigCache.Contains(key) {
+		t.Fatal("VerifyForMempool did not cache the input")
+	}
+
+	view := NewUTXOViewFromSet(utxoSet)
+	if _, err := VerifyTxWithView(tx, view); err != nil {
+		t.Fatal(err)
+	}
+	if sigCache.Contains(key) {
+		t.Fatal("block validation should consume the cache entry")
+	}
+
+	// without the entry the block path verifies the script itself
+	if _, err := VerifyTxWithView(tx, view); err != nil {
+		t.Fatalf("uncached verify: %v", err)
+	}
+}
+
+func TestSigCacheRejectsTxidMismatch(t *testing.T) {
+	tx, utxoSet, mempool := newSpendFixture(t)
+	if !VerifyForMempool(tx, utxoSet, mempool) {
+		t.Fatal("valid tx rejected")
+	}
+
+	// same Txid field, different content: must not ride on the cached entry
+	forged := *tx
+	forged.Vout = []VOUT{{Value: 999, N: 0, ScriptPubKey: tx.Vout[0].ScriptPubKey}}
+	if VerifyForMempool(&forged, utxoSet, NewInMemoryMempool()) {
+		t.Fatal("forged tx accepted by mempool")
+	}
+	if _, err := VerifyTxWithView(&forged, NewUTXOViewFromSet(utxoSet)); err == nil || !strings.Contains(err.Error(), "txid") {
+		t.Fatalf("forged tx in block: got %v", err)
+	}
+}
+
+func TestSigCacheBounded(t *testing.T) {
+	c := NewSigCache(2)
+	c.Add("a")
+	c.Add("b")
+	c.Add("c")
+	if c.Len() != 2 || !c.Contains("c") {
+		t.Fatalf("len=%d, newest present=%v", c.Len(), c.Contains("c"))
+	}
+}
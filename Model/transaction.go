@@ -8,6 +8,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"project/helper"
 	"project/metrics"
 	"time"
@@ -65,30 +67,22 @@ func HashPubKey(pubkey []byte) []byte {
 func MakeP2PKHScriptPubKey(addr string) ScriptPubKey {
 	pubKeyHash, _ := hex.DecodeString(addr)
 
-	script := []byte{
-		0x76, // OP_DUP
-		0xa9, // OP_HASH160
-		0x14, // PUSHDATA 20 bytes
-	}
-	script = append(script, pubKeyHash...)
-	script = append(script, 0x88, 0xac) // OP_EQUALVERIFY OP_CHECKSIG
+	script := BuildP2PKHScriptPubKey(pubKeyHash)
+	asm, _ := DisassembleScript(script)
 
 	return ScriptPubKey{
-		ASM:       "",
+		ASM:       asm,
 		Hex:       hex.EncodeToString(script),
 		Addresses: []string{addr},
 	}
 }
 
-// SignTransaction: for each input, create signature over a "sighash" computed by
-// - making a copy of tx where for the input being signed we set its ScriptSig.Hex = prevOutput.ScriptPubKey.Hex
-// - other inputs' ScriptSig.Hex = ""
-// - hash that copy and sign with priv
-// Then set original tx.Vin[i].ScriptSig.Hex = sigHex and ASM = sigHex + " " + pubkeyHex
+// SignEd25519: for each input, create signature over the sighash (see Transaction.SigHash)
+// Then set tx.Vin[i].ScriptSig.Hex = <push sig> <push pubkey> and ASM = sigHex + " " + pubkeyHex
 func (t *Transaction) SignEd25519(
 	priv ed25519.PrivateKey,
-	utxoSet *UTXOSet,
-	mempool *InMemoryMempool,
+	utxoSet UTXOProvider,
+	mempool Mempool,
 ) error {
 	start := time.Now()
 	defer func() {
@@ -130,172 +124,43 @@ func (t *Transaction) SignEd25519(
 		}
 
 		// -----------------------------
-		// 2) Create txCopy with empty scripts
+		// 2) Sighash over tx copy with prev scriptPubKey injected
 		// -----------------------------
-		txCopy := t.ShallowCopyEmptySigs()
-
-		// -----------------------------
-		// 3) Inject ScriptPubKey for THIS input
-		// -----------------------------
-		txCopy.Vin[inIdx].ScriptSig.Hex = prevOut.ScriptPubKey.Hex
-
-		// -----------------------------
-		// 4) Serialize + double SHA256
-		// -----------------------------
-		raw := txCopy.Serialize()
-		h1 := sha256.Sum256(raw)
-		h2 := sha256.Sum256(h1[:])
-		sighash := h2[:]
+		prevScript, err := hex.DecodeString(prevOut.ScriptPubKey.Hex)
+		if err != nil {
+			return fmt.Errorf("cannot sign: bad scriptPubKey for %s[%d]", vin.Txid, vin.Vout)
+		}
+		sighash := t.SigHash(inIdx, prevScript)
 
 		// -----------------------------
-		// 5) Sign with Ed25519
+		// 3) Sign with Ed25519
 		// -----------------------------
 		sig := ed25519.Sign(priv, sighash) // 64 bytes
 
 		// -----------------------------
-		// 6) Build scriptSig = sig || pubkey
+		// 4) Build scriptSig = <sig> <pubkey>
 		// -----------------------------
-		script := append(sig, pub...) // 96 bytes
+		script := BuildP2PKHScriptSig(sig, pub)
 
 		vin.ScriptSig.Hex = hex.EncodeToString(script)
 		vin.ScriptSig.ASM = fmt.Sprintf("%x %x", sig, pub)
 	}
 
 	// -----------------------------
-	// 7) Update txid AFTER signing
+	// 5) Update txid AFTER signing
 	// -----------------------------
 	t.Txid = t.ComputeTxID()
 	return nil
 }
 
-// VerifyTransaction: for each input, extract signature and pubkey from ScriptSig.ASM,
-// verify pubKeyHash matches prev output addresses[0], then compute sighash same as signing and verify signature.
+// VerifyForMempool: CheckMempoolTx without the reason
 func VerifyForMempool(
 	t *Transaction,
-	utxoSet *UTXOSet,
-	mempool *InMemoryMempool,
+	utxoSet UTXOProvider,
+	mempool Mempool,
 ) bool {
-	start := time.Now()
-	defer func() {
-		metrics.FnDuration.
-			WithLabelValues("tx_verify_duration").
-			Observe(float64(time.Since(start).Milliseconds()))
-	}()
-
-	// -----------------------------
-	// 0) Basic sanity checks
-	// -----------------------------
-	if len(t.Vin) == 0 || len(t.Vout) == 0 {
-		return false
-	}
-
-	// No duplicate inputs inside tx
-	seen := make(map[string]bool)
-	for _, vin := range t.Vin {
-		key := fmt.Sprintf("%s_%d", vin.Txid, vin.Vout)
-		if seen[key] {
-			return false
-		}
-		seen[key] = true
-	}
-
-	inputSum := int64(0)
-
-	// -----------------------------
-	// 1) Verify each input
-	// -----------------------------
-	for inIdx, vin := range t.Vin {
-
-		// Coinbase is NOT allowed in mempool
-		if vin.Txid == "" {
-			return false
-		}
-
-		// 1.1 Double-spend check (mempool)
-		if mempool.IsSpent(vin.Txid, vin.Vout) {
-			return false
-		}
-
-		// 1.2 Fetch referenced output
-		var prevOut VOUT
-		var ok bool
-
-		// (a) canonical UTXO
-		utxo, found := utxoSet.Get(vin.Txid, vin.Vout)
-		if found {
-			prevOut = utxo.Vout
-			ok = true
-		} else {
-			// (b) mempool output (chained tx)
-			prevOut, ok = mempool.GetOutput(vin.Txid, vin.Vout)
-			if !ok {
-				return false
-			}
-		}
-
-		// -----------------------------
-		// 2) SCRIPT & SIGNATURE VERIFY
-		// -----------------------------
-
-		// scriptSig = sig(64) || pubkey(32)
-		scriptBytes, err := hex.DecodeString(vin.ScriptSig.Hex)
-		if err != nil || len(scriptBytes) != 96 {
-			return false
-		}
-
-		sigBytes := scriptBytes[:64]
-		pubBytes := scriptBytes[64:96]
-
-		// Compare pubKeyHash with ScriptPubKey
-		pubKeyHashCalc := HashPubKey(pubBytes)
-
-		spk, err := hex.DecodeString(prevOut.ScriptPubKey.Hex)
-		if err != nil || len(spk) < 25 {
-			return false
-		}
-
-		expectedHash := spk[3 : 3+20]
-		if !bytes.Equal(pubKeyHashCalc, expectedHash) {
-			return false
-		}
-
-		// -----------------------------
-		// 3) Compute sighash
-		// -----------------------------
-		txCopy := t.ShallowCopyEmptySigs()
-		txCopy.Vin[inIdx].ScriptSig.Hex = hex.EncodeToString(spk)
-
-		raw := txCopy.Serialize()
-		h1 := sha256.Sum256(raw)
-		h2 := sha256.Sum256(h1[:])
-		sighash := h2[:]
-
-		// -----------------------------
-		// 4) Verify signature
-		// -----------------------------
-		if !ed25519.Verify(
-			ed25519.PublicKey(pubBytes),
-			sighash,
-			sigBytes,
-		) {
-			return false
-		}
-
-		inputSum += prevOut.Value
-	}
-
-	// -----------------------------
-	// 5) Verify outputs
-	// -----------------------------
-	outputSum := int64(0)
-	for _, out := range t.Vout {
-		if out.Value <= 0 {
-			return false
-		}
-		outputSum += out.Value
-	}
-
-	return inputSum >= outputSum
+	_, err := CheckMempoolTx(t, utxoSet, mempool)
+	return err == nil
 }
 
 // UpdateUTXOSet: cập nhật UTXO set sau khi verify thành công
@@ -332,90 +197,99 @@ func (t *Transaction) ShallowCopyEmptySigs() Transaction {
 	newVout := make([]VOUT, len(t.Vout))
 	copy(newVout, t.Vout)
 	txCopy := Transaction{
-		Txid: "",
-		Vin:  newVin,
-		Vout: newVout,
+		Version:  t.Version,
+		Txid:     "",
+		Vin:      newVin,
+		Vout:     newVout,
+		LockTime: t.LockTime,
 	}
 	return txCopy
 }
 
+// DustThreshold: change below this is not worth an output and goes to the fee
+const DustThreshold int64 = 546
+
+// p2pkhScriptSigSize = push(64-byte sig) + push(32-byte pubkey)
+const p2pkhScriptSigSize = 1 + ed25519.SignatureSize + 1 + ed25519.PublicKeySize
+
+// p2pkhInputSize = prev txid + index + varint + scriptSig + sequence
+const p2pkhInputSize = 32 + 4 + 1 + p2pkhScriptSigSize + 4
+
+func serializedOutputSize(out VOUT) int {
+	n := len(out.ScriptPubKey.Hex) / 2
+	return 8 + varIntSize(n) + n
+}
+
+// CreateTransaction pays amount to toAddr at feeRate (units per byte of
+// Transaction.Size) and returns the signed tx together with the fee it pays.
+// Inputs are chosen by wallet.Selector (DefaultCoinSelector when nil);
+// change below DustThreshold is dropped into the fee.
 func CreateTransaction(
 	priv ed25519.PrivateKey,
 	fromAddr string,
 	toAddr string,
 	amount int64,
-	utxoSet *UTXOSet,
-	mempool *InMemoryMempool,
+	feeRate int64,
+	utxoSet UTXOProvider,
+	mempool Mempool,
 	wallet *Wallet,
 
-) (Transaction, error) {
+) (Transaction, int64, error) {
 
-	type inputCandidate struct {
-		Txid  string
-		Index int
-		Out   VOUT
+	if amount <= 0 {
+		return Transaction{}, 0, errors.New("amount must be positive")
+	}
+	if feeRate < 0 {
+		return Transaction{}, 0, errors.New("negative fee rate")
 	}
 
-	var candidates []inputCandidate
-
-	// 1) get spendable UTXOs from wallet
+	// 1) get spendable UTXOs from wallet (oldest first)
 	utxos := wallet.GetSpendableUTXOs(mempool)
-	for _, u := range utxos {
-		candidates = append(candidates, inputCandidate{
-			Txid:  u.Txid,
-			Index: u.Index,
-			Out:   u.Vout,
-		})
+	if len(utxos) == 0 {
+		return Transaction{}, 0, errors.New("no spendable outputs")
 	}
 
-	if len(candidates) == 0 {
-		return Transaction{}, errors.New("no spendable outputs")
+	payment := VOUT{
+		Value:        amount,
+		N:            0,
+		ScriptPubKey: MakeP2PKHScriptPubKey(toAddr),
 	}
-
-	// 2) select inputs
-	var selected []inputCandidate
-	var total int64
-
-	for _, c := range candidates {
-		selected = append(selected, c)
-		total += c.Out.Value
-		if total >= amount {
-			break
-		}
+	change := VOUT{
+		N:            1,
+		ScriptPubKey: MakeP2PKHScriptPubKey(fromAddr),
 	}
 
-	if total < amount {
-		return Transaction{}, errors.New("insufficient funds")
+	// 2) select inputs
+	selector := wallet.Selector
+	if selector == nil {
+		selector = DefaultCoinSelector
+	}
+
+	sel, err := selector.SelectCoins(utxos, SelectionParams{
+		Amount:      amount,
+		FeeRate:     feeRate,
+		InputSize:   p2pkhInputSize,
+		PaymentSize: serializedOutputSize(payment),
+		ChangeSize:  serializedOutputSize(change),
+		Dust:        DustThreshold,
+	})
+	if err != nil {
+		return Transaction{}, 0, err
 	}
 
-	// 3) build vins
-	vins := make([]VIN, len(selected))
-	for i, in := range selected {
+	// 3) build vins / vouts
+	vins := make([]VIN, len(sel.Inputs))
+	for i, in := range sel.Inputs {
 		vins[i] = VIN{
 			Txid: in.Txid,
 			Vout: in.Index,
-			ScriptSig: ScriptSig{
-				ASM: "",
-				Hex: "",
-			},
 		}
 	}
 
-	// 4) build vouts
-	vouts := []VOUT{
-		{
-			Value:        amount,
-			N:            0,
-			ScriptPubKey: MakeP2PKHScriptPubKey(toAddr),
-		},
-	}
-
-	if total > amount {
-		vouts = append(vouts, VOUT{
-			Value:        total - amount,
-			N:            1,
-			ScriptPubKey: MakeP2PKHScriptPubKey(fromAddr),
-		})
+	vouts := []VOUT{payment}
+	if sel.Change > 0 {
+		change.Value = sel.Change
+		vouts = append(vouts, change)
 	}
 
 	tx := Transaction{
@@ -424,12 +298,12 @@ func CreateTransaction(
 		Vout:    vouts,
 	}
 
-	// 5) sign
+	// 4) sign
 	if err := tx.SignEd25519(priv, utxoSet, mempool); err != nil {
-		return Transaction{}, err
+		return Transaction{}, 0, err
 	}
 
-	return tx, nil
+	return tx, sel.Fee, nil
 }
 
 func (t *Transaction) Size() int {
@@ -488,6 +362,174 @@ func (tx *Transaction) ComputeTxID() string {
 	return hex.EncodeToString(helper.ReverseBytes(second[:])) // Bitcoin hiển thị little-endian
 }
 
+var (
+	ErrTxTruncated    = errors.New("truncated transaction")
+	ErrTxTrailingData = errors.New("trailing data after transaction")
+)
+
+// txSequenceFinal is the only sequence value Serialize writes
+const txSequenceFinal = 0xffffffff
+
+// DeserializeTransaction parses the wire encoding produced by Serialize.
+// It is strict: truncated input, trailing bytes, non-canonical varints and
+// sequences other than 0xffffffff are rejected, so
+// DeserializeTransaction(tx.Serialize()) round-trips and ComputeTxID is stable.
+// An all-zero prev txid decodes to "" (coinbase), matching how Serialize pads it.
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	r := bytes.NewReader(data)
+	tx, err := readTransaction(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTxTrailingData, r.Len())
+	}
+	return tx, nil
+}
+
+// Deserialize replaces t with the transaction decoded from data
+func (t *Transaction) Deserialize(data []byte) error {
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return err
+	}
+	*t = *tx
+	return nil
+}
+
+// readTransaction decodes one transaction from r, leaving any following bytes unread
+func readTransaction(r *bytes.Reader) (*Transaction, error) {
+	tx := &Transaction{}
+
+	// 1) version
+	if err := binary.Read(r, binary.LittleEndian, &tx.Version); err != nil {
+		return nil, fmt.Errorf("%w: version", ErrTxTruncated)
+	}
+
+	// 2) inputs
+	vinCount, err := readCount(r, 41) // 32 txid + 4 index + 1 script len + 4 sequence
+	if err != nil {
+		return nil, fmt.Errorf("vin count: %w", err)
+	}
+
+	tx.Vin = make([]VIN, vinCount)
+	for i := range tx.Vin {
+		prev := make([]byte, 32)
+		if _, err := io.ReadFull(r, prev); err != nil {
+			return nil, fmt.Errorf("%w: vin %d txid", ErrTxTruncated, i)
+		}
+		if !bytes.Equal(prev, make([]byte, 32)) {
+			tx.Vin[i].Txid = hex.EncodeToString(helper.ReverseBytes(prev))
+		}
+
+		var index uint32
+		if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+			return nil, fmt.Errorf("%w: vin %d index", ErrTxTruncated, i)
+		}
+		tx.Vin[i].Vout = int(index)
+
+		script, err := readScript(r)
+		if err != nil {
+			return nil, fmt.Errorf("vin %d scriptSig: %w", i, err)
+		}
+		tx.Vin[i].ScriptSig = ScriptSigFromBytes(script)
+
+		var sequence uint32
+		if err := binary.Read(r, binary.LittleEndian, &sequence); err != nil {
+			return nil, fmt.Errorf("%w: vin %d sequence", ErrTxTruncated, i)
+		}
+		if sequence != txSequenceFinal {
+			return nil, fmt.Errorf("vin %d: unsupported sequence %#x", i, sequence)
+		}
+	}
+
+	// 3) outputs
+	voutCount, err := readCount(r, 9) // 8 value + 1 script len
+	if err != nil {
+		return nil, fmt.Errorf("vout count: %w", err)
+	}
+
+	tx.Vout = make([]VOUT, voutCount)
+	for i := range tx.Vout {
+		var value uint64
+		if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+			return nil, fmt.Errorf("%w: vout %d value", ErrTxTruncated, i)
+		}
+		if value > math.MaxInt64 {
+			return nil, fmt.Errorf("vout %d: value out of range", i)
+		}
+
+		script, err := readScript(r)
+		if err != nil {
+			return nil, fmt.Errorf("vout %d scriptPubKey: %w", i, err)
+		}
+
+		tx.Vout[i] = VOUT{
+			Value:        int64(value),
+			N:            i,
+			ScriptPubKey: ScriptPubKeyFromBytes(script),
+		}
+	}
+
+	// 4) locktime
+	if err := binary.Read(r, binary.LittleEndian, &tx.LockTime); err != nil {
+		return nil, fmt.Errorf("%w: locktime", ErrTxTruncated)
+	}
+
+	tx.Txid = tx.ComputeTxID()
+	return tx, nil
+}
+
+// readCount reads a varint element count and bounds it by the bytes left,
+// so a corrupt count can't trigger a huge allocation
+func readCount(r *bytes.Reader, minElemSize int) (int, error) {
+	n, err := helper.ReadVarInt(r)
+	if err != nil {
+		if errors.Is(err, helper.ErrNonCanonicalVarInt) {
+			return 0, err
+		}
+		return 0, ErrTxTruncated
+	}
+	if n > uint64(r.Len()/minElemSize) {
+		return 0, ErrTxTruncated
+	}
+	return int(n), nil
+}
+
+func readScript(r *bytes.Reader) ([]byte, error) {
+	n, err := readCount(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	script := make([]byte, n)
+	if _, err := io.ReadFull(r, script); err != nil {
+		return nil, ErrTxTruncated
+	}
+	return script, nil
+}
+
+// ScriptSigFromBytes rebuilds ScriptSig fields from raw script bytes
+func ScriptSigFromBytes(script []byte) ScriptSig {
+	asm, _ := DisassembleScript(script)
+	return ScriptSig{
+		ASM: asm,
+		Hex: hex.EncodeToString(script),
+	}
+}
+
+// ScriptPubKeyFromBytes rebuilds ScriptPubKey fields from raw script bytes.
+// Addresses are only known for standard P2PKH scripts.
+func ScriptPubKeyFromBytes(script []byte) ScriptPubKey {
+	if hash, ok := ExtractP2PKHHash(script); ok {
+		return MakeP2PKHScriptPubKey(hex.EncodeToString(hash))
+	}
+	asm, _ := DisassembleScript(script)
+	return ScriptPubKey{
+		ASM: asm,
+		Hex: hex.EncodeToString(script),
+	}
+}
+
 type Transaction struct {
 	Version  uint32
 	Vin      []VIN
@@ -535,115 +577,207 @@ func BuildP2PKHScriptSig(sig []byte, pub []byte) []byte {
 	script := []byte{}
 	script = append(script, byte(len(sig))) // push sig
 	script = append(script, sig...)
-	script = append(script, byte(len(pub))) // push pubkey (33)
+	script = append(script, byte(len(pub))) // push pubkey (32)
 	script = append(script, pub...)
 	return script
 }
 
+// VerifyTxWithView checks a non-coinbase tx against view and returns its fee
 func VerifyTxWithView(
 	t *Transaction,
 	view *UTXOView,
-) error {
+) (int64, error) {
+
+	prevOuts, fee, err := checkTxInputs(t, view.Get)
+
+	// scripts of the inputs resolved before any failure come first
+	for inIdx, prevOut := range prevOuts {
+		if serr := verifyInputCached(t, inIdx, prevOut, false); serr != nil {
+			return 0, scriptError(inIdx, serr)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fee, nil
+}
+
+func scriptError(inIdx int, err error) error {
+	return fmt.Errorf("input %d script: %v", inIdx, err)
+}
+
+// checkTxInputs is VerifyTxWithView without scripts: sanity, input lookup
+// and amounts. prevOuts holds the outputs resolved before the first error
+// (all of them on success), in input order.
+func checkTxInputs(
+	t *Transaction,
+	lookup func(txid string, vout int) (UTXO, bool),
+) (prevOuts []VOUT, fee int64, err error) {
 
 	// -----------------------------
 	// 0) Basic sanity checks
 	// -----------------------------
 	if len(t.Vin) == 0 || len(t.Vout) == 0 {
-		return fmt.Errorf("empty vin or vout")
+		return nil, 0, fmt.Errorf("empty vin or vout")
+	}
+
+	if t.Txid != t.ComputeTxID() {
+		return nil, 0, fmt.Errorf("txid does not match tx content")
 	}
 
 	seen := make(map[string]bool)
 	for _, vin := range t.Vin {
 		key := fmt.Sprintf("%s_%d", vin.Txid, vin.Vout)
 		if seen[key] {
-			return fmt.Errorf("duplicate input")
+			return nil, 0, fmt.Errorf("duplicate input")
 		}
 		seen[key] = true
 	}
 
 	inputSum := int64(0)
+	prevOuts = make([]VOUT, 0, len(t.Vin))
 
 	// -----------------------------
-	// 1) Verify each input
+	// 1) Resolve each input
 	// -----------------------------
 	for _, vin := range t.Vin {
 
-		// Coinbase NOT allowed here
+		// Coinbase only allowed as first tx of a block (see VerifyBlock)
 		if vin.Txid == "" {
-			return fmt.Errorf("coinbase not allowed")
+			return prevOuts, 0, fmt.Errorf("coinbase not allowed")
 		}
 
-		// fetch prevOut ONLY from view
-		key := viewKey(vin.Txid, vin.Vout)
-		utxo, ok := view.utxos[key]
+		utxo, ok := lookup(vin.Txid, vin.Vout)
 		if !ok {
-			return fmt.Errorf("missing utxo %s", key)
+			return prevOuts, 0, fmt.Errorf("missing utxo %s", viewKey(vin.Txid, vin.Vout))
 		}
-		prevOut := utxo.Vout
-
-		// -----------------------------
-		// 2) SCRIPT & SIGNATURE VERIFY - SKIPPED
-		// -----------------------------
-		// Signature verification disabled for performance
-		// Assumes transactions were already verified before entering block
 
-		inputSum += prevOut.Value
+		prevOuts = append(prevOuts, utxo.Vout)
+		inputSum += utxo.Vout.Value
+		if !MoneyRange(utxo.Vout.Value) || !MoneyRange(inputSum) {
+			return prevOuts, 0, fmt.Errorf("input value out of range")
+		}
 	}
 
 	// -----------------------------
-	// 5) Verify outputs
+	// 2) Verify outputs
 	// -----------------------------
 	outputSum := int64(0)
 	for _, out := range t.Vout {
-		if out.Value <= 0 {
-			return fmt.Errorf("invalid output value")
+		if out.Value <= 0 || out.Value > MaxMoney {
+			return prevOuts, 0, fmt.Errorf("invalid output value")
 		}
 		outputSum += out.Value
+		if !MoneyRange(outputSum) {
+			return prevOuts, 0, fmt.Errorf("output total out of range")
+		}
 	}
 
 	if inputSum < outputSum {
-		return fmt.Errorf("input < output")
+		return prevOuts, 0, fmt.Errorf("input < output")
 	}
 
-	return nil
+	return prevOuts, inputSum - outputSum, nil
 }
 
 func ApplyTxToView(tx *Transaction, view *UTXOView) {
 
 	// remove spent inputs
 	for _, vin := range tx.Vin {
-		delete(view.utxos, string(utxoKey(vin.Txid, vin.Vout)))
+		if vin.Txid == "" {
+			continue
+		}
+		view.spend(vin.Txid, vin.Vout)
 	}
 
 	// add new outputs
 	for i, out := range tx.Vout {
-		view.utxos[string(utxoKey(tx.Txid, i))] = UTXO{
+		view.add(UTXO{
 			Txid:  tx.Txid,
 			Index: i,
 			Vout:  out,
-		}
+		})
 	}
 }
 
-func VerifyBlock(block *Block, utxoSet *UTXOSet) error {
+// VerifyBlockSerial: header PoW, then every tx in block order against a full
+// copy of the UTXO set, finally coinbase reward <= subsidy(height) + fees.
+// Reference for VerifyBlock (same result, same error), kept for tests and benchmarks.
+func VerifyBlockSerial(block *Block, utxoSet UTXOProvider, params *ChainParams) error {
+
+	// 0️⃣ header: hash + proof of work
+	if err := CheckBlockHeader(block, params); err != nil {
+		return err
+	}
+
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block has no coinbase")
+	}
 
 	// 1️⃣ init view từ UTXO set
 	view := NewUTXOViewFromSet(utxoSet)
 
-	// 2️⃣ verify từng tx theo thứ tự trong block
-	for i := range block.Transactions {
+	// 2️⃣ verify từng tx theo thứ tự trong block (skip coinbase)
+	fees := int64(0)
+	for i := 1; i < len(block.Transactions); i++ {
 		tx := &block.Transactions[i]
 
-		if err := VerifyTxWithView(tx, view); err != nil {
-			return fmt.Errorf("tx %s invalid: %v, with index %d", tx.Txid, err, i)
+		fee, err := VerifyTxWithView(tx, view)
+		if err != nil {
+			return blockTxError(tx, i, err)
+		}
+		fees += fee
+		if !MoneyRange(fees) {
+			return fmt.Errorf("block fees out of range")
 		}
 
 		// 3️⃣ apply tx lên view
 		ApplyTxToView(tx, view)
 	}
 
+	// 4️⃣ coinbase
+	coinbase := &block.Transactions[0]
+	if err := CheckCoinbase(coinbase, block.Height, fees, params); err != nil {
+		return fmt.Errorf("coinbase %s invalid: %v", coinbase.Txid, err)
+	}
+	ApplyTxToView(coinbase, view)
+
 	return nil
 }
+
+// CalcFees sums fees of txs (in block order) resolving inputs from utxoSet or
+// from earlier txs in the list. No script checks - used to size the coinbase.
+func CalcFees(txs []Transaction, utxoSet UTXOProvider) (int64, error) {
+	pending := make(map[string]VOUT)
+	fees := int64(0)
+
+	for _, tx := range txs {
+		inputSum := int64(0)
+		for _, vin := range tx.Vin {
+			key := viewKey(vin.Txid, vin.Vout)
+			if out, ok := pending[key]; ok {
+				inputSum += out.Value
+				continue
+			}
+			utxo, ok := utxoSet.Get(vin.Txid, vin.Vout)
+			if !ok {
+				return 0, fmt.Errorf("tx %s: missing utxo %s", tx.Txid, key)
+			}
+			inputSum += utxo.Vout.Value
+		}
+
+		outputSum := int64(0)
+		for i, out := range tx.Vout {
+			outputSum += out.Value
+			pending[viewKey(tx.Txid, i)] = out
+		}
+
+		fees += inputSum - outputSum
+	}
+
+	return fees, nil
+}
 func VerifyMerkleRoot(block *Block) error {
 	calculated := ComputeMerkleRoot(block.Transactions)
 	if !bytes.Equal(calculated, block.MerkleRoot) {
@@ -652,21 +786,15 @@ func VerifyMerkleRoot(block *Block) error {
 	return nil
 }
 
+// CommitBlock applies an already validated block to utxoSet and db.
+// Spent inputs must exist; created outputs must not.
 func CommitBlock(
 	block *Block,
-	utxoSet *UTXOSet,
+	utxoSet UTXOProvider,
 	db *badger.DB,
 ) error {
 
-	startCommit := time.Now()
-	totalDeletes := 0
-	totalPuts := 0
-	var totalMemTime, totalDBTime time.Duration
-
-	// ==========================================
-	// PHASE 1: Update in-memory UTXOSet (fast)
-	// ==========================================
-	t1 := time.Now()
+	view := NewUTXOView(utxoSet)
 
 	for _, tx := range block.Transactions {
 		// Remove spent inputs
@@ -674,73 +802,68 @@ func CommitBlock(
 			if vin.Txid == "" {
 				continue
 			}
-			if err := utxoSet.Delete(vin.Txid, vin.Vout); err != nil {
+			if err := view.Delete(vin.Txid, vin.Vout); err != nil {
 				return err
 			}
-			totalDeletes++
 		}
 
 		// Add new outputs
 		for i, out := range tx.Vout {
-			if err := utxoSet.Put(tx.Txid, i, out); err != nil {
+			if err := view.Put(tx.Txid, i, out); err != nil {
 				return err
 			}
-			totalPuts++
 		}
 	}
 
-	totalMemTime = time.Since(t1)
-
-	// ==========================================
-	// PHASE 2: WriteBatch (faster, async)
-	// ==========================================
-	t2 := time.Now()
-
-	batch := db.NewWriteBatch()
-	defer batch.Cancel()
+	return CommitBlockView(block, view, utxoSet, db)
+}
 
-	for _, tx := range block.Transactions {
-		// Delete spent inputs
-		for _, vin := range tx.Vin {
-			if vin.Txid == "" {
-				continue
-			}
-			if err := batch.Delete(makeUTXOKey(vin.Txid, vin.Vout)); err != nil {
-				return err
-			}
-		}
+// CommitBlockView flushes view - block applied on top of utxoSet, e.g. by
+// VerifyBlockWithView - to db and utxoSet as one atomic commit, together with
+// the block's undo record (the outputs it spent, in input order) and the
+// UTXO best-block marker.
+func CommitBlockView(
+	block *Block,
+	view *UTXOView,
+	utxoSet UTXOProvider,
+	db *badger.DB,
+) error {
+	return commitBlockView(block, view, utxoSet, db, nil)
+}
 
-		// Add new outputs (binary serialization)
-		for i, out := range tx.Vout {
-			utxo := UTXO{
-				Txid:  tx.Txid,
-				Index: i,
-				Vout:  out,
-			}
+// commitBlockView: extra joins the same commit (chain tip, see connectBlockLocked)
+func commitBlockView(
+	block *Block,
+	view *UTXOView,
+	utxoSet UTXOProvider,
+	db *badger.DB,
+	extra func(w kvWriter) error,
+) error {
 
-			// Binary encoding instead of JSON
-			data := serializeUTXOBinary(utxo)
+	startCommit := time.Now()
+	totalPuts, totalDeletes := view.Size()
 
-			if err := batch.Set(makeUTXOKey(tx.Txid, i), data); err != nil {
-				return err
-			}
+	undo := &BlockUndo{Spent: view.spentLog}
+	err := view.flush(utxoSet, db, func(w kvWriter) error {
+		if err := w.Set(undoKey(block.Hash), undo.Serialize()); err != nil {
+			return err
 		}
-	}
-
-	// Flush all writes to disk
-	if err := batch.Flush(); err != nil {
+		if err := w.Set(utxoBestKey, block.Hash); err != nil {
+			return err
+		}
+		if extra != nil {
+			return extra(w)
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	totalDBTime = time.Since(t2)
-	totalCommitTime := time.Since(startCommit)
-
 	// Log timing breakdown
 	fmt.Printf(
-		"  [CommitBlock] total=%v | memory=%v | db=%v (WriteBatch)\n",
-		totalCommitTime,
-		totalMemTime,
-		totalDBTime,
+		"  [CommitBlock] total=%v (atomic commit + memory)\n",
+		time.Since(startCommit),
 	)
 	fmt.Printf(
 		"    operations: deletes=%d | puts=%d\n",
@@ -791,14 +914,15 @@ func serializeUTXOBinary(utxo UTXO) []byte {
 	return buf.Bytes()
 }
 
-// deserializeUTXOBinary decodes UTXO from binary format
+// deserializeUTXOBinary decodes UTXO from binary format. Strict: a short
+// read or trailing bytes is an error, never a partially filled UTXO.
 func deserializeUTXOBinary(data []byte) (UTXO, error) {
 	buf := bytes.NewReader(data)
 	utxo := UTXO{}
 
 	// Txid (32 bytes fixed)
 	txidBytes := make([]byte, 32)
-	if _, err := buf.Read(txidBytes); err != nil {
+	if _, err := io.ReadFull(buf, txidBytes); err != nil {
 		return utxo, err
 	}
 	utxo.Txid = hex.EncodeToString(txidBytes)
@@ -830,7 +954,7 @@ func deserializeUTXOBinary(data []byte) (UTXO, error) {
 		return utxo, err
 	}
 	scriptBytes := make([]byte, scriptLen)
-	if _, err := buf.Read(scriptBytes); err != nil {
+	if _, err := io.ReadFull(buf, scriptBytes); err != nil {
 		return utxo, err
 	}
 	utxo.Vout.ScriptPubKey.Hex = hex.EncodeToString(scriptBytes)
@@ -847,12 +971,15 @@ func deserializeUTXOBinary(data []byte) (UTXO, error) {
 			return utxo, err
 		}
 		addrBytes := make([]byte, addrLen)
-		if _, err := buf.Read(addrBytes); err != nil {
+		if _, err := io.ReadFull(buf, addrBytes); err != nil {
 			return utxo, err
 		}
 		utxo.Vout.ScriptPubKey.Addresses[i] = string(addrBytes)
 	}
 
+	if buf.Len() != 0 {
+		return utxo, fmt.Errorf("utxo: %d trailing bytes", buf.Len())
+	}
 	return utxo, nil
 }
 
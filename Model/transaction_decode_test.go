@@ -0,0 +1,74 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionDeserializeRoundTrip(t *testing.T) {
+	tx, _, _ := newSpendFixture(t)
+	tx.LockTime = 42
+	tx.Txid = tx.ComputeTxID()
+
+	raw := tx.Serialize()
+	decoded, err := DeserializeTransaction(raw)
+	if err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, tx) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", decoded, tx)
+	}
+	if decoded.ComputeTxID() != tx.Txid {
+		t.Fatalf("txid changed: %s != %s", decoded.ComputeTxID(), tx.Txid)
+	}
+
+	var viaMethod Transaction
+	if err := viaMethod.Deserialize(raw); err != nil {
+		t.Fatal(err)
+	}
+	if viaMethod.Txid != tx.Txid {
+		t.Fatal("Transaction.Deserialize txid mismatch")
+	}
+}
+
+func TestTransactionDeserializeCoinbaseInput(t *testing.T) {
+	tx := &Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: "", Vout: 0xffffffff, ScriptSig: ScriptSigFromBytes([]byte{0x01, 0x07})}},
+		Vout:    []VOUT{{Value: 50, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")}},
+	}
+	tx.Txid = tx.ComputeTxID()
+
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, tx) {
+		t.Fatalf("coinbase round trip mismatch:\n got %+v\nwant %+v", decoded, tx)
+	}
+}
+
+func TestTransactionDeserializeStrict(t *testing.T) {
+	tx, _, _ := newSpendFixture(t)
+	raw := tx.Serialize()
+
+	for cut := 0; cut < len(raw); cut++ {
+		if _, err := DeserializeTransaction(raw[:cut]); err == nil {
+			t.Fatalf("truncated at %d accepted", cut)
+		}
+	}
+
+	if _, err := DeserializeTransaction(append(raw, 0x00)); !errors.Is(err, ErrTxTrailingData) {
+		t.Fatalf("trailing data: got %v", err)
+	}
+
+	// vin count 1 encoded as 0xfd 0x01 0x00 is not minimal
+	bad := append([]byte{}, raw[:4]...)
+	bad = append(bad, 0xfd, 0x01, 0x00)
+	bad = append(bad, raw[5:]...)
+	if _, err := DeserializeTransaction(bad); err == nil {
+		t.Fatal("non-canonical varint accepted")
+	}
+}
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"project/helper"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// undo:<block hash> → BlockUndo.Serialize(), written by CommitBlock
+var undoKeyPrefix = []byte("undo:")
+
+var ErrNoUndoData = errors.New("no undo data for block")
+
+func undoKey(hash []byte) []byte {
+	return append(append([]byte{}, undoKeyPrefix...), hash...)
+}
+
+// BlockUndo holds the outputs a block spent, in block input order
+// (coinbase inputs skipped), so the block can be disconnected later.
+type BlockUndo struct {
+	Spent []UTXO
+}
+
+// Serialize: varint count | per output: txid (32) | varint index | value (8 LE) | varint script len | script
+func (u *BlockUndo) Serialize() []byte {
+	buf := new(bytes.Buffer)
+	helper.WriteVarInt(buf, uint64(len(u.Spent)))
+
+	for _, s := range u.Spent {
+		txid, _ := hex.DecodeString(s.Txid)
+		padded := make([]byte, 32)
+		copy(padded, txid)
+		buf.Write(padded)
+
+		helper.WriteVarInt(buf, uint64(s.Index))
+		binary.Write(buf, binary.LittleEndian, s.Vout.Value)
+
+		script, _ := hex.DecodeString(s.Vout.ScriptPubKey.Hex)
+		helper.WriteVarInt(buf, uint64(len(script)))
+		buf.Write(script)
+	}
+	return buf.Bytes()
+}
+
+// DeserializeBlockUndo is the inverse of BlockUndo.Serialize
+func DeserializeBlockUndo(data []byte) (*BlockUndo, error) {
+	r := bytes.NewReader(data)
+
+	count, err := readCount(r, 32+1+8+1)
+	if err != nil {
+		return nil, fmt.Errorf("undo count: %w", err)
+	}
+
+	u := &BlockUndo{Spent: make([]UTXO, count)}
+	for i := range u.Spent {
+		txid := make([]byte, 32)
+		if _, err := io.ReadFull(r, txid); err != nil {
+			return nil, fmt.Errorf("undo %d: %w", i, ErrTxTruncated)
+		}
+		index, err := helper.ReadVarInt(r)
+		if err != nil {
+			return nil, fmt.Errorf("undo %d index: %w", i, err)
+		}
+		var value int64
+		if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+			return nil, fmt.Errorf("undo %d: %w", i, ErrTxTruncated)
+		}
+		script, err := readScript(r)
+		if err != nil {
+			return nil, fmt.Errorf("undo %d script: %w", i, err)
+		}
+
+		u.Spent[i] = UTXO{
+			Txid:  hex.EncodeToString(txid),
+			Index: int(index),
+			Vout: VOUT{
+				Value:        value,
+				N:            int(index),
+				ScriptPubKey: ScriptPubKeyFromBytes(script),
+			},
+		}
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("undo: %w", ErrTxTrailingData)
+	}
+	return u, nil
+}
+
+func loadBlockUndo(db *badger.DB, hash []byte) (*BlockUndo, error) {
+	var undo *BlockUndo
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(undoKey(hash))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNoUndoData
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			var derr error
+			undo, derr = DeserializeBlockUndo(val)
+			return derr
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("block %x: %w", hash, err)
+	}
+	return undo, nil
+}
+
+// DisconnectBlock reverts CommitBlock: outputs created by block are removed and
+// the outputs it spent are restored from its undo record. block must be the
+// last block committed to utxoSet/db.
+//
+// The changes are built in a UTXOView and checked before anything is written,
+// then committed atomically with the deletion of the undo record and the
+// UTXO best-block marker moving to the parent.
+func DisconnectBlock(
+	block *Block,
+	utxoSet UTXOProvider,
+	db *badger.DB,
+) error {
+	return disconnectBlock(block, utxoSet, db, nil)
+}
+
+// disconnectBlock: extra joins the same commit (chain tip, see disconnectTipLocked)
+func disconnectBlock(
+	block *Block,
+	utxoSet UTXOProvider,
+	db *badger.DB,
+	extra func(w kvWriter) error,
+) error {
+
+	undo, err := loadBlockUndo(db, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	view := NewUTXOView(utxoSet)
+
+	// walk backwards: later txs may spend outputs of earlier ones
+	next := len(undo.Spent)
+	for t := len(block.Transactions) - 1; t >= 0; t-- {
+		tx := &block.Transactions[t]
+
+		for i := range tx.Vout {
+			if err := view.Delete(tx.Txid, i); err != nil {
+				return fmt.Errorf("disconnect %x: %v", block.Hash, err)
+			}
+		}
+
+		for i := len(tx.Vin) - 1; i >= 0; i-- {
+			vin := tx.Vin[i]
+			if vin.Txid == "" {
+				continue
+			}
+			if next == 0 {
+				return fmt.Errorf("disconnect %x: undo data has too few entries", block.Hash)
+			}
+			next--
+			spent := undo.Spent[next]
+			if spent.Txid != vin.Txid || spent.Index != vin.Vout {
+				return fmt.Errorf("disconnect %x: undo entry %d is %s:%d, input spends %s:%d",
+					block.Hash, next, spent.Txid, spent.Index, vin.Txid, vin.Vout)
+			}
+			if err := view.Put(spent.Txid, spent.Index, spent.Vout); err != nil {
+				return fmt.Errorf("disconnect %x: %v", block.Hash, err)
+			}
+		}
+	}
+	if next != 0 {
+		return fmt.Errorf("disconnect %x: undo data has %d unused entries", block.Hash, next)
+	}
+
+	err = view.flush(utxoSet, db, func(w kvWriter) error {
+		if err := w.Delete(undoKey(block.Hash)); err != nil {
+			return err
+		}
+		if err := w.Set(utxoBestKey, block.PrevHash); err != nil {
+			return err
+		}
+		if extra != nil {
+			return extra(w)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("disconnect %x: %w", block.Hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func loadSetFromDB(t *testing.T, db *badger.DB) map[string]UTXO {
+	t.Helper()
+	s := NewUTXOSet()
+	if err := s.LoadFromBadger(db); err != nil {
+		t.Fatal(err)
+	}
+	return s.utxos
+}
+
+func copyUTXOs(s *UTXOSet) map[string]UTXO {
+	out := make(map[string]UTXO, len(s.utxos))
+	for k, v := range s.utxos {
+		out[k] = v
+	}
+	return out
+}
+
+func TestCommitThenDisconnectRestoresUTXOSet(t *testing.T) {
+	db := openTestDB(t)
+	params := RegtestChainParams()
+
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoSet := NewUTXOSet()
+	mempool := NewInMemoryMempool()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	_, otherPub := NewKeyPair()
+	other := AddressFromPub(otherPub)
+
+	// block 1: coinbase funding addr
+	cb1 := NewCoinbaseTx(1, addr, params.BlockSubsidy(1), 0)
+	b1 := newTestBlock(t, []Transaction{cb1}, bc.Tip().Hash, 1, params.PowLimitBits)
+	if err := CommitBlock(b1, utxoSet, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+
+	memBefore := copyUTXOs(utxoSet)
+	diskBefore := loadSetFromDB(t, db)
+
+	// block 2: tx1 spends the coinbase, tx2 spends tx1 in the same block
+	tx1 := Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: cb1.Txid, Vout: 0}},
+		Vout: []VOUT{
+			{Value: 1000, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(addr)},
+			{Value: params.BlockSubsidy(1) - 2000, N: 1, ScriptPubKey: MakeP2PKHScriptPubKey(other)},
+		},
+	}
+	if err := tx1.SignEd25519(priv, utxoSet, mempool); err != nil {
+		t.Fatal(err)
+	}
+	if err := mempool.AddTransaction(&tx1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	tx2 := Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: tx1.Txid, Vout: 0}},
+		Vout:    []VOUT{{Value: 900, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(other)}},
+	}
+	if err := tx2.SignEd25519(priv, utxoSet, mempool); err != nil {
+		t.Fatal(err)
+	}
+
+	cb2 := NewCoinbaseTx(2, addr, params.BlockSubsidy(2)+1100, 0)
+	b2 := newTestBlock(t, []Transaction{cb2, tx1, tx2}, b1.Hash, 2, params.PowLimitBits)
+	if err := CommitBlock(b2, utxoSet, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := utxoSet.Get(cb1.Txid, 0); ok {
+		t.Fatal("coinbase output still unspent after block 2")
+	}
+
+	removed, err := bc.DisconnectTip(utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed.Hash, b2.Hash) {
+		t.Fatal("DisconnectTip removed the wrong block")
+	}
+
+	if !reflect.DeepEqual(copyUTXOs(utxoSet), memBefore) {
+		t.Fatal("in-memory utxo set not restored")
+	}
+	if !reflect.DeepEqual(loadSetFromDB(t, db), diskBefore) {
+		t.Fatal("on-disk utxo set not restored")
+	}
+	if got := utxoSet.FindUTXOsByAddress(other); len(got) != 0 {
+		t.Fatalf("address index still has %d outputs of block 2", len(got))
+	}
+
+	// undo record is consumed
+	if err := DisconnectBlock(b2, utxoSet, db); !errors.Is(err, ErrNoUndoData) {
+		t.Fatalf("second disconnect: got %v", err)
+	}
+
+	// chain tip moved back, also after reload
+	reloaded, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Tip().Height != 1 || !reflect.DeepEqual(reloaded.Tip().Hash, b1.Hash) {
+		t.Fatalf("reloaded tip at height %d", reloaded.Tip().Height)
+	}
+
+	// rewinding to genesis empties the set
+	if _, err := bc.DisconnectTip(utxoSet); err != nil {
+		t.Fatal(err)
+	}
+	if len(utxoSet.utxos) != 0 || len(loadSetFromDB(t, db)) != 0 {
+		t.Fatal("utxo set not empty after rewinding to genesis")
+	}
+	if _, err := bc.DisconnectTip(utxoSet); err == nil {
+		t.Fatal("genesis disconnected")
+	}
+}
+
+func TestBlockUndoRoundTrip(t *testing.T) {
+	tx, utxoSet, _ := newSpendFixture(t)
+	spent, _ := utxoSet.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+
+	undo := &BlockUndo{Spent: []UTXO{spent}}
+	decoded, err := DeserializeBlockUndo(undo.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, undo) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", decoded, undo)
+	}
+}
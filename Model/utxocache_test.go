@@ -0,0 +1,199 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func newTestCache(t *testing.T, maxBytes int64) (*CachedUTXOSet, *BadgerUTXOSet) {
+	t.Helper()
+	disk := &BadgerUTXOSet{db: openTestDB(t)}
+	return NewCachedUTXOSet(disk, maxBytes), disk
+}
+
+func cacheTxid(i int) string {
+	return fmt.Sprintf("%064x", i+1)
+}
+
+func TestCachedUTXOSetWriteBack(t *testing.T) {
+	cache, disk := newTestCache(t, DefaultUTXOCacheBytes)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+
+	if err := cache.Put(cacheTxid(0), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(cacheTxid(1), 0, VOUT{Value: 20, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := disk.Get(cacheTxid(0), 0); ok {
+		t.Fatal("Put wrote through to disk")
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := disk.Get(cacheTxid(0), 0); !ok || u.Vout.Value != 10 {
+		t.Fatal("Flush did not write the entry")
+	}
+
+	// spent marker hides the disk copy until the next flush deletes it
+	if err := cache.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(cacheTxid(0), 0); ok {
+		t.Fatal("deleted entry still visible")
+	}
+	if _, ok := disk.Get(cacheTxid(0), 0); !ok {
+		t.Fatal("Delete wrote through to disk")
+	}
+	if st := cache.Stats(); st.Dirty != 1 {
+		t.Fatalf("dirty = %d, want 1", st.Dirty)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := disk.Get(cacheTxid(0), 0); ok {
+		t.Fatal("Flush did not delete the entry")
+	}
+	if got := len(disk.FindByAddress(spk.Addresses[0])); got != 1 {
+		t.Fatalf("disk address index has %d entries, want 1", got)
+	}
+	if st := cache.Stats(); st.Dirty != 0 || st.Entries != 1 {
+		t.Fatalf("after flush: %+v", st)
+	}
+}
+
+func TestCachedUTXOSetEvictsCleanLRU(t *testing.T) {
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	entrySize := utxoMemSize(string(utxoKey(cacheTxid(0), 0)), UTXO{Txid: cacheTxid(0), Vout: VOUT{ScriptPubKey: spk}})
+	cache, _ := newTestCache(t, 10*entrySize)
+
+	// dirty entries are pinned, whatever the budget
+	for i := 0; i < 30; i++ {
+		if err := cache.Put(cacheTxid(i), 0, VOUT{Value: int64(i + 1), ScriptPubKey: spk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := cache.Stats(); st.Entries != 30 || st.Evictions != 0 {
+		t.Fatalf("dirty entries evicted: %+v", st)
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	st := cache.Stats()
+	if st.Bytes > st.MaxBytes || st.Entries != 10 || st.Evictions != 20 {
+		t.Fatalf("after flush: %+v", st)
+	}
+
+	// keep entry 0 hot while reading the rest back in
+	for i := 0; i < 30; i++ {
+		if _, ok := cache.Get(cacheTxid(0), 0); !ok {
+			t.Fatal("hot entry lost")
+		}
+		u, ok := cache.Get(cacheTxid(i), 0)
+		if !ok || u.Vout.Value != int64(i+1) {
+			t.Fatalf("entry %d not readable after eviction", i)
+		}
+	}
+	before := cache.Stats()
+	cache.Get(cacheTxid(0), 0)
+	if after := cache.Stats(); after.Hits != before.Hits+1 {
+		t.Fatal("most recently used entry was evicted")
+	}
+	if before.Misses == 0 || before.Hits == 0 {
+		t.Fatalf("hit/miss not counted: %+v", before)
+	}
+}
+
+func TestCachedUTXOSetFindByAddressOverlay(t *testing.T) {
+	cache, _ := newTestCache(t, DefaultUTXOCacheBytes)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	other := MakeP2PKHScriptPubKey("1415161718191a1b1c1d1e1f2021222324252627")
+
+	for i := 0; i < 3; i++ {
+		if err := cache.Put(cacheTxid(i), 0, VOUT{Value: int64(i + 1), ScriptPubKey: spk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// unflushed: 0 spent, 1 moved to another address, 3 added
+	if err := cache.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(cacheTxid(1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(cacheTxid(1), 0, VOUT{Value: 2, ScriptPubKey: other}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(cacheTxid(3), 0, VOUT{Value: 4, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int64
+	for _, u := range cache.FindUTXOsByAddress(spk.Addresses[0]) {
+		got = append(got, u.Vout.Value)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if fmt.Sprint(got) != "[3 4]" {
+		t.Fatalf("values for address = %v, want [3 4]", got)
+	}
+	if st := cache.Stats(); st.Entries != 4 {
+		t.Fatalf("address scan changed the cache: %+v", st)
+	}
+
+	// the moved output must leave the old address on disk too
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(cache.FindUTXOsByAddress(spk.Addresses[0])); got != 2 {
+		t.Fatalf("after flush: %d outputs for address, want 2", got)
+	}
+	if got := cache.FindUTXOsByAddress(other.Addresses[0]); len(got) != 1 || got[0].Vout.Value != 2 {
+		t.Fatalf("after flush: moved output = %+v", got)
+	}
+}
+
+// a miss read from disk must not come back as a clean entry after a flush
+// spent the output in the meantime
+func TestCachedUTXOSetMissRacesFlush(t *testing.T) {
+	cache, _ := newTestCache(t, DefaultUTXOCacheBytes)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+
+	if err := cache.Put(cacheTxid(0), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// start from a miss
+	cache.mu.Lock()
+	cache.dropLocked(cache.entries[string(utxoKey(cacheTxid(0), 0))])
+	cache.mu.Unlock()
+
+	// spend and flush while the miss is between its read and its insert
+	read, raced := cache.readDisk, false
+	cache.readDisk = func(txid string, index int) (UTXO, bool) {
+		u, found := read(txid, index)
+		if !raced {
+			raced = true
+			if err := cache.Delete(cacheTxid(0), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return u, found
+	}
+	if _, ok := cache.Get(cacheTxid(0), 0); ok {
+		t.Fatal("stale read returned")
+	}
+	if _, ok := cache.Get(cacheTxid(0), 0); ok {
+		t.Fatal("spent output cached by the racing miss")
+	}
+}
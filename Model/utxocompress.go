@@ -0,0 +1,255 @@
+package model
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"project/helper"
+	"slices"
+)
+
+// Compact UTXO encoding (schema version 3; version 2 lacks script type 0x02).
+//
+//	key:   'U' | txid (32 bytes) | varint index
+//	value: varint compressAmount(value) | script type | script payload
+//
+// Script types:
+//
+//	0x00 P2PKH: 20-byte pubkey hash (the full script is rebuilt on read)
+//	0x01 other: varint len | raw script
+//	0x02 other, with addresses: varint len | raw script |
+//	     varint count | count × (varint len | address)
+//
+// N is the output index and is not stored. Addresses are derived from the
+// script; type 0x02 is only used when they cannot be (several owners, or
+// addresses the script does not show). Decoding is strict, so every UTXO has exactly one
+// key and one value.
+var utxoKeyPrefix = []byte{'U'}
+
+const (
+	scriptTypeP2PKH byte = 0x00
+	scriptTypeRaw   byte = 0x01
+	scriptTypeAddrs byte = 0x02
+)
+
+// maxStoredAmount bounds amounts so compressAmount cannot overflow
+const maxStoredAmount = 1 << 60
+
+var ErrBadUTXOEncoding = errors.New("bad utxo encoding")
+
+// utxoKey: txid (32 bytes) | varint index. Also the key of the in-memory maps.
+func utxoKey(txid string, index int) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(txidBytes32(txid))
+	helper.WriteVarInt(buf, uint64(index))
+	return buf.Bytes()
+}
+
+// makeUTXOKey is the Badger key of an output
+func makeUTXOKey(txid string, vout int) []byte {
+	return append(append([]byte{}, utxoKeyPrefix...), utxoKey(txid, vout)...)
+}
+
+// txidBytes32: txid hex, zero-padded to 32 bytes
+func txidBytes32(txid string) []byte {
+	b, _ := hex.DecodeString(txid)
+	if len(b) < 32 {
+		padded := make([]byte, 32)
+		copy(padded, b)
+		b = padded
+	}
+	return b
+}
+
+// parseUTXOKey splits a Badger key from makeUTXOKey
+func parseUTXOKey(key []byte) (string, int, error) {
+	if !bytes.HasPrefix(key, utxoKeyPrefix) || len(key) < len(utxoKeyPrefix)+33 {
+		return "", 0, fmt.Errorf("%w: key %x", ErrBadUTXOEncoding, key)
+	}
+	r := bytes.NewReader(key[len(utxoKeyPrefix)+32:])
+	idx, err := helper.ReadVarInt(r)
+	if err != nil || r.Len() != 0 || idx > math.MaxUint32 {
+		return "", 0, fmt.Errorf("%w: key %x", ErrBadUTXOEncoding, key)
+	}
+	txid := hex.EncodeToString(key[len(utxoKeyPrefix) : len(utxoKeyPrefix)+32])
+	return txid, int(idx), nil
+}
+
+// compressAmount: amounts are mostly round numbers, so trailing decimal
+// zeros go into the exponent (same scheme as Bitcoin Core's CompressAmount)
+func compressAmount(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	e := uint64(0)
+	for n%10 == 0 && e < 9 {
+		n /= 10
+		e++
+	}
+	if e < 9 {
+		d := n % 10
+		n /= 10
+		return 1 + (n*9+d-1)*10 + e
+	}
+	return 1 + (n-1)*10 + 9
+}
+
+func decompressAmount(x uint64) (uint64, error) {
+	if x == 0 {
+		return 0, nil
+	}
+	x--
+	e := x % 10
+	x /= 10
+	var n uint64
+	if e < 9 {
+		d := x%9 + 1
+		x /= 9
+		if x > (math.MaxUint64-d)/10 {
+			return 0, fmt.Errorf("%w: amount overflow", ErrBadUTXOEncoding)
+		}
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+	for ; e > 0; e-- {
+		if n > math.MaxUint64/10 {
+			return 0, fmt.Errorf("%w: amount overflow", ErrBadUTXOEncoding)
+		}
+		n *= 10
+	}
+	return n, nil
+}
+
+// encodeUTXOValue compresses an output for storage
+func encodeUTXOValue(out VOUT) ([]byte, error) {
+	if out.Value < 0 || out.Value > maxStoredAmount {
+		return nil, fmt.Errorf("%w: amount %d out of range", ErrBadUTXOEncoding, out.Value)
+	}
+	script, err := hex.DecodeString(out.ScriptPubKey.Hex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: script hex: %v", ErrBadUTXOEncoding, err)
+	}
+
+	buf := new(bytes.Buffer)
+	helper.WriteVarInt(buf, compressAmount(uint64(out.Value)))
+	addrs := out.ScriptPubKey.Addresses
+	hash, isP2PKH := ExtractP2PKHHash(script)
+	switch {
+	case len(addrs) > 0 && !slices.Equal(addrs, ScriptPubKeyFromBytes(script).Addresses):
+		buf.WriteByte(scriptTypeAddrs)
+		helper.WriteVarInt(buf, uint64(len(script)))
+		buf.Write(script)
+		helper.WriteVarInt(buf, uint64(len(addrs)))
+		for _, a := range addrs {
+			helper.WriteVarInt(buf, uint64(len(a)))
+			buf.WriteString(a)
+		}
+	case isP2PKH:
+		buf.WriteByte(scriptTypeP2PKH)
+		buf.Write(hash)
+	default:
+		buf.WriteByte(scriptTypeRaw)
+		helper.WriteVarInt(buf, uint64(len(script)))
+		buf.Write(script)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeUTXOValue is the strict inverse of encodeUTXOValue: anything
+// encodeUTXOValue would not have produced is rejected
+func decodeUTXOValue(val []byte) (VOUT, error) {
+	var out VOUT
+	r := bytes.NewReader(val)
+
+	code, err := helper.ReadVarInt(r)
+	if err != nil {
+		return out, fmt.Errorf("%w: amount: %v", ErrBadUTXOEncoding, err)
+	}
+	amount, err := decompressAmount(code)
+	if err != nil {
+		return out, err
+	}
+	if amount > maxStoredAmount || compressAmount(amount) != code {
+		return out, fmt.Errorf("%w: non-canonical amount", ErrBadUTXOEncoding)
+	}
+	out.Value = int64(amount)
+
+	typ, err := r.ReadByte()
+	if err != nil {
+		return out, fmt.Errorf("%w: missing script", ErrBadUTXOEncoding)
+	}
+	var script []byte
+	var addrs []string
+	switch typ {
+	case scriptTypeP2PKH:
+		hash := make([]byte, 20)
+		if _, err := io.ReadFull(r, hash); err != nil {
+			return out, fmt.Errorf("%w: short pubkey hash", ErrBadUTXOEncoding)
+		}
+		script = BuildP2PKHScriptPubKey(hash)
+	case scriptTypeRaw:
+		if script, err = readScript(r); err != nil {
+			return out, fmt.Errorf("%w: script: %v", ErrBadUTXOEncoding, err)
+		}
+		if _, ok := ExtractP2PKHHash(script); ok {
+			return out, fmt.Errorf("%w: uncompressed P2PKH script", ErrBadUTXOEncoding)
+		}
+	case scriptTypeAddrs:
+		if script, err = readScript(r); err != nil {
+			return out, fmt.Errorf("%w: script: %v", ErrBadUTXOEncoding, err)
+		}
+		n, err := readCount(r, 1)
+		if err != nil || n == 0 {
+			return out, fmt.Errorf("%w: address count", ErrBadUTXOEncoding)
+		}
+		addrs = make([]string, n)
+		for i := range addrs {
+			a, err := readScript(r)
+			if err != nil {
+				return out, fmt.Errorf("%w: address: %v", ErrBadUTXOEncoding, err)
+			}
+			addrs[i] = string(a)
+		}
+	default:
+		return out, fmt.Errorf("%w: script type %d", ErrBadUTXOEncoding, typ)
+	}
+	if r.Len() != 0 {
+		return out, fmt.Errorf("%w: %d trailing bytes", ErrBadUTXOEncoding, r.Len())
+	}
+
+	out.ScriptPubKey = ScriptPubKeyFromBytes(script)
+	if addrs != nil {
+		if slices.Equal(addrs, out.ScriptPubKey.Addresses) {
+			return out, fmt.Errorf("%w: addresses stored but derivable", ErrBadUTXOEncoding)
+		}
+		out.ScriptPubKey.Addresses = addrs
+	}
+	return out, nil
+}
+
+// encodeUTXO returns the Badger key and value of utxo
+func encodeUTXO(utxo UTXO) ([]byte, []byte, error) {
+	val, err := encodeUTXOValue(utxo.Vout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("utxo %s:%d: %w", utxo.Txid, utxo.Index, err)
+	}
+	return makeUTXOKey(utxo.Txid, utxo.Index), val, nil
+}
+
+// decodeUTXO rebuilds a UTXO from its Badger key and value
+func decodeUTXO(key, val []byte) (UTXO, error) {
+	txid, idx, err := parseUTXOKey(key)
+	if err != nil {
+		return UTXO{}, err
+	}
+	out, err := decodeUTXOValue(val)
+	if err != nil {
+		return UTXO{}, fmt.Errorf("utxo %s:%d: %w", txid, idx, err)
+	}
+	out.N = idx
+	return UTXO{Txid: txid, Index: idx, Vout: out}, nil
+}
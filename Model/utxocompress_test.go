@@ -0,0 +1,97 @@
+package model
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressAmountRoundTrip(t *testing.T) {
+	for _, n := range []uint64{0, 1, 7, 10, 50, 99, 100, 1000, 123456789, 5_000_000_000, 1e9, 1e15, 21e14 + 1, maxStoredAmount} {
+		x := compressAmount(n)
+		got, err := decompressAmount(x)
+		if err != nil || got != n {
+			t.Fatalf("amount %d -> %d -> %d (%v)", n, x, got, err)
+		}
+	}
+	// round amounts shrink to a byte or two
+	if x := compressAmount(5_000_000_000); x >= 0xfd {
+		t.Fatalf("compressAmount(50 coins) = %d, want a 1-byte varint", x)
+	}
+}
+
+func TestUTXOEncodingRoundTrip(t *testing.T) {
+	txid := strings.Repeat("5a", 32)
+	p2pkh := UTXO{Txid: txid, Index: 300, Vout: VOUT{Value: 2_500_000, N: 300,
+		ScriptPubKey: MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")}}
+	raw := UTXO{Txid: txid, Index: 1, Vout: VOUT{Value: 12345, N: 1,
+		ScriptPubKey: ScriptPubKeyFromBytes([]byte{OP_DUP, OP_DROP})}}
+	shared := UTXO{Txid: txid, Index: 2, Vout: VOUT{Value: 7, N: 2,
+		ScriptPubKey: ScriptPubKeyFromBytes([]byte{OP_DUP, OP_DROP})}}
+	shared.Vout.ScriptPubKey.Addresses = []string{"aa", "bb"}
+
+	for _, want := range []UTXO{p2pkh, raw, shared} {
+		key, val, err := encodeUTXO(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeUTXO(key, val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip:\n got %+v\nwant %+v", got, want)
+		}
+	}
+
+	key, val, _ := encodeUTXO(p2pkh)
+	if len(key) != 1+32+3 || len(val) != 1+1+20 {
+		t.Fatalf("p2pkh entry is %d+%d bytes", len(key), len(val))
+	}
+
+	old := serializeUTXOBinary(p2pkh)
+	oldKey := legacyUTXOKey(p2pkh.Txid, p2pkh.Index)
+	t.Logf("P2PKH entry: version 1 %d+%d bytes, compact %d+%d bytes",
+		len(oldKey), len(old), len(key), len(val))
+}
+
+func TestDecodeUTXORejectsNonCanonical(t *testing.T) {
+	hash, _ := hex.DecodeString("0102030405060708090a0b0c0d0e0f1011121314")
+	key := makeUTXOKey(strings.Repeat("5a", 32), 0)
+
+	cases := map[string][]byte{
+		"empty":              {},
+		"bad script type":    {0x01, 0x07},
+		"short hash":         append([]byte{0x01, scriptTypeP2PKH}, hash[:19]...),
+		"trailing bytes":     append(append([]byte{0x01, scriptTypeP2PKH}, hash...), 0),
+		"p2pkh stored raw":   append([]byte{0x01, scriptTypeRaw, 25}, BuildP2PKHScriptPubKey(hash)...),
+		"non-canonical size": append([]byte{0xfd, 0x01, 0x00, scriptTypeP2PKH}, hash...),
+		"no addresses":       {0x01, scriptTypeAddrs, 1, OP_DUP, 0},
+		"derivable address": append(append([]byte{0x01, scriptTypeAddrs, 25}, BuildP2PKHScriptPubKey(hash)...),
+			append([]byte{1, 40}, hex.EncodeToString(hash)...)...),
+	}
+	for name, val := range cases {
+		if _, err := decodeUTXO(key, val); !errors.Is(err, ErrBadUTXOEncoding) {
+			t.Errorf("%s: got %v, want ErrBadUTXOEncoding", name, err)
+		}
+	}
+
+	good := append([]byte{0x01, scriptTypeP2PKH}, hash...)
+	for name, k := range map[string][]byte{
+		"no prefix":        key[1:],
+		"short txid":       key[:20],
+		"trailing key":     append(bytes.Clone(key), 0),
+		"non-canonical ix": append(bytes.Clone(key[:33]), 0xfd, 0x00, 0x00),
+	} {
+		if _, err := decodeUTXO(k, good); !errors.Is(err, ErrBadUTXOEncoding) {
+			t.Errorf("%s: got %v, want ErrBadUTXOEncoding", name, err)
+		}
+	}
+
+	if _, _, err := encodeUTXO(UTXO{Txid: "aa", Vout: VOUT{Value: -1}}); !errors.Is(err, ErrBadUTXOEncoding) {
+		t.Fatalf("negative amount: got %v", err)
+	}
+}
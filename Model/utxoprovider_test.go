@@ -0,0 +1,304 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// utxoBackends: every UTXOProvider the node can run on. The small cache
+// evicts constantly, so reads go through to disk.
+var utxoBackends = []struct {
+	name       string
+	backend    string
+	cacheBytes int64
+}{
+	{"memory", UTXOBackendMemory, 0},
+	{"cached", UTXOBackendCached, DefaultUTXOCacheBytes},
+	{"cached-tiny", UTXOBackendCached, 1024},
+	{"disk", UTXOBackendDisk, 0},
+}
+
+func openTestProvider(t *testing.T, backend string, db *badger.DB, cacheBytes int64) UTXOProvider {
+	t.Helper()
+	p, err := NewUTXOProvider(backend, db, cacheBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestUTXOProviderConformance runs the same checks against every backend
+func TestUTXOProviderConformance(t *testing.T) {
+	checks := []struct {
+		name string
+		run  func(t *testing.T, open func(db *badger.DB) UTXOProvider)
+	}{
+		{"PutGetDelete", testProviderPutGetDelete},
+		{"FindByAddress", testProviderFindByAddress},
+		{"MultiAddress", testProviderMultiAddress},
+		{"UpdateWithTransaction", testProviderUpdateWithTransaction},
+		{"CommitAndDisconnect", testProviderCommitAndDisconnect},
+	}
+	for _, b := range utxoBackends {
+		b := b
+		open := func(db *badger.DB) UTXOProvider {
+			return openTestProvider(t, b.backend, db, b.cacheBytes)
+		}
+		for _, c := range checks {
+			t.Run(b.name+"/"+c.name, func(t *testing.T) { c.run(t, open) })
+		}
+	}
+}
+
+func TestNewUTXOProviderUnknownBackend(t *testing.T) {
+	if _, err := NewUTXOProvider("tape", openTestDB(t), 0); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+}
+
+func testProviderPutGetDelete(t *testing.T, open func(db *badger.DB) UTXOProvider) {
+	p := open(openTestDB(t))
+	defer p.Close()
+	out := VOUT{Value: 42, N: 1, ScriptPubKey: MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")}
+
+	if _, ok := p.Get(cacheTxid(0), 1); ok {
+		t.Fatal("empty set has an output")
+	}
+	if err := p.Put(cacheTxid(0), 1, out); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := p.Get(cacheTxid(0), 1)
+	if !ok || !reflect.DeepEqual(got, UTXO{Txid: cacheTxid(0), Index: 1, Vout: out}) {
+		t.Fatalf("Get = %+v, %v", got, ok)
+	}
+	if err := p.Put(cacheTxid(0), 1, out); err == nil {
+		t.Fatal("Put overwrote an existing output")
+	}
+
+	if err := p.Delete(cacheTxid(0), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Get(cacheTxid(0), 1); ok {
+		t.Fatal("deleted output still visible")
+	}
+	if err := p.Delete(cacheTxid(0), 1); err == nil {
+		t.Fatal("Delete of a missing output succeeded")
+	}
+	// a spent outpoint can be created again (reorg)
+	if err := p.Put(cacheTxid(0), 1, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testProviderFindByAddress(t *testing.T, open func(db *badger.DB) UTXOProvider) {
+	p := open(openTestDB(t))
+	defer p.Close()
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	other := MakeP2PKHScriptPubKey("1415161718191a1b1c1d1e1f2021222324252627")
+
+	for i := 0; i < 4; i++ {
+		s := spk
+		if i == 2 {
+			s = other
+		}
+		if err := p.Put(cacheTxid(i), 0, VOUT{Value: int64(i + 1), ScriptPubKey: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int64
+	for _, u := range p.FindUTXOsByAddress(spk.Addresses[0]) {
+		got = append(got, u.Vout.Value)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if !reflect.DeepEqual(got, []int64{2, 4}) {
+		t.Fatalf("values for address = %v, want [2 4]", got)
+	}
+	if got := p.FindUTXOsByAddress("nobody"); len(got) != 0 {
+		t.Fatalf("unknown address has %d outputs", len(got))
+	}
+}
+
+// an output with several owners is found under each of them, also after
+// a reopen, and disappears from all of them when spent
+func testProviderMultiAddress(t *testing.T, open func(db *badger.DB) UTXOProvider) {
+	db := openTestDB(t)
+	p := open(db)
+	alice := "0102030405060708090a0b0c0d0e0f1011121314"
+	bob := "1415161718191a1b1c1d1e1f2021222324252627"
+	spk := MakeP2PKHScriptPubKey(alice)
+	spk.Addresses = []string{alice, bob}
+
+	// committed the way blocks are, so the RAM set persists it too
+	view := NewUTXOViewFromSet(p)
+	if err := view.Put(cacheTxid(0), 0, VOUT{Value: 9, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Flush(p, db); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 2; round++ {
+		for _, addr := range spk.Addresses {
+			got := p.FindUTXOsByAddress(addr)
+			if len(got) != 1 || !reflect.DeepEqual(got[0].Vout.ScriptPubKey.Addresses, spk.Addresses) {
+				t.Fatalf("round %d: outputs of %s = %+v", round, addr[:4], got)
+			}
+		}
+		p.Close()
+		p = open(db)
+	}
+
+	view = NewUTXOViewFromSet(p)
+	if err := view.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Flush(p, db); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range spk.Addresses {
+		if got := p.FindUTXOsByAddress(addr); len(got) != 0 {
+			t.Fatalf("spent output still listed for %s", addr[:4])
+		}
+	}
+	p.Close()
+}
+
+func testProviderUpdateWithTransaction(t *testing.T, open func(db *badger.DB) UTXOProvider) {
+	p := open(openTestDB(t))
+	defer p.Close()
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+
+	for i := 0; i < 2; i++ {
+		if err := p.Put(cacheTxid(i), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outs := []VOUT{{Value: 5, N: 0, ScriptPubKey: spk}, {Value: 14, N: 1, ScriptPubKey: spk}}
+
+	// second input is missing: nothing may change
+	bad := Transaction{Txid: cacheTxid(10), Vin: []VIN{{Txid: cacheTxid(0)}, {Txid: cacheTxid(7)}}, Vout: outs}
+	if err := p.UpdateWithTransaction(bad); err == nil {
+		t.Fatal("tx with a missing input applied")
+	}
+	if _, ok := p.Get(cacheTxid(0), 0); !ok {
+		t.Fatal("failed tx spent an input")
+	}
+	if _, ok := p.Get(cacheTxid(10), 0); ok {
+		t.Fatal("failed tx added an output")
+	}
+
+	// the same input twice is a double spend
+	double := Transaction{Txid: cacheTxid(11), Vin: []VIN{{Txid: cacheTxid(0)}, {Txid: cacheTxid(0)}}, Vout: outs}
+	if err := p.UpdateWithTransaction(double); err == nil {
+		t.Fatal("tx spending one output twice applied")
+	}
+
+	good := Transaction{Txid: cacheTxid(12), Vin: []VIN{{Txid: cacheTxid(0)}, {Txid: cacheTxid(1)}}, Vout: outs}
+	if err := p.UpdateWithTransaction(good); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := p.Get(cacheTxid(i), 0); ok {
+			t.Fatalf("input %d not spent", i)
+		}
+		if u, ok := p.Get(cacheTxid(12), i); !ok || u.Vout.Value != outs[i].Value {
+			t.Fatalf("output %d = %+v, %v", i, u, ok)
+		}
+	}
+	if err := p.UpdateWithTransaction(good); err == nil {
+		t.Fatal("tx applied twice")
+	}
+}
+
+// testProviderCommitAndDisconnect drives the block pipeline (sign, verify,
+// commit, disconnect, reload) on top of the provider
+func testProviderCommitAndDisconnect(t *testing.T, open func(db *badger.DB) UTXOProvider) {
+	db := openTestDB(t)
+	params := RegtestChainParams()
+	bc, err := NewBlockchain(params, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := open(db)
+	mempool := NewInMemoryMempool()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	_, otherPub := NewKeyPair()
+	other := AddressFromPub(otherPub)
+
+	cb1 := NewCoinbaseTx(1, addr, params.BlockSubsidy(1), 0)
+	b1 := newTestBlock(t, []Transaction{cb1}, bc.Tip().Hash, 1, params.PowLimitBits)
+	if err := VerifyBlock(b1, p, params); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitBlock(b1, p, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := Transaction{
+		Version: 1,
+		Vin:     []VIN{{Txid: cb1.Txid, Vout: 0}},
+		Vout: []VOUT{
+			{Value: 1000, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(other)},
+			{Value: params.BlockSubsidy(1) - 1500, N: 1, ScriptPubKey: MakeP2PKHScriptPubKey(addr)},
+		},
+	}
+	if err := tx.SignEd25519(priv, p, mempool); err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyForMempool(&tx, p, mempool) {
+		t.Fatal("signed tx rejected")
+	}
+	cb2 := NewCoinbaseTx(2, addr, params.BlockSubsidy(2)+500, 0)
+	b2 := newTestBlock(t, []Transaction{cb2, tx}, b1.Hash, 2, params.PowLimitBits)
+	if err := VerifyBlock(b2, p, params); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitBlock(b2, p, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := p.Get(cb1.Txid, 0); ok {
+		t.Fatal("spent coinbase still visible")
+	}
+	if got := p.FindUTXOsByAddress(other); len(got) != 1 || got[0].Vout.Value != 1000 {
+		t.Fatalf("outputs of other = %+v", got)
+	}
+	if got := len(loadSetFromDB(t, db)); got != 3 {
+		t.Fatalf("disk has %d outputs after block 2, want 3", got)
+	}
+
+	// a reopened provider sees the committed state
+	p.Close()
+	p = open(db)
+	if got := len(p.FindUTXOsByAddress(addr)); got != 2 {
+		t.Fatalf("after reopen: %d outputs for addr, want 2", got)
+	}
+
+	if _, err := bc.DisconnectTip(p); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := p.Get(cb1.Txid, 0); !ok || u.Vout.Value != params.BlockSubsidy(1) {
+		t.Fatal("disconnect did not restore the spent coinbase")
+	}
+	if got := p.FindUTXOsByAddress(other); len(got) != 0 {
+		t.Fatalf("outputs of the disconnected block still indexed: %+v", got)
+	}
+	if got := len(loadSetFromDB(t, db)); got != 1 {
+		t.Fatalf("disk has %d outputs after disconnect, want 1", got)
+	}
+	p.Close()
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// UTXOSetInfo summarizes a UTXO set. Two sets are equal iff their MuHash is.
+type UTXOSetInfo struct {
+	Count          int64
+	TotalAmount    int64
+	SerializedSize int64 // key+value bytes of the compact encoding
+	MuHash         [32]byte
+}
+
+func (i UTXOSetInfo) String() string {
+	return fmt.Sprintf("utxos=%d total=%d size=%dB muhash=%s",
+		i.Count, i.TotalAmount, i.SerializedSize, hex.EncodeToString(i.MuHash[:]))
+}
+
+// chainstate:utxostats → count | total | size (u64 LE each) | MuHash state
+//
+// Updated in the same atomic write as the UTXO entries it describes, so it
+// always matches the on-disk set. Missing in DBs from older builds: it is
+// then computed by a full scan on first use.
+var utxoStatsKey = []byte("chainstate:utxostats")
+
+// utxoStatsMu serializes read-modify-write of utxoStatsKey
+var utxoStatsMu sync.Mutex
+
+type utxoStats struct {
+	count, total, size int64
+	hash               *MuHash
+}
+
+func newUTXOStats() *utxoStats {
+	return &utxoStats{hash: NewMuHash()}
+}
+
+func (s *utxoStats) add(u UTXO) error {
+	key, val, err := encodeUTXO(u)
+	if err != nil {
+		return err
+	}
+	s.count++
+	s.total += u.Vout.Value
+	s.size += int64(len(key) + len(val))
+	s.hash.Insert(append(key, val...))
+	return nil
+}
+
+func (s *utxoStats) remove(u UTXO) error {
+	key, val, err := encodeUTXO(u)
+	if err != nil {
+		return err
+	}
+	s.count--
+	s.total -= u.Vout.Value
+	s.size -= int64(len(key) + len(val))
+	s.hash.Remove(append(key, val...))
+	return nil
+}
+
+// apply: each change must be a transition (an added output was not in the
+// set, a removed one was)
+func (s *utxoStats) apply(changes []utxoChange) error {
+	for _, c := range changes {
+		var err error
+		if c.exists {
+			err = s.add(c.utxo)
+		} else {
+			err = s.remove(c.utxo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *utxoStats) info() UTXOSetInfo {
+	return UTXOSetInfo{
+		Count:          s.count,
+		TotalAmount:    s.total,
+		SerializedSize: s.size,
+		MuHash:         s.hash.Digest(),
+	}
+}
+
+func (s *utxoStats) marshal() []byte {
+	buf := make([]byte, 24, 24+muHashBytes)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(s.count))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(s.total))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(s.size))
+	return append(buf, s.hash.MarshalBinary()...)
+}
+
+func unmarshalUTXOStats(b []byte) (*utxoStats, error) {
+	if len(b) != 24+muHashBytes {
+		return nil, fmt.Errorf("utxo stats: %d bytes", len(b))
+	}
+	hash, err := UnmarshalMuHash(b[24:])
+	if err != nil {
+		return nil, err
+	}
+	return &utxoStats{
+		count: int64(binary.LittleEndian.Uint64(b[0:])),
+		total: int64(binary.LittleEndian.Uint64(b[8:])),
+		size:  int64(binary.LittleEndian.Uint64(b[16:])),
+		hash:  hash,
+	}, nil
+}
+
+// loadUTXOStatsTxn reads the stored stats, or scans the set if there are none
+func loadUTXOStatsTxn(txn *badger.Txn) (*utxoStats, error) {
+	item, err := txn.Get(utxoStatsKey)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return scanUTXOStatsTxn(txn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s *utxoStats
+	err = item.Value(func(val []byte) error {
+		s, err = unmarshalUTXOStats(val)
+		return err
+	})
+	return s, err
+}
+
+func scanUTXOStatsTxn(txn *badger.Txn) (*utxoStats, error) {
+	s := newUTXOStats()
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(utxoKeyPrefix); it.ValidForPrefix(utxoKeyPrefix); it.Next() {
+		item := it.Item()
+		err := item.Value(func(val []byte) error {
+			utxo, err := decodeUTXO(item.Key(), val)
+			if err != nil {
+				return err
+			}
+			return s.add(utxo)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// writeAtomicWithStats commits ops together with the stats updated by
+// changes (see writeAtomic)
+func writeAtomicWithStats(db *badger.DB, ops opList, changes []utxoChange) error {
+	utxoStatsMu.Lock()
+	defer utxoStatsMu.Unlock()
+
+	var s *utxoStats
+	err := db.View(func(txn *badger.Txn) error {
+		var err error
+		s, err = loadUTXOStatsTxn(txn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.apply(changes); err != nil {
+		return err
+	}
+	ops.Set(utxoStatsKey, s.marshal())
+	return writeAtomic(db, ops)
+}
+
+// ReadUTXOStats returns the stats of the UTXO set in db as kept by block
+// commits: one read, no scan (except once for a DB from an older build)
+func ReadUTXOStats(db *badger.DB) (UTXOSetInfo, error) {
+	var info UTXOSetInfo
+	err := db.View(func(txn *badger.Txn) error {
+		s, err := loadUTXOStatsTxn(txn)
+		if err != nil {
+			return err
+		}
+		info = s.info()
+		return nil
+	})
+	return info, err
+}
+
+// Info computes the stats of the set by a full scan
+func (u *UTXOSet) Info() (UTXOSetInfo, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	s := newUTXOStats()
+	for _, utxo := range u.utxos {
+		if err := s.add(utxo); err != nil {
+			return UTXOSetInfo{}, err
+		}
+	}
+	return s.info(), nil
+}
+
+// Info computes the stats of the on-disk set by a full scan; compare with
+// ReadUTXOStats to check the incremental stats
+func (u *BadgerUTXOSet) Info() (UTXOSetInfo, error) {
+	var info UTXOSetInfo
+	err := u.db.View(func(txn *badger.Txn) error {
+		s, err := scanUTXOStatsTxn(txn)
+		if err != nil {
+			return err
+		}
+		info = s.info()
+		return nil
+	})
+	return info, err
+}
+
+// Info flushes and computes the stats of the on-disk set by a full scan
+func (c *CachedUTXOSet) Info() (UTXOSetInfo, error) {
+	if c.db != nil {
+		if err := c.Flush(); err != nil {
+			return UTXOSetInfo{}, err
+		}
+		return c.db.Info()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := newUTXOStats()
+	for _, e := range c.entries {
+		if e.spent {
+			continue
+		}
+		if err := s.add(e.utxo); err != nil {
+			return UTXOSetInfo{}, err
+		}
+	}
+	return s.info(), nil
+}
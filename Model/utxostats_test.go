@@ -0,0 +1,258 @@
+package model
+
+import (
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestMuHashSetProperties(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	x := NewMuHash()
+	x.Insert(a)
+	x.Insert(b)
+	x.Insert(c)
+	y := NewMuHash()
+	y.Insert(c)
+	y.Insert(a)
+	y.Insert(b)
+	if x.Digest() != y.Digest() {
+		t.Fatal("digest depends on insertion order")
+	}
+
+	y.Remove(c)
+	if x.Digest() == y.Digest() {
+		t.Fatal("different sets, same digest")
+	}
+	y.Insert(c)
+	y.Remove(b)
+	y.Insert(b)
+	if x.Digest() != y.Digest() {
+		t.Fatal("remove then insert changed the digest")
+	}
+
+	z := NewMuHash()
+	z.Insert(a)
+	z.Remove(a)
+	if z.Digest() != NewMuHash().Digest() {
+		t.Fatal("insert then remove is not the empty set")
+	}
+
+	restored, err := UnmarshalMuHash(x.MarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.Remove(a)
+	x.Remove(a)
+	if restored.Digest() != x.Digest() {
+		t.Fatal("state did not survive a round trip")
+	}
+	if _, err := UnmarshalMuHash(make([]byte, muHashBytes)); err == nil {
+		t.Fatal("zero state accepted")
+	}
+}
+
+// checkUTXOStats compares the stored stats with a full scan of db
+func checkUTXOStats(t *testing.T, db *badger.DB, wantCount, wantTotal int64) UTXOSetInfo {
+	t.Helper()
+	stored, err := ReadUTXOStats(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := NewBadgerUTXOSetFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned, err := disk.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != scanned {
+		t.Fatalf("stored stats %v\n  full scan %v", stored, scanned)
+	}
+	if stored.Count != wantCount || stored.TotalAmount != wantTotal {
+		t.Fatalf("stats %v, want %d utxos worth %d", stored, wantCount, wantTotal)
+	}
+	return stored
+}
+
+func TestUTXOStatsFollowCommits(t *testing.T) {
+	for _, b := range utxoBackends {
+		t.Run(b.name, func(t *testing.T) {
+			db := openTestDB(t)
+			params := RegtestChainParams()
+			bc, err := NewBlockchain(params, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := openTestProvider(t, b.backend, db, b.cacheBytes)
+			defer p.Close()
+
+			empty := checkUTXOStats(t, db, 0, 0)
+
+			_, pub := NewKeyPair()
+			addr := AddressFromPub(pub)
+			cb1 := NewCoinbaseTx(1, addr, params.BlockSubsidy(1), 0)
+			b1 := newTestBlock(t, []Transaction{cb1}, bc.Tip().Hash, 1, params.PowLimitBits)
+			if err := CommitBlock(b1, p, db); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.AppendBlock(b1); err != nil {
+				t.Fatal(err)
+			}
+			atB1 := checkUTXOStats(t, db, 1, params.BlockSubsidy(1))
+
+			// spend the coinbase into two outputs, burning 100 as fee
+			tx := Transaction{
+				Version: 1,
+				Vin:     []VIN{{Txid: cb1.Txid, Vout: 0}},
+				Vout: []VOUT{
+					{Value: 1000, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(addr)},
+					{Value: params.BlockSubsidy(1) - 1100, N: 1, ScriptPubKey: MakeP2PKHScriptPubKey(addr)},
+				},
+			}
+			tx.Txid = tx.ComputeTxID()
+			cb2 := NewCoinbaseTx(2, addr, params.BlockSubsidy(2), 0)
+			b2 := newTestBlock(t, []Transaction{cb2, tx}, b1.Hash, 2, params.PowLimitBits)
+			if err := CommitBlock(b2, p, db); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.AppendBlock(b2); err != nil {
+				t.Fatal(err)
+			}
+			checkUTXOStats(t, db, 3, params.BlockSubsidy(1)+params.BlockSubsidy(2)-100)
+
+			if info, ok := p.(interface{ Info() (UTXOSetInfo, error) }); ok {
+				got, err := info.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored, _ := ReadUTXOStats(db); got != stored {
+					t.Fatalf("provider Info %v\n  stored %v", got, stored)
+				}
+			}
+
+			// disconnecting brings back the exact same hash
+			if _, err := bc.DisconnectTip(p); err != nil {
+				t.Fatal(err)
+			}
+			if got := checkUTXOStats(t, db, 1, params.BlockSubsidy(1)); got != atB1 {
+				t.Fatalf("after disconnect %v\n      at block 1 %v", got, atB1)
+			}
+			if _, err := bc.DisconnectTip(p); err != nil {
+				t.Fatal(err)
+			}
+			if got := checkUTXOStats(t, db, 0, 0); got != empty {
+				t.Fatalf("empty set hash changed: %v", got)
+			}
+		})
+	}
+}
+
+func TestUTXOStatsDirectWrites(t *testing.T) {
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+
+	disk := &BadgerUTXOSet{db: openTestDB(t)}
+	if err := disk.Put(cacheTxid(0), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Put(cacheTxid(1), 0, VOUT{Value: 20, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Put(cacheTxid(1), 0, VOUT{Value: 20, ScriptPubKey: spk}); err == nil {
+		t.Fatal("duplicate Put accepted")
+	}
+	if err := disk.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	tx := Transaction{Txid: cacheTxid(2), Vin: []VIN{{Txid: cacheTxid(1)}},
+		Vout: []VOUT{{Value: 7, ScriptPubKey: spk}, {Value: 8, ScriptPubKey: spk}}}
+	if err := disk.UpdateWithTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, disk.db, 2, 15)
+
+	cache, cdisk := newTestCache(t, DefaultUTXOCacheBytes)
+	if err := cache.Put(cacheTxid(0), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// spent and recreated with another value in the same flush
+	if err := cache.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(cacheTxid(0), 0, VOUT{Value: 11, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(cacheTxid(1), 0, VOUT{Value: 5, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(cacheTxid(1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, cdisk.db, 1, 11)
+}
+
+func TestReadUTXOStatsScansOldDB(t *testing.T) {
+	disk := &BadgerUTXOSet{db: openTestDB(t)}
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	for i := 0; i < 3; i++ {
+		if err := disk.Put(cacheTxid(i), 0, VOUT{Value: 100, ScriptPubKey: spk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// as left by a build without stats
+	if err := disk.db.Update(func(txn *badger.Txn) error { return txn.Delete(utxoStatsKey) }); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, disk.db, 3, 300)
+
+	if err := disk.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, disk.db, 2, 200)
+}
+
+// a commit spending outputs that are still dirty in the cache must leave the
+// stored stats equal to a full scan
+func TestUTXOStatsCommitSpendsDirtyCache(t *testing.T) {
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	cache, disk := newTestCache(t, DefaultUTXOCacheBytes)
+
+	if err := cache.Put(cacheTxid(0), 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// not flushed: a new output and the on-disk one spent
+	if err := cache.Put(cacheTxid(1), 0, VOUT{Value: 20, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(cacheTxid(0), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	view := NewUTXOView(cache)
+	tx := Transaction{Txid: cacheTxid(2), Vin: []VIN{{Txid: cacheTxid(1)}},
+		Vout: []VOUT{{Value: 7, ScriptPubKey: spk}}}
+	ApplyTxToView(&tx, view)
+	if err := view.Flush(cache, disk.db); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, disk.db, 1, 7)
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkUTXOStats(t, disk.db, 1, 7)
+	if _, ok := cache.Get(cacheTxid(0), 0); ok {
+		t.Fatal("output spent in the cache came back")
+	}
+}
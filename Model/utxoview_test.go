@@ -0,0 +1,114 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUTXOViewOverlay(t *testing.T) {
+	db := openTestDB(t)
+	spk := MakeP2PKHScriptPubKey("0102030405060708090a0b0c0d0e0f1011121314")
+	base := NewUTXOSet()
+	if err := base.Put("aa", 0, VOUT{Value: 10, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Put("aa", 1, VOUT{Value: 20, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+
+	view := NewUTXOView(base)
+	if u, ok := view.Get("aa", 0); !ok || u.Vout.Value != 10 {
+		t.Fatal("view does not fall through to base")
+	}
+
+	if err := view.Delete("aa", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Put("bb", 0, VOUT{Value: 5, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	// created and spent inside the view: never reaches the base
+	if err := view.Put("cc", 0, VOUT{Value: 7, ScriptPubKey: spk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Delete("cc", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := view.Get("aa", 0); ok {
+		t.Fatal("spent marker ignored")
+	}
+	if err := view.Delete("aa", 0); err == nil {
+		t.Fatal("double spend in view accepted")
+	}
+	if err := view.Put("aa", 1, VOUT{Value: 1}); err == nil {
+		t.Fatal("overwrite of base output accepted")
+	}
+
+	// base untouched until Flush
+	if _, ok := base.Get("aa", 0); !ok {
+		t.Fatal("view modified its base")
+	}
+	if _, ok := base.Get("bb", 0); ok {
+		t.Fatal("view modified its base")
+	}
+	if added, spent := view.Size(); added != 1 || spent != 1 {
+		t.Fatalf("view diff added=%d spent=%d, want 1/1", added, spent)
+	}
+
+	if err := view.Flush(base, db); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"aa:0": false, "aa:1": true, "bb:0": true, "cc:0": false}
+	for key, exists := range want {
+		txid, idx := key[:2], int(key[3]-'0')
+		if _, ok := base.Get(txid, idx); ok != exists {
+			t.Fatalf("%s in set = %v, want %v", key, ok, exists)
+		}
+	}
+	if got := len(base.FindUTXOsByAddress(spk.Addresses[0])); got != 2 {
+		t.Fatalf("address index has %d outputs, want 2", got)
+	}
+
+	// Badger only got the diff: bb:0 written, aa:0 deleted (aa:1 was never on disk)
+	onDisk := loadSetFromDB(t, db)
+	if len(onDisk) != 1 {
+		t.Fatalf("disk after flush has %d outputs, want 1", len(onDisk))
+	}
+	for _, u := range onDisk {
+		if u.Vout.Value != 5 {
+			t.Fatalf("disk has %+v, want bb:0", u)
+		}
+	}
+
+	if added, spent := view.Size(); added != 0 || spent != 0 {
+		t.Fatal("view not reset by Flush")
+	}
+}
+
+func TestVerifyAndCommitShareView(t *testing.T) {
+	block, utxoSet, params := newValidationBlock(t, 12, 0)
+
+	reference := NewUTXOSet()
+	for _, u := range utxoSet.utxos {
+		if err := reference.Put(u.Txid, u.Index, u.Vout); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	view := NewUTXOView(utxoSet)
+	if err := VerifyBlockWithView(block, view, params); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitBlockView(block, view, utxoSet, openTestDB(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// same end state as the standalone CommitBlock
+	if err := CommitBlock(block, reference, openTestDB(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(utxoSet.utxos, reference.utxos) {
+		t.Fatal("shared view commit differs from CommitBlock")
+	}
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ValidationWorkers is the number of goroutines VerifyBlock runs scripts on
+// (<= 0 means runtime.NumCPU())
+var ValidationWorkers = 0
+
+// scriptJob is one input whose script still has to run
+type scriptJob struct {
+	txIdx   int
+	inIdx   int
+	prevOut VOUT
+}
+
+// VerifyBlock: header PoW, then every tx in block order, finally coinbase
+// reward <= subsidy(height) + fees. Same result and error as VerifyBlockSerial.
+func VerifyBlock(block *Block, utxoSet UTXOProvider, params *ChainParams) error {
+	return VerifyBlockWithView(block, NewUTXOView(utxoSet), params)
+}
+
+// VerifyBlockWithView validates block on top of view and leaves the block
+// applied to it, ready for CommitBlockView. On error the view is garbage.
+//
+// Pipeline:
+//  1. one pass in block order resolves inputs (including outputs of earlier
+//     txs in the block) and checks amounts, applying each tx to the view -
+//     no scripts yet
+//  2. the scripts of every resolved input run across ValidationWorkers
+//  3. the reported error is the one the serial path would hit first: the
+//     lowest failing script in block order, unless the pass-1 failure
+//     comes before it
+func VerifyBlockWithView(block *Block, view *UTXOView, params *ChainParams) error {
+
+	// 0️⃣ header: hash + proof of work
+	if err := CheckBlockHeader(block, params); err != nil {
+		return err
+	}
+
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block has no coinbase")
+	}
+
+	// 1️⃣ resolve inputs in block order
+	var jobs []scriptJob
+	var structErr error // first pass-1 failure, ordered after all queued jobs
+
+	fees := int64(0)
+	for i := 1; i < len(block.Transactions); i++ {
+		tx := &block.Transactions[i]
+
+		prevOuts, fee, err := checkTxInputs(tx, view.Get)
+		for inIdx, prevOut := range prevOuts {
+			jobs = append(jobs, scriptJob{txIdx: i, inIdx: inIdx, prevOut: prevOut})
+		}
+		if err != nil {
+			structErr = blockTxError(tx, i, err)
+			break
+		}
+		fees += fee
+		if !MoneyRange(fees) {
+			structErr = fmt.Errorf("block fees out of range")
+			break
+		}
+
+		ApplyTxToView(tx, view)
+	}
+
+	// 2️⃣ scripts in parallel
+	if failed, err := runScriptJobs(block, jobs); err != nil {
+		job := jobs[failed]
+		tx := &block.Transactions[job.txIdx]
+		return blockTxError(tx, job.txIdx, scriptError(job.inIdx, err))
+	}
+	if structErr != nil {
+		return structErr
+	}
+
+	// 3️⃣ coinbase
+	coinbase := &block.Transactions[0]
+	if err := CheckCoinbase(coinbase, block.Height, fees, params); err != nil {
+		return fmt.Errorf("coinbase %s invalid: %v", coinbase.Txid, err)
+	}
+	ApplyTxToView(coinbase, view)
+
+	return nil
+}
+
+// runScriptJobs verifies jobs on ValidationWorkers goroutines and returns the
+// lowest failing job index. Jobs above a known failure are skipped; jobs
+// below it always run, so the result does not depend on scheduling.
+func runScriptJobs(block *Block, jobs []scriptJob) (int, error) {
+	workers := ValidationWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	var next atomic.Int64
+	var mu sync.Mutex
+	failed := len(jobs)
+	var failErr error
+
+	lowestFailure := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(jobs) || i > lowestFailure() {
+					return
+				}
+				job := jobs[i]
+				tx := &block.Transactions[job.txIdx]
+
+				if err := verifyInputCached(tx, job.inIdx, job.prevOut, false); err != nil {
+					mu.Lock()
+					if i < failed {
+						failed, failErr = i, err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failed, failErr
+}
+
+func blockTxError(tx *Transaction, i int, err error) error {
+	return fmt.Errorf("tx %s invalid: %v, with index %d", tx.Txid, err, i)
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newValidationBlock builds a solved regtest block with nTx signed txs; every
+// fourth tx spends the previous tx's output inside the block. extra unrelated
+// UTXOs pad the set like a long-running node.
+func newValidationBlock(tb testing.TB, nTx, extra int) (*Block, *UTXOSet, *ChainParams) {
+	tb.Helper()
+	params := RegtestChainParams()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	spk := MakeP2PKHScriptPubKey(addr)
+
+	utxoSet := NewUTXOSet()
+	mempool := NewInMemoryMempool()
+	for i := 0; i < extra; i++ {
+		if err := utxoSet.Put(fmt.Sprintf("%064x", 1<<40+i), 0, VOUT{Value: 1, ScriptPubKey: spk}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	txs := make([]Transaction, 0, nTx+1)
+	fees := int64(0)
+	for i := 0; i < nTx; i++ {
+		var tx Transaction
+		if i%4 == 3 {
+			prev := txs[len(txs)-1]
+			tx = Transaction{Version: 1, Vin: []VIN{{Txid: prev.Txid, Vout: 0}}}
+			tx.Vout = []VOUT{{Value: prev.Vout[0].Value - 100, ScriptPubKey: spk}}
+		} else {
+			fund := fmt.Sprintf("%064x", i+1)
+			if err := utxoSet.Put(fund, 0, VOUT{Value: 1000, ScriptPubKey: spk}); err != nil {
+				tb.Fatal(err)
+			}
+			tx = Transaction{Version: 1, Vin: []VIN{{Txid: fund, Vout: 0}}}
+			tx.Vout = []VOUT{{Value: 900, ScriptPubKey: spk}}
+		}
+		if err := tx.SignEd25519(priv, utxoSet, mempool); err != nil {
+			tb.Fatal(err)
+		}
+		if err := mempool.AddTransaction(&tx, 100); err != nil {
+			tb.Fatal(err)
+		}
+		txs = append(txs, tx)
+		fees += 100
+	}
+
+	coinbase := NewCoinbaseTx(1, addr, params.BlockSubsidy(1)+fees, 0)
+	block := NewBlock(append([]Transaction{coinbase}, txs...), []byte{}, 1)
+	block.Bits = params.PowLimitBits
+	if !block.Solve(nil) {
+		tb.Fatal("could not solve block")
+	}
+	return block, utxoSet, params
+}
+
+// breakSig changes an output so the signature no longer matches
+func breakSig(tx *Transaction) {
+	tx.Vout[0].Value--
+	tx.Txid = tx.ComputeTxID()
+}
+
+// breakInput points input 0 at an output that doesn't exist
+func breakInput(tx *Transaction) {
+	tx.Vin[0].Txid = strings.Repeat("ab", 32)
+	tx.Txid = tx.ComputeTxID()
+}
+
+func TestVerifyBlockMatchesSerial(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(b *Block)
+	}{
+		{"valid", func(b *Block) {}},
+		{"bad sig", func(b *Block) { breakSig(&b.Transactions[9]) }},
+		{"bad sigs, lowest wins", func(b *Block) {
+			breakSig(&b.Transactions[30])
+			breakSig(&b.Transactions[2])
+			breakSig(&b.Transactions[17])
+		}},
+		{"missing input before bad sig", func(b *Block) {
+			breakInput(&b.Transactions[5])
+			breakSig(&b.Transactions[21])
+		}},
+		{"bad sig before missing input", func(b *Block) {
+			breakSig(&b.Transactions[5])
+			breakInput(&b.Transactions[21])
+		}},
+		{"child of broken parent", func(b *Block) { breakSig(&b.Transactions[3]) }},
+	}
+
+	for _, tc := range cases {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/workers=%d", tc.name, workers), func(t *testing.T) {
+				ValidationWorkers = workers
+				defer func() { ValidationWorkers = 0 }()
+
+				block, utxoSet, params := newValidationBlock(t, 40, 0)
+				tc.mutate(block)
+
+				serial := VerifyBlockSerial(block, utxoSet, params)
+				parallel := VerifyBlock(block, utxoSet, params)
+
+				if fmt.Sprint(serial) != fmt.Sprint(parallel) {
+					t.Fatalf("serial: %v\nparallel: %v", serial, parallel)
+				}
+				if tc.name == "valid" && parallel != nil {
+					t.Fatal(parallel)
+				}
+				if tc.name != "valid" && parallel == nil {
+					t.Fatal("broken block accepted")
+				}
+			})
+		}
+	}
+}
+
+func benchmarkVerifyBlock(b *testing.B, verify func(*Block, UTXOProvider, *ChainParams) error) {
+	block, utxoSet, params := newValidationBlock(b, 2000, 100_000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := verify(block, utxoSet, params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyBlockSerial(b *testing.B) {
+	benchmarkVerifyBlock(b, VerifyBlockSerial)
+}
+
+func BenchmarkVerifyBlockParallel(b *testing.B) {
+	benchmarkVerifyBlock(b, VerifyBlock)
+}
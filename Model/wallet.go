@@ -2,47 +2,128 @@ package model
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
 type Wallet struct {
 	Address string
-	utxos   map[string]UTXO // key = txid:vout
-	mu      sync.Mutex
+
+	// Selector picks inputs in CreateTransaction (nil → DefaultCoinSelector)
+	Selector CoinSelector
+
+	utxos map[string]UTXO // key = txid:vout
+
+	// receive order of each utxo (key = txid:vout), used for oldest-first ordering
+	received map[string]uint64
+	nextSeq  uint64
+
+	mu sync.Mutex
 }
 
 func NewWallet(addr string) *Wallet {
 	return &Wallet{
-		Address: addr,
-		utxos:   make(map[string]UTXO),
+		Address:  addr,
+		utxos:    make(map[string]UTXO),
+		received: make(map[string]uint64),
+	}
+}
+
+// addUTXOLocked stores u and records when the wallet first saw it (w.mu held)
+func (w *Wallet) addUTXOLocked(key string, u UTXO) {
+	w.utxos[key] = u
+	if _, ok := w.received[key]; !ok {
+		w.received[key] = w.nextSeq
+		w.nextSeq++
 	}
 }
 
+func (w *Wallet) removeUTXOLocked(key string) {
+	delete(w.utxos, key)
+	delete(w.received, key)
+}
+
+// GetSpendableUTXOs returns outputs not spent in mempool, oldest first
+// (receive order, ties broken by txid:vout) so coin selection is reproducible.
 func (w *Wallet) GetSpendableUTXOs(
-	mempool *InMemoryMempool,
+	mempool Mempool,
 ) []UTXO {
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	var res []UTXO
-	for _, u := range w.utxos {
+	type entry struct {
+		seq  uint64
+		utxo UTXO
+	}
+
+	var entries []entry
+	for k, u := range w.utxos {
 		if mempool.IsSpent(u.Txid, u.Index) {
 			continue
 		}
-		res = append(res, u)
+		entries = append(entries, entry{seq: w.received[k], utxo: u})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.seq != b.seq {
+			return a.seq < b.seq
+		}
+		if a.utxo.Txid != b.utxo.Txid {
+			return a.utxo.Txid < b.utxo.Txid
+		}
+		return a.utxo.Index < b.utxo.Index
+	})
+
+	res := make([]UTXO, len(entries))
+	for i, e := range entries {
+		res[i] = e.utxo
 	}
 	return res
 }
 
-func (w *Wallet) LoadFromUTXOSet(utxoSet *UTXOSet) {
+// LoadFromUTXOSet loads the confirmed outputs of the wallet. Their receive
+// order is rebuilt from where chain confirms them (height, then position in
+// the block); outputs chain does not know, or all of them when chain is nil,
+// come last by txid:vout.
+func (w *Wallet) LoadFromUTXOSet(utxoSet UTXOProvider, chain *Blockchain) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	outs := utxoSet.FindUTXOsByAddress(w.Address)
+
+	var locs map[string]TxLocation
+	if chain != nil {
+		txids := make([]string, len(outs))
+		for i, u := range outs {
+			txids[i] = u.Txid
+		}
+		locs = chain.LocateTxs(txids)
+	}
+
+	// UTXOSet returns map order; sort so receive sequence is deterministic
+	sort.Slice(outs, func(i, j int) bool {
+		li, iok := locs[outs[i].Txid]
+		lj, jok := locs[outs[j].Txid]
+		if iok != jok {
+			return iok
+		}
+		if li != lj {
+			if li.Height != lj.Height {
+				return li.Height < lj.Height
+			}
+			return li.Index < lj.Index
+		}
+		if outs[i].Txid != outs[j].Txid {
+			return outs[i].Txid < outs[j].Txid
+		}
+		return outs[i].Index < outs[j].Index
+	})
+
 	for _, u := range outs {
 		key := fmt.Sprintf("%s:%d", u.Txid, u.Index)
-		w.utxos[key] = u
+		w.addUTXOLocked(key, u)
 	}
 }
 
@@ -53,18 +134,18 @@ func (w *Wallet) ApplyUnconfirmedTx(tx Transaction) {
 	// remove spent inputs
 	for _, vin := range tx.Vin {
 		key := fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)
-		delete(w.utxos, key)
+		w.removeUTXOLocked(key)
 	}
 
 	// add new outputs (change)
 	for i, vout := range tx.Vout {
 		if IsOutputForAddress(vout, w.Address) {
 			key := fmt.Sprintf("%s:%d", tx.Txid, i)
-			w.utxos[key] = UTXO{
+			w.addUTXOLocked(key, UTXO{
 				Txid:  tx.Txid,
 				Index: i,
 				Vout:  vout,
-			}
+			})
 		}
 	}
 }
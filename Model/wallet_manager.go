@@ -18,7 +18,8 @@ func NewWalletManager() *WalletManager {
 
 func (wm *WalletManager) GetWallet(
 	addr string,
-	utxoSet *UTXOSet,
+	utxoSet UTXOProvider,
+	chain *Blockchain,
 ) *Wallet {
 
 	wm.mu.Lock()
@@ -32,8 +33,8 @@ func (wm *WalletManager) GetWallet(
 	// 2) tạo wallet mới
 	w := NewWallet(addr)
 
-	// load UTXO confirmed ban đầu
-	w.LoadFromUTXOSet(utxoSet)
+	// load UTXO confirmed ban đầu (receive order từ chain)
+	w.LoadFromUTXOSet(utxoSet, chain)
 
 	wm.Wallets[addr] = w
 	return w
@@ -48,7 +49,7 @@ func (wm *WalletManager) ApplyUnconfirmedTx(tx Transaction) {
 		for _, w := range wm.Wallets {
 			w.mu.Lock()
 			key := fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)
-			delete(w.utxos, key)
+			w.removeUTXOLocked(key)
 			w.mu.Unlock()
 		}
 	}
@@ -59,11 +60,11 @@ func (wm *WalletManager) ApplyUnconfirmedTx(tx Transaction) {
 			if IsOutputForAddress(vout, w.Address) {
 				w.mu.Lock()
 				key := fmt.Sprintf("%s:%d", tx.Txid, i)
-				w.utxos[key] = UTXO{
+				w.addUTXOLocked(key, UTXO{
 					Txid:  tx.Txid,
 					Index: i,
 					Vout:  vout,
-				}
+				})
 				w.mu.Unlock()
 			}
 		}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+)
+
+// fundedWallet returns a wallet whose address owns one confirmed UTXO per value
+func fundedWallet(t *testing.T, values ...int64) (ed25519.PrivateKey, *Wallet, *UTXOSet) {
+	t.Helper()
+
+	priv, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	utxoSet := NewUTXOSet()
+
+	for i, v := range values {
+		txid := fmt.Sprintf("%064x", i+1)
+		if err := utxoSet.Put(txid, 0, VOUT{Value: v, N: 0, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWallet(addr)
+	w.LoadFromUTXOSet(utxoSet, nil)
+	return priv, w, utxoSet
+}
+
+func TestCreateTransactionPaysFeeRate(t *testing.T) {
+	priv, w, utxoSet := fundedWallet(t, 100_000)
+	mempool := NewInMemoryMempool()
+	to := "0102030405060708090a0b0c0d0e0f1011121314"
+
+	tx, fee, err := CreateTransaction(priv, w.Address, to, 10_000, 3, utxoSet, mempool, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tx.Vout) != 2 {
+		t.Fatalf("expected change output, got %d outputs", len(tx.Vout))
+	}
+	if want := int64(3 * tx.Size()); fee != want {
+		t.Fatalf("fee = %d, want feeRate*size = %d", fee, want)
+	}
+	if in, out := int64(100_000), tx.Vout[0].Value+tx.Vout[1].Value; in-out != fee {
+		t.Fatalf("in-out = %d, reported fee %d", in-out, fee)
+	}
+	if !VerifyForMempool(&tx, utxoSet, mempool) {
+		t.Fatal("created tx does not verify")
+	}
+}
+
+func TestCreateTransactionDropsDustChange(t *testing.T) {
+	// leftover after amount+fee is below DustThreshold
+	amount := int64(10_000)
+	priv, w, utxoSet := fundedWallet(t, amount+300+DustThreshold/2)
+	mempool := NewInMemoryMempool()
+
+	tx, fee, err := CreateTransaction(priv, w.Address, "0102030405060708090a0b0c0d0e0f1011121314", amount, 1, utxoSet, mempool, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vout) != 1 {
+		t.Fatalf("dust change kept: %d outputs", len(tx.Vout))
+	}
+	if fee != 300+DustThreshold/2 {
+		t.Fatalf("fee = %d", fee)
+	}
+}
+
+func TestCreateTransactionInsufficientForFee(t *testing.T) {
+	priv, w, utxoSet := fundedWallet(t, 10_050)
+	_, _, err := CreateTransaction(priv, w.Address, "0102030405060708090a0b0c0d0e0f1011121314", 10_000, 1, utxoSet, NewInMemoryMempool(), w)
+	if err == nil {
+		t.Fatal("expected insufficient funds once fee is included")
+	}
+}
+
+func TestLoadFromUTXOSetOrdersByConfirmation(t *testing.T) {
+	_, pub := NewKeyPair()
+	addr := AddressFromPub(pub)
+	utxoSet := NewUTXOSet()
+
+	// txids sort opposite to the order they were confirmed in
+	txs := make([]Transaction, 3)
+	for i := range txs {
+		txs[i] = Transaction{Txid: fmt.Sprintf("%064x", 3-i)}
+		if err := utxoSet.Put(txs[i].Txid, 0, VOUT{Value: 1000, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unknown := fmt.Sprintf("%064x", 0)
+	if err := utxoSet.Put(unknown, 0, VOUT{Value: 1000, ScriptPubKey: MakeP2PKHScriptPubKey(addr)}); err != nil {
+		t.Fatal(err)
+	}
+	chain := &Blockchain{Blocks: []*Block{
+		{Height: 0},
+		{Height: 1, Transactions: []Transaction{{Txid: "coinbase"}, txs[0], txs[1]}},
+		{Height: 2, Transactions: []Transaction{txs[2]}},
+	}}
+
+	w := NewWallet(addr)
+	w.LoadFromUTXOSet(utxoSet, chain)
+
+	var got []string
+	for _, u := range w.GetSpendableUTXOs(NewInMemoryMempool()) {
+		got = append(got, u.Txid)
+	}
+	want := []string{txs[0].Txid, txs[1].Txid, txs[2].Txid, unknown}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("receive order %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+// utxotool inspects and upgrades the node's Badger database.
+//
+//	go run ./cmd/utxotool [-db ./data/utxo] version
+//	go run ./cmd/utxotool [-db ./data/utxo] migrate
+//	go run ./cmd/utxotool [-db ./data/utxo] reindex
+//	go run ./cmd/utxotool [-db ./data/utxo] stats
+//
+// Stop the node first: Badger allows one process per directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	model "project/Model"
+	storage "project/storage"
+)
+
+func main() {
+	dbPath := flag.String("db", "./data/utxo", "badger directory")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: utxotool [-db path] version|migrate|reindex|stats")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := storage.OpenBadger(*dbPath)
+	if err != nil {
+		log.Fatal("Open Badger failed:", err)
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "version":
+		version, ok, err := model.ReadSchemaVersion(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			fmt.Println("schema version: none (empty or pre-versioning database)")
+		} else {
+			fmt.Println("schema version:", version)
+		}
+		fmt.Println("this build:", model.UTXOSchemaVersion)
+
+	case "migrate":
+		stats, err := model.MigrateSchema(db)
+		if err != nil {
+			log.Fatal("migrate failed:", err)
+		}
+		fmt.Printf("migrated schema %d -> %d: %d utxos (%d v1 binary, %d json, %d legacy values, %d bare keys)\n",
+			stats.FromVersion, model.UTXOSchemaVersion, stats.Rewritten(),
+			stats.Binary, stats.JSON, stats.LegacyVOUT, stats.Moved)
+		if stats.BytesBefore > 0 {
+			fmt.Printf("utxo entries: %d -> %d bytes (%.1f%% smaller)\n", stats.BytesBefore, stats.BytesAfter,
+				100*(1-float64(stats.BytesAfter)/float64(stats.BytesBefore)))
+		}
+		fmt.Printf("address index: %d entries (%d added, %d stale removed)\n",
+			stats.Addresses.Entries, stats.Addresses.Added, stats.Addresses.Removed)
+
+	case "reindex":
+		stats, err := model.ReindexAddresses(db)
+		if err != nil {
+			log.Fatal("reindex failed:", err)
+		}
+		fmt.Printf("address index: %d utxos, %d entries (%d added, %d stale removed)\n",
+			stats.Outputs, stats.Entries, stats.Added, stats.Removed)
+
+	case "stats":
+		stored, err := model.ReadUTXOStats(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("stored:", stored)
+
+		disk, err := model.NewBadgerUTXOSetFromDB(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scanned, err := disk.Info()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if scanned != stored {
+			fmt.Println("scan:  ", scanned)
+			log.Fatal("stored stats do not match the UTXO entries")
+		}
+		fmt.Println("full scan matches")
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -52,3 +52,42 @@ func ParseUTXOKey(b []byte) (string, int) {
 	idx, _ := strconv.Atoi(parts[2])
 	return parts[1], idx
 }
+
+var ErrNonCanonicalVarInt = errors.New("non-canonical varint")
+
+// ReadVarInt reads a varint written by WriteVarInt and rejects non-minimal encodings
+func ReadVarInt(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var n, min uint64
+	switch prefix {
+	case 0xfd:
+		var v uint16
+		if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+			return 0, err
+		}
+		n, min = uint64(v), 0xfd
+	case 0xfe:
+		var v uint32
+		if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+			return 0, err
+		}
+		n, min = uint64(v), 0x10000
+	case 0xff:
+		var v uint64
+		if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+			return 0, err
+		}
+		n, min = v, 0x100000000
+	default:
+		return uint64(prefix), nil
+	}
+
+	if n < min {
+		return 0, ErrNonCanonicalVarInt
+	}
+	return n, nil
+}
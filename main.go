@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"runtime"
@@ -12,6 +13,18 @@ import (
 )
 
 func main() {
+	utxoBackend := flag.String("utxo", model.UTXOBackendMemory,
+		"UTXO set backend: memory, cached (LRU over Badger) or disk")
+	utxoCacheMB := flag.Int64("utxo-cache-mb", model.DefaultUTXOCacheBytes>>20,
+		"memory budget of the cached UTXO backend, in MB")
+	mempoolBackend := flag.String("mempool", "memory", "mempool backend: memory or redis")
+	redisAddr := flag.String("redis", "localhost:6379", "redis address for -mempool redis")
+	mempoolMB := flag.Int("mempool-mb", model.DefaultMempoolMaxBytes>>20,
+		"memory mempool size limit in MB; lowest fee-rate packages are evicted beyond it")
+	mempoolExpiry := flag.Duration("mempool-expiry", model.DefaultMempoolExpiry,
+		"memory mempool: drop txs older than this")
+	flag.Parse()
+
 	// -------------------------------
 	// 0) CPU
 	// -------------------------------
@@ -29,21 +42,52 @@ func main() {
 	defer db.Close()
 
 	// -------------------------------
-	// 2) INIT IN-MEMORY STATE
+	// 2) INIT STATE (chain reloaded from DB)
 	// -------------------------------
-	utxoSet := model.NewUTXOSet()
-	mempool := model.NewInMemoryMempool()
-	blockchain := model.NewBlockchain()
+	params := model.DefaultChainParams()
+	params.TargetSpacing = mining.BlockInterval
+	blockchain, err := model.NewBlockchain(params, db)
+	if err != nil {
+		log.Fatal("Load blockchain failed:", err)
+	}
 	walletManager := model.NewWalletManager()
 
 	// -------------------------------
 	// 3) LOAD UTXO FROM DB
 	// -------------------------------
-	if err := utxoSet.LoadFromBadger(db); err != nil {
+	utxoSet, err := model.NewUTXOProvider(*utxoBackend, db, *utxoCacheMB<<20)
+	if err != nil {
 		log.Fatal("Load UTXO from DB failed:", err)
 	}
+	defer utxoSet.Close()
 
-	fmt.Println("Loaded confirmed UTXOs from DB")
+	if err := blockchain.RecoverUTXOSet(utxoSet); err != nil {
+		log.Fatal("Recover UTXO set failed:", err)
+	}
+
+	fmt.Println("Loaded confirmed UTXOs from DB, backend =", *utxoBackend)
+
+	var mempool model.Mempool
+	switch *mempoolBackend {
+	case "memory":
+		mempool = model.NewInMemoryMempoolWithLimits(model.MempoolLimits{
+			MaxBytes: *mempoolMB << 20,
+			Expiry:   *mempoolExpiry,
+		})
+	case "redis":
+		rm := model.NewRedisMempool(*redisAddr)
+		if err := rm.Ping(); err != nil {
+			log.Fatal("Connect redis failed:", err)
+		}
+		defer rm.Close()
+		model.RevalidateMempool(rm, utxoSet) // pool may predate this chain state
+		mempool = rm
+	default:
+		log.Fatal("unknown mempool backend: ", *mempoolBackend)
+	}
+	blockchain.TxOrphans = model.NewOrphanPool(model.DefaultOrphanLimits())
+	fmt.Println("Mempool backend =", *mempoolBackend, "| txs =", mempool.Size())
+	fmt.Println("Loaded chain: height =", blockchain.Tip().Height)
 
 	// -------------------------------
 	// 4) CREATE KEYS
@@ -58,59 +102,20 @@ func main() {
 	fmt.Println("Bob   Address:", bobAddr)
 
 	// -------------------------------
-	// 5) GENESIS (ONLY IF DB EMPTY)
+	// 5) FUND BOB WITH A COINBASE (mined block pays subsidy to Bob)
 	// -------------------------------
-	if len(utxoSet.FindUTXOsByAddress(aliceAddr)) == 0 &&
-		len(utxoSet.FindUTXOsByAddress(bobAddr)) == 0 {
-
-		fmt.Println("\n== Insert genesis UTXOs ==")
-
-		genesisAlice := model.Transaction{
-			Version: 1,
-			Vin:     nil,
-			Vout: []model.VOUT{
-				{
-					Value:        500000,
-					N:            0,
-					ScriptPubKey: model.MakeP2PKHScriptPubKey(aliceAddr),
-				},
-			},
-		}
-		genesisAlice.Txid = genesisAlice.ComputeTxID()
+	miner := mining.NewMiner(blockchain, mempool, utxoSet, db, bobAddr)
 
-		for _, out := range genesisAlice.Vout {
-			if err := utxoSet.PutWithDB(db, genesisAlice.Txid, out.N, out); err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		genesisBob := model.Transaction{
-			Version: 1,
-			Vin:     nil,
-			Vout: []model.VOUT{
-				{
-					Value:        10000000,
-					N:            0,
-					ScriptPubKey: model.MakeP2PKHScriptPubKey(bobAddr),
-				},
-			},
-		}
-		genesisBob.Txid = genesisBob.ComputeTxID()
-
-		for _, out := range genesisBob.Vout {
-			if err := utxoSet.PutWithDB(db, genesisBob.Txid, out.N, out); err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		fmt.Println("Genesis inserted")
+	fmt.Println("\n== Mine funding block ==")
+	if _, err := miner.MineBlock(nil); err != nil {
+		log.Fatal("Mine funding block failed:", err)
 	}
 
 	// -------------------------------
 	// 6) INIT WALLETS
 	// -------------------------------
-	aliceWallet := walletManager.GetWallet(aliceAddr, utxoSet)
-	bobWallet := walletManager.GetWallet(bobAddr, utxoSet)
+	aliceWallet := walletManager.GetWallet(aliceAddr, utxoSet, blockchain)
+	bobWallet := walletManager.GetWallet(bobAddr, utxoSet, blockchain)
 
 	fmt.Println("Alice spendable:", len(aliceWallet.GetSpendableUTXOs(mempool)))
 	fmt.Println("Bob   spendable:", len(bobWallet.GetSpendableUTXOs(mempool)))
@@ -120,12 +125,15 @@ func main() {
 	// -------------------------------
 	fmt.Println("\n== Stress test: Bob → Alice (10,000 txs) ==")
 
+	const feeRate = 1 // units per byte
+
 	for i := 0; i < 30000; i++ {
-		tx, err := model.CreateTransaction(
+		tx, _, err := model.CreateTransaction(
 			bobPriv,
 			bobAddr,
 			aliceAddr,
 			1,
+			feeRate,
 			utxoSet,
 			mempool,
 			bobWallet,
@@ -135,13 +143,8 @@ func main() {
 			break
 		}
 
-		if !model.VerifyForMempool(&tx, utxoSet, mempool) {
-			fmt.Printf("[tx %d] verify failed\n", i)
-			break
-		}
-
-		if err := mempool.AddTransaction(&tx); err != nil {
-			fmt.Printf("[tx %d] mempool add failed: %v\n", i, err)
+		if _, err := model.ProcessTransaction(&tx, utxoSet, mempool, blockchain.TxOrphans); err != nil {
+			fmt.Printf("[tx %d] rejected: %v\n", i, err)
 			break
 		}
 
@@ -161,7 +164,6 @@ func main() {
 	// 8) START MINER (WITH DB)
 	// -------------------------------
 	fmt.Println("\n== Starting miner ==")
-	miner := mining.NewMiner(blockchain, mempool, utxoSet, db)
 	miner.StartMiner()
 
 	// -------------------------------
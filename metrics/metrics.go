@@ -100,6 +100,60 @@ var (
 		Help:      "Time spent verifying ed25519 signatures",
 		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 15),
 	})
+
+	SigCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "blockchain",
+			Subsystem: "crypto",
+			Name:      "sigcache_lookups_total",
+			Help:      "Signature cache lookups by result (hit/miss)",
+		},
+		[]string{"result"},
+	)
+)
+
+// ===============================
+// UTXO CACHE (CachedUTXOSet)
+// ===============================
+var (
+	UTXOCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "blockchain",
+			Subsystem: "utxo_cache",
+			Name:      "lookups_total",
+			Help:      "UTXO cache lookups by result (hit/miss)",
+		},
+		[]string{"result"},
+	)
+
+	UTXOCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "blockchain",
+		Subsystem: "utxo_cache",
+		Name:      "evictions_total",
+		Help:      "Clean UTXO cache entries evicted to stay within the memory budget",
+	})
+
+	UTXOCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "blockchain",
+		Subsystem: "utxo_cache",
+		Name:      "bytes",
+		Help:      "Estimated memory used by the UTXO cache",
+	})
+
+	UTXOCacheDirty = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "blockchain",
+		Subsystem: "utxo_cache",
+		Name:      "dirty_entries",
+		Help:      "UTXO cache entries not yet flushed to disk",
+	})
+
+	UTXOCacheFlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "blockchain",
+		Subsystem: "utxo_cache",
+		Name:      "flush_duration_ms",
+		Help:      "Time spent writing dirty UTXO cache entries to Badger",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 15),
+	})
 )
 
 // ===============================
@@ -140,6 +194,13 @@ func Register() {
 
 		TxSignDuration,
 		TxVerifySigDuration,
+		SigCacheLookups,
+
+		UTXOCacheLookups,
+		UTXOCacheEvictions,
+		UTXOCacheBytes,
+		UTXOCacheDirty,
+		UTXOCacheFlushDuration,
 
 		RedisGetDuration,
 		RedisPipelineDuration,
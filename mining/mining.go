@@ -17,26 +17,31 @@ const (
 
 type Miner struct {
 	Blockchain *model.Blockchain
-	Mempool    *model.InMemoryMempool
-	UTXOSet    *model.UTXOSet
+	Mempool    model.Mempool
+	UTXOSet    model.UTXOProvider
 	DB         *badger.DB
 
+	// CoinbaseAddr receives block subsidy + fees
+	CoinbaseAddr string
+
 	stopCh chan struct{}
 }
 
 func NewMiner(
 	bc *model.Blockchain,
-	mempool *model.InMemoryMempool,
-	utxoSet *model.UTXOSet,
+	mempool model.Mempool,
+	utxoSet model.UTXOProvider,
 	db *badger.DB,
+	coinbaseAddr string,
 
 ) *Miner {
 	return &Miner{
-		Blockchain: bc,
-		Mempool:    mempool,
-		UTXOSet:    utxoSet,
-		DB:         db,
-		stopCh:     make(chan struct{}),
+		Blockchain:   bc,
+		Mempool:      mempool,
+		UTXOSet:      utxoSet,
+		DB:           db,
+		CoinbaseAddr: coinbaseAddr,
+		stopCh:       make(chan struct{}),
 	}
 }
 
@@ -71,9 +76,7 @@ func (m *Miner) StartMiner() {
 					continue // Đợi thêm tx
 				}
 
-				// 3️⃣ build block
-				t1 := time.Now()
-				// Collect transactions from mempool
+				// 2️⃣ collect transactions from mempool
 				var txs []model.Transaction
 				for _, txid := range snap.TxIDs {
 					tx := m.Mempool.GetTransaction(txid)
@@ -83,66 +86,26 @@ func (m *Miner) StartMiner() {
 					txs = append(txs, *tx)
 				}
 
-				// Get previous block hash
-				prevBlock := m.Blockchain.Blocks[len(m.Blockchain.Blocks)-1]
-				block := model.NewBlock(txs, prevBlock.Hash)
-				tBuild := time.Since(t1)
-
 				fmt.Printf(
-					"[miner] building block with %d txs (%d bytes)\n",
-					len(block.Transactions),
+					"[miner] building block with %d txs (%d bytes, fees=%d)\n",
+					len(txs),
 					snap.Size,
+					snap.Fees,
 				)
 
-				// 4️⃣ verify merkle root
-				t2 := time.Now()
-				if err := model.VerifyMerkleRoot(block); err != nil {
-					fmt.Printf("[miner] merkle verification failed: %v\n", err)
-					blockStart = time.Now()
-					continue
-				}
-				tMerkle := time.Since(t2)
-
-				// 5️⃣ verify block using VerifyBlock (proper verification)
-				t3 := time.Now()
-				if err := model.VerifyBlock(block, m.UTXOSet); err != nil {
-					fmt.Printf("[miner] block verification failed: %v\n", err)
-					blockStart = time.Now()
-					continue
-				}
-				tVerify := time.Since(t3)
-
-				// 6️⃣ commit block
-				t4 := time.Now()
-				if err := model.CommitBlock(block, m.UTXOSet, m.DB); err != nil {
-					fmt.Println("[miner] commit block failed:", err)
+				block, err := m.MineBlock(txs)
+				if err != nil {
+					fmt.Println("[miner]", err)
 					blockStart = time.Now()
 					continue
 				}
-				tCommit := time.Since(t4)
-
-				// Add block to blockchain
-				m.Blockchain.Blocks = append(m.Blockchain.Blocks, block)
-
-				// Tính duration trước khi cleanup
-				duration := time.Since(blockStart)
-
-				// 7️⃣ remove committed txs from mempool
-				t5 := time.Now()
-				for _, tx := range block.Transactions {
-					m.Mempool.RemoveTransaction(&tx)
-				}
-				tCleanup := time.Since(t5)
 
 				fmt.Printf(
-					"[miner] ✓ block committed | height=%d | txs=%d | total=%v (excluding cleanup)\n",
-					len(m.Blockchain.Blocks),
+					"[miner] ✓ block committed | height=%d | txs=%d | total=%v (snapshot=%v)\n",
+					block.Height,
 					len(block.Transactions),
-					duration,
-				)
-				fmt.Printf(
-					"  timing: snapshot=%v build=%v merkle=%v verify=%v commit=%v cleanup=%v\n",
-					tSnapshot, tBuild, tMerkle, tVerify, tCommit, tCleanup,
+					time.Since(blockStart),
+					tSnapshot,
 				)
 
 				blockStart = time.Now()
@@ -151,6 +114,73 @@ func (m *Miner) StartMiner() {
 	}()
 }
 
+// MineBlock builds a block on top of the current tip: coinbase (subsidy + fees
+// to CoinbaseAddr) followed by txs, and submits it via Blockchain.ProcessBlock.
+func (m *Miner) MineBlock(txs []model.Transaction) (*model.Block, error) {
+	// 1️⃣ build block
+	t1 := time.Now()
+	prevBlock := m.Blockchain.Tip()
+	height := prevBlock.Height + 1
+
+	fees, err := model.CalcFees(txs, m.UTXOSet)
+	if err != nil {
+		return nil, fmt.Errorf("fee calculation failed: %v", err)
+	}
+	reward := m.Blockchain.Params.BlockSubsidy(height) + fees
+	coinbase := model.NewCoinbaseTx(height, m.CoinbaseAddr, reward, 0)
+
+	bits, err := m.Blockchain.NextBits()
+	if err != nil {
+		return nil, fmt.Errorf("difficulty: %v", err)
+	}
+
+	block := model.NewBlock(append([]model.Transaction{coinbase}, txs...), prevBlock.Hash, height)
+	block.Bits = bits
+	block.Timestamp = m.Blockchain.NextTimestamp()
+	tBuild := time.Since(t1)
+
+	// 2️⃣ proof of work: header nonce, then coinbase extra nonce
+	t2 := time.Now()
+	for extraNonce := uint64(1); !block.Solve(m.stopCh); extraNonce++ {
+		select {
+		case <-m.stopCh:
+			return nil, fmt.Errorf("mining interrupted")
+		default:
+		}
+		block.Transactions[0] = model.NewCoinbaseTx(height, m.CoinbaseAddr, reward, extraNonce)
+		block.MerkleRoot = model.ComputeMerkleRoot(block.Transactions)
+		block.Timestamp = m.Blockchain.NextTimestamp()
+	}
+	tPow := time.Since(t2)
+
+	// 3️⃣ verify merkle root
+	t3 := time.Now()
+	if err := model.VerifyMerkleRoot(block); err != nil {
+		return nil, fmt.Errorf("merkle verification failed: %v", err)
+	}
+	tMerkle := time.Since(t3)
+
+	// 4️⃣ hand the block to the chain: context + VerifyBlock + commit, then
+	// mempool cleanup. A competing tip may have arrived meanwhile, in which
+	// case the block is kept on a side branch.
+	t4 := time.Now()
+	isTip, err := m.Blockchain.ProcessBlock(block, m.UTXOSet, m.Mempool)
+	if err != nil {
+		return nil, fmt.Errorf("block rejected: %v", err)
+	}
+	if !isTip {
+		return nil, fmt.Errorf("block %x stored on a side branch", block.Hash)
+	}
+	tProcess := time.Since(t4)
+
+	fmt.Printf(
+		"  timing: build=%v pow=%v merkle=%v process=%v | nonce=%d reward=%d (fees=%d)\n",
+		tBuild, tPow, tMerkle, tProcess, block.Nonce, reward, fees,
+	)
+
+	return block, nil
+}
+
 func (m *Miner) Stop() {
 	close(m.stopCh)
 }